@@ -4,18 +4,23 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 	"github.com/rs/xid"
 
+	"microapi/internal/auth"
 	"microapi/internal/config"
 	"microapi/internal/database"
 	"microapi/internal/middleware"
 	"microapi/internal/query"
+	"microapi/internal/rules"
+	"microapi/internal/txn"
 )
 
 type ListSetsArgs struct{}
@@ -30,6 +35,7 @@ type GetDocumentArgs struct {
 	Set        string `json:"set"`
 	Collection string `json:"collection"`
 	ID         string `json:"id"`
+	Fields     string `json:"fields" jsonschema:"comma-separated JSON paths to return (e.g. title,user.name)"`
 }
 
 type UpdateDocumentArgs struct {
@@ -49,12 +55,40 @@ type QueryCollectionArgs struct {
 	Set         string `json:"set"`
 	Collection  string `json:"collection"`
 	Where       string `json:"where" jsonschema:"JSON string representing filters"`
-	OrderBy     string `json:"order_by"`
+	Search      string `json:"search" jsonschema:"full-text search across the collection's indexed fields; ranked by relevance unless order_by is set"`
+	Snippet     bool   `json:"snippet" jsonschema:"add a highlighted snippet to each search result"`
+	OrderBy     string `json:"order_by" jsonschema:"comma-separated fields, '-' prefix for descending (e.g. -priority,created_at)"`
 	Limit       int    `json:"limit"`
 	Offset      int    `json:"offset"`
+	Fields      string `json:"fields" jsonschema:"comma-separated JSON paths to return (e.g. title,user.name)"`
+	Cursor      string `json:"cursor" jsonschema:"opaque next_cursor token from a previous page"`
 	IncludeMeta *bool  `json:"include_meta" jsonschema:"include _meta in results (default true)"`
 }
 
+type AggregateCollectionArgs struct {
+	Set        string `json:"set"`
+	Collection string `json:"collection"`
+	Where      string `json:"where" jsonschema:"JSON string representing filters"`
+	GroupBy    string `json:"group_by" jsonschema:"comma-separated JSON paths to group by"`
+	Aggs       string `json:"aggs" jsonschema:"comma-separated aggregates (e.g. count,sum:price,avg:price); defaults to count"`
+}
+
+type KNNSearchArgs struct {
+	Set         string    `json:"set"`
+	Collection  string    `json:"collection"`
+	Vector      []float64 `json:"vector" jsonschema:"query vector, with as many dimensions as the index"`
+	K           int       `json:"k" jsonschema:"number of neighbours to return (default 10)"`
+	Metric      string    `json:"metric" jsonschema:"distance metric: cosine (default), dot or l2"`
+	Path        string    `json:"path" jsonschema:"vector field to search; only needed when the collection has several vector indexes"`
+	Where       string    `json:"where" jsonschema:"JSON string of where filters applied before ranking"`
+	Fields      string    `json:"fields" jsonschema:"comma-separated JSON paths to return (e.g. title,user.name)"`
+	IncludeMeta *bool     `json:"include_meta" jsonschema:"include _meta in results (default true)"`
+}
+
+type TransactionArgs struct {
+	Operations []txn.Operation `json:"operations" jsonschema:"ordered operations: {op, set, collection, id?, ref?, data?, if_match?}; \"$ref:<name>\" stands for the id created by an earlier op with that ref"`
+}
+
 func main() {
 	// Structured logger
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
@@ -86,6 +120,9 @@ func main() {
 	mcp.AddTool(server, &mcp.Tool{Name: "update_document", Description: "Patch fields of a document by id"}, updateDocumentTool(db))
 	mcp.AddTool(server, &mcp.Tool{Name: "delete_document", Description: "Delete a document by id"}, deleteDocumentTool(db))
 	mcp.AddTool(server, &mcp.Tool{Name: "query_collection", Description: "Query a collection with optional where/order/limit/offset"}, queryCollectionTool(db))
+	mcp.AddTool(server, &mcp.Tool{Name: "aggregate_collection", Description: "Compute count/sum/avg/min/max/count_distinct over a collection, optionally filtered and grouped"}, aggregateCollectionTool(db))
+	mcp.AddTool(server, &mcp.Tool{Name: "knn_search", Description: "Find the documents whose embedding is nearest to a query vector, using the collection's vector index; nearest first, with the distance in _knn"}, knnSearchTool(db))
+	mcp.AddTool(server, &mcp.Tool{Name: "transaction", Description: "Run create/replace/patch/delete operations across sets and collections atomically; all are rolled back on the first failure"}, transactionTool(db))
 
 	if err := server.Run(context.Background(), mcp.NewStdioTransport()); err != nil {
 		log.Fatal(err)
@@ -134,15 +171,22 @@ func createDocumentTool(db *sql.DB) func(context.Context, *mcp.ServerSession, *m
 				return errorResult("fields starting with '_' are reserved"), nil
 			}
 		}
+		ck, err := checker(db, args.Set, args.Collection)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		if err := ck.Check(db, rules.Create, nil, args.Document); err != nil {
+			return errorResult(err.Error()), nil
+		}
 		id := xid.New().String()
 		now := time.Now().Unix()
 		b, _ := json.Marshal(args.Document)
-		_, err := db.Exec("INSERT INTO "+tableName(args.Set)+" (id, collection, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?)", id, args.Collection, string(b), now, now)
+		_, err = db.Exec("INSERT INTO "+tableName(args.Set)+" (id, collection, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?)", id, args.Collection, string(b), now, now)
 		if err != nil {
 			return errorResult(err.Error()), nil
 		}
 		res := cloneMap(args.Document)
-		res["_meta"] = map[string]any{"id": id, "created_at": now, "updated_at": now}
+		res["_meta"] = map[string]any{"id": id, "created_at": now, "updated_at": now, "version": 1}
 		return &mcp.CallToolResultFor[any]{StructuredContent: res}, nil
 	}
 }
@@ -159,9 +203,29 @@ func getDocumentTool(db *sql.DB) func(context.Context, *mcp.ServerSession, *mcp.
 		if err := database.EnsureSetTable(db, args.Set); err != nil {
 			return errorResult(err.Error()), nil
 		}
+		fields, err := query.ParseFields(args.Fields)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		ck, err := checker(db, args.Set, args.Collection)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		restrict, err := ck.Filter(rules.Read)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		sqlStr := "SELECT " + query.DataExpr(fields) + ", created_at, updated_at, version FROM " + tableName(args.Set) + " WHERE id = ? AND collection = ?"
+		sqlArgs := []any{args.ID, args.Collection}
+		if restrict != nil {
+			for _, c := range restrict.Conds {
+				sqlStr += " AND " + c.SQL
+				sqlArgs = append(sqlArgs, c.Args...)
+			}
+		}
 		var dataStr string
-		var created, updated int64
-		err := db.QueryRow("SELECT data, created_at, updated_at FROM "+tableName(args.Set)+" WHERE id = ? AND collection = ?", args.ID, args.Collection).Scan(&dataStr, &created, &updated)
+		var created, updated, version int64
+		err = db.QueryRow(sqlStr, sqlArgs...).Scan(&dataStr, &created, &updated, &version)
 		if err == sql.ErrNoRows {
 			return errorResult("not found"), nil
 		}
@@ -173,7 +237,7 @@ func getDocumentTool(db *sql.DB) func(context.Context, *mcp.ServerSession, *mcp.
 		if m == nil {
 			m = map[string]any{}
 		}
-		m["_meta"] = map[string]any{"id": args.ID, "created_at": created, "updated_at": updated}
+		m["_meta"] = map[string]any{"id": args.ID, "created_at": created, "updated_at": updated, "version": version}
 		return &mcp.CallToolResultFor[any]{StructuredContent: m}, nil
 	}
 }
@@ -208,14 +272,16 @@ func updateDocumentTool(db *sql.DB) func(context.Context, *mcp.ServerSession, *m
 		}
 		// load existing
 		var dataStr string
-		err := db.QueryRow("SELECT data FROM "+tableName(args.Set)+" WHERE id = ? AND collection = ?", args.ID, args.Collection).Scan(&dataStr)
+		var current int64
+		err := db.QueryRow("SELECT data, version FROM "+tableName(args.Set)+" WHERE id = ? AND collection = ?", args.ID, args.Collection).Scan(&dataStr, &current)
 		if err == sql.ErrNoRows {
 			return errorResult("not found"), nil
 		}
 		if err != nil {
 			return errorResult(err.Error()), nil
 		}
-		var m map[string]any
+		var old, m map[string]any
+		_ = json.Unmarshal([]byte(dataStr), &old)
 		_ = json.Unmarshal([]byte(dataStr), &m)
 		if m == nil {
 			m = map[string]any{}
@@ -223,17 +289,28 @@ func updateDocumentTool(db *sql.DB) func(context.Context, *mcp.ServerSession, *m
 		for k, v := range args.Patch {
 			m[k] = v
 		}
+		ck, err := checker(db, args.Set, args.Collection)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		if err := ck.Check(db, rules.Update, old, m); err != nil {
+			return errorResult(err.Error()), nil
+		}
 		now := time.Now().Unix()
-		_, err = db.Exec("UPDATE "+tableName(args.Set)+" SET data = ?, updated_at = ? WHERE id = ? AND collection = ?", mustJSON(m), now, args.ID, args.Collection)
+		// only write over the version the rule was checked against
+		res, err := db.Exec("UPDATE "+tableName(args.Set)+" SET data = ?, updated_at = ?, version = version + 1 WHERE id = ? AND collection = ? AND version = ?", mustJSON(m), now, args.ID, args.Collection, current)
 		if err != nil {
 			return errorResult(err.Error()), nil
 		}
-		var created, updated int64
-		err = db.QueryRow("SELECT created_at, updated_at FROM "+tableName(args.Set)+" WHERE id = ? AND collection = ?", args.ID, args.Collection).Scan(&created, &updated)
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errorResult("document was modified concurrently, retry"), nil
+		}
+		var created, updated, version int64
+		err = db.QueryRow("SELECT created_at, updated_at, version FROM "+tableName(args.Set)+" WHERE id = ? AND collection = ?", args.ID, args.Collection).Scan(&created, &updated, &version)
 		if err != nil {
 			return errorResult(err.Error()), nil
 		}
-		m["_meta"] = map[string]any{"id": args.ID, "created_at": created, "updated_at": updated}
+		m["_meta"] = map[string]any{"id": args.ID, "created_at": created, "updated_at": updated, "version": version}
 		return &mcp.CallToolResultFor[any]{StructuredContent: m}, nil
 	}
 }
@@ -247,8 +324,36 @@ func deleteDocumentTool(db *sql.DB) func(context.Context, *mcp.ServerSession, *m
 		if err := middleware.ValidateNames(args.Set, args.Collection); err != nil {
 			return errorResult(err.Error()), nil
 		}
-		_, _ = db.Exec("DELETE FROM "+tableName(args.Set)+" WHERE id = ? AND collection = ?", args.ID, args.Collection)
-		return &mcp.CallToolResultFor[any]{StructuredContent: map[string]any{"deleted": args.ID}}, nil
+		ck, err := checker(db, args.Set, args.Collection)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		restrict, err := ck.Filter(rules.Delete)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		sqlStr := "DELETE FROM " + tableName(args.Set) + " WHERE id = ? AND collection = ?"
+		sqlArgs := []any{args.ID, args.Collection}
+		if restrict != nil {
+			for _, c := range restrict.Conds {
+				sqlStr += " AND " + c.SQL
+				sqlArgs = append(sqlArgs, c.Args...)
+			}
+		}
+		res, err := db.Exec(sqlStr, sqlArgs...)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 {
+			var exists bool
+			_ = db.QueryRow("SELECT EXISTS(SELECT 1 FROM "+tableName(args.Set)+" WHERE id = ? AND collection = ?)", args.ID, args.Collection).Scan(&exists)
+			if exists && restrict != nil {
+				return errorResult(rules.Denied(rules.Delete).Error()), nil
+			}
+			return errorResult("not found"), nil
+		}
+		return &mcp.CallToolResultFor[any]{StructuredContent: map[string]any{"deleted": args.ID, "rows_affected": n}}, nil
 	}
 }
 
@@ -264,45 +369,251 @@ func queryCollectionTool(db *sql.DB) func(context.Context, *mcp.ServerSession, *
 		if err := database.EnsureSetTable(db, args.Set); err != nil {
 			return errorResult(err.Error()), nil
 		}
-		pw, err := query.ParseWhere(args.Where)
+		pw, err := query.ParseWhereWith(args.Where, database.Indexes(db, args.Set, args.Collection))
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		if args.Search != "" {
+			sw, err := query.Search(args.Search, database.Indexes(db, args.Set, args.Collection))
+			if err != nil {
+				return errorResult(err.Error()), nil
+			}
+			pw = pw.And(sw)
+		}
+		ck, err := checker(db, args.Set, args.Collection)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		restrict, err := ck.Filter(rules.Read)
 		if err != nil {
 			return errorResult(err.Error()), nil
 		}
+		pw = pw.And(restrict)
+		orderBy, err := query.ParseOrderBy(args.OrderBy)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		fields, err := query.ParseFields(args.Fields)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		var after *query.Cursor
+		if args.Cursor != "" {
+			if args.Offset > 0 {
+				return errorResult("cursor cannot be combined with offset"), nil
+			}
+			if after, err = query.DecodeCursor(args.Cursor, orderBy); err != nil {
+				return errorResult(err.Error()), nil
+			}
+		}
 		// total count (ignore limit/offset)
 		countSQL, countArgs := query.BuildCount(query.BuildOpts{Set: args.Set, Collection: args.Collection, Where: pw})
 		var total int64
 		_ = db.QueryRow(countSQL, countArgs...).Scan(&total)
-		sqlStr, sqlArgs := query.BuildSelect(query.BuildOpts{Set: args.Set, Collection: args.Collection, Where: pw, OrderBy: args.OrderBy, Limit: args.Limit, Offset: args.Offset})
+		opts := query.BuildOpts{Set: args.Set, Collection: args.Collection, Where: pw, OrderBy: orderBy, Fields: fields, Limit: args.Limit, Offset: args.Offset, After: after, Snippets: args.Snippet}
+		if after != nil && opts.Ranked() {
+			return errorResult("cursor cannot be used with results ranked by relevance or distance: page with offset or set order_by"), nil
+		}
+		sqlStr, sqlArgs := query.BuildSelect(opts)
 		rows, err := db.Query(sqlStr, sqlArgs...)
 		if err != nil {
 			return errorResult(err.Error()), nil
 		}
 		defer rows.Close()
 		var results []map[string]any
+		keys := opts.KeyColumns()
 		for rows.Next() {
 			var id, dataStr string
-			var created, updated int64
-			if err := rows.Scan(&id, &dataStr, &created, &updated); err == nil {
-				var m map[string]any
-				_ = json.Unmarshal([]byte(dataStr), &m)
-				includeMeta := true
-				if args.IncludeMeta != nil && !*args.IncludeMeta {
-					includeMeta = false
+			var created, updated, version int64
+			var distance sql.NullFloat64
+			if err := rows.Scan(append([]any{&id, &dataStr, &created, &updated, &version, &distance}, keys...)...); err != nil {
+				return errorResult(err.Error()), nil
+			}
+			var m map[string]any
+			_ = json.Unmarshal([]byte(dataStr), &m)
+			includeMeta := true
+			if args.IncludeMeta != nil && !*args.IncludeMeta {
+				includeMeta = false
+			}
+			if includeMeta {
+				if m == nil {
+					m = map[string]any{}
 				}
-				if includeMeta {
-					if m == nil {
-						m = map[string]any{}
-					}
-					m["_meta"] = map[string]any{"id": id, "created_at": created, "updated_at": updated}
+				meta := map[string]any{"id": id, "created_at": created, "updated_at": updated, "version": version}
+				if distance.Valid {
+					meta["distance"] = distance.Float64
 				}
-				results = append(results, m)
+				m["_meta"] = meta
 			}
+			results = append(results, m)
+		}
+		if err := rows.Err(); err != nil {
+			return errorResult(err.Error()), nil
 		}
 		// Return both results and total so clients can page
-		return &mcp.CallToolResultFor[any]{StructuredContent: map[string]any{"items": results, "total": total}}, nil
+		out := map[string]any{"items": results, "total": total}
+		next, err := opts.NextCursor(len(results), keys)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		if next != nil {
+			out["next_cursor"] = query.EncodeCursor(*next)
+		}
+		return &mcp.CallToolResultFor[any]{StructuredContent: out}, nil
 	}
 }
 
+func aggregateCollectionTool(db *sql.DB) func(context.Context, *mcp.ServerSession, *mcp.CallToolParamsFor[AggregateCollectionArgs]) (*mcp.CallToolResultFor[any], error) {
+	return func(ctx context.Context, _ *mcp.ServerSession, params *mcp.CallToolParamsFor[AggregateCollectionArgs]) (*mcp.CallToolResultFor[any], error) {
+		args := params.Arguments
+		if args.Set == "" || args.Collection == "" {
+			return errorResult("set and collection are required"), nil
+		}
+		if err := middleware.ValidateNames(args.Set, args.Collection); err != nil {
+			return errorResult(err.Error()), nil
+		}
+		if err := database.EnsureSetTable(db, args.Set); err != nil {
+			return errorResult(err.Error()), nil
+		}
+		pw, err := query.ParseWhereWith(args.Where, database.Indexes(db, args.Set, args.Collection))
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		ck, err := checker(db, args.Set, args.Collection)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		restrict, err := ck.Filter(rules.Read)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		pw = pw.And(restrict)
+		groups, err := query.ParseGroupBy(args.GroupBy)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		aggs, err := query.ParseAggregates(args.Aggs)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		opts := query.AggregateOpts{Set: args.Set, Collection: args.Collection, Where: pw, GroupBy: groups, Aggregates: aggs}
+		sqlStr, sqlArgs := query.BuildAggregate(opts)
+		rows, err := db.Query(sqlStr, sqlArgs...)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		defer rows.Close()
+		results, err := opts.Scan(rows)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		return &mcp.CallToolResultFor[any]{StructuredContent: map[string]any{"items": results}}, nil
+	}
+}
+
+func knnSearchTool(db *sql.DB) func(context.Context, *mcp.ServerSession, *mcp.CallToolParamsFor[KNNSearchArgs]) (*mcp.CallToolResultFor[any], error) {
+	return func(ctx context.Context, _ *mcp.ServerSession, params *mcp.CallToolParamsFor[KNNSearchArgs]) (*mcp.CallToolResultFor[any], error) {
+		args := params.Arguments
+		if args.Set == "" || args.Collection == "" {
+			return errorResult("set and collection are required"), nil
+		}
+		if err := middleware.ValidateNames(args.Set, args.Collection); err != nil {
+			return errorResult(err.Error()), nil
+		}
+		if err := database.EnsureSetTable(db, args.Set); err != nil {
+			return errorResult(err.Error()), nil
+		}
+		var path string
+		if args.Path != "" {
+			p, err := query.ParseFields(args.Path)
+			if err != nil || len(p) != 1 {
+				return errorResult(fmt.Sprintf("invalid path %q", args.Path)), nil
+			}
+			path = p[0]
+		}
+		idx, err := database.GetVectorIndex(db, args.Set, args.Collection, path)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		if idx.Status != "ready" {
+			return errorResult(fmt.Sprintf("vector index on %s is %s", strings.TrimPrefix(idx.Path, "$."), idx.Status)), nil
+		}
+		if len(args.Vector) != idx.Dimensions {
+			return errorResult(fmt.Sprintf("vector must have %d dimensions, got %d", idx.Dimensions, len(args.Vector))), nil
+		}
+		vec, err := database.EncodeVector(args.Vector)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		metric, err := query.ParseMetric(args.Metric)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		k := args.K
+		if k == 0 {
+			k = 10
+		}
+		if k < 1 || k > 1000 {
+			return errorResult("k must be between 1 and 1000"), nil
+		}
+		pw, err := query.ParseWhereWith(args.Where, database.Indexes(db, args.Set, args.Collection))
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		ck, err := checker(db, args.Set, args.Collection)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		restrict, err := ck.Filter(rules.Read)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		pw = pw.And(restrict)
+		fields, err := query.ParseFields(args.Fields)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		sqlStr, sqlArgs := query.BuildKNN(query.KNNOpts{Set: args.Set, Collection: args.Collection, Table: idx.Name, Vector: vec, Metric: metric, K: k, Where: pw, Fields: fields})
+		rows, err := db.Query(sqlStr, sqlArgs...)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		defer rows.Close()
+		results := []map[string]any{}
+		for rows.Next() {
+			var id, dataStr string
+			var created, updated, version int64
+			if err := rows.Scan(&id, &dataStr, &created, &updated, &version); err != nil {
+				return errorResult(err.Error()), nil
+			}
+			var m map[string]any
+			_ = json.Unmarshal([]byte(dataStr), &m)
+			if args.IncludeMeta == nil || *args.IncludeMeta {
+				m["_meta"] = map[string]any{"id": id, "created_at": created, "updated_at": updated, "version": version}
+			}
+			results = append(results, m)
+		}
+		database.MarkIndexUsed(db, args.Set, args.Collection, idx.Name)
+		return &mcp.CallToolResultFor[any]{StructuredContent: map[string]any{"items": results}}, nil
+	}
+}
+
+func transactionTool(db *sql.DB) func(context.Context, *mcp.ServerSession, *mcp.CallToolParamsFor[TransactionArgs]) (*mcp.CallToolResultFor[any], error) {
+	return func(ctx context.Context, _ *mcp.ServerSession, params *mcp.CallToolParamsFor[TransactionArgs]) (*mcp.CallToolResultFor[any], error) {
+		results, err := txn.Execute(db, params.Arguments.Operations, auth.Root)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		return &mcp.CallToolResultFor[any]{StructuredContent: map[string]any{"results": results}}, nil
+	}
+}
+
+// checker loads the document rules of a collection. The stdio server is driven by the
+// local operator, so rules see it as an admin.
+func checker(db *sql.DB, set, collection string) (*rules.Checker, error) {
+	return rules.NewChecker(db, set, collection, rules.IdentityOf(auth.Root, set, collection))
+}
+
 func errorResult(msg string) *mcp.CallToolResultFor[any] {
 	return &mcp.CallToolResultFor[any]{StructuredContent: map[string]any{"error": msg}, IsError: true}
 }
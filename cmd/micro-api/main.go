@@ -39,23 +39,55 @@ func main() {
 		os.Exit(1)
 	}
 
-	srv := server.New(cfg, db, version)
+	if cfg.AdminKey == "" && cfg.JWTSecret == "" && cfg.JWTJWKSFile == "" && cfg.TLSClientIdentitiesFile == "" {
+		logger.Warn("neither ADMIN_KEY, JWT verification nor client identities are configured: authentication is disabled and every request has full access")
+	}
+
+	srv, err := server.New(cfg, db, version)
+	if err != nil {
+		logger.Error("failed to set up server", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
 
+	httpSrv := &http.Server{Addr: ":" + cfg.Port, Handler: srv, TLSConfig: srv.TLSConfig()}
 	go func() {
-		logger.Info("microapi starting server", slog.String("port", cfg.Port))
+		logger.Info("microapi starting server", slog.String("port", cfg.Port), slog.Bool("tls", httpSrv.TLSConfig != nil))
 		logger.Info("microapi version", slog.String("version", version))
-		if err := http.ListenAndServe(":"+cfg.Port, srv); err != nil && err != http.ErrServerClosed {
+		var err error
+		if httpSrv.TLSConfig != nil {
+			// certificates come from the TLS config so they can be reloaded
+			err = httpSrv.ListenAndServeTLS("", "")
+		} else {
+			err = httpSrv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Error("microapi server error", slog.String("error", err.Error()))
 			os.Exit(1)
 		}
 	}()
 
-	// Graceful shutdown
+	// SIGHUP reloads certificates; SIGINT and SIGTERM shut down gracefully
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := srv.Reload(); err != nil {
+				logger.Error("reload failed, keeping previous certificates", slog.String("error", err.Error()))
+				continue
+			}
+			logger.Info("reloaded certificates")
+		}
+	}()
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 	<-stop
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	// stop accepting connections and let requests, SSE streams and /_ws connections drain
+	// before the background workers stop
+	if err := httpSrv.Shutdown(ctx); err != nil {
+		logger.Error("http shutdown", slog.String("error", err.Error()))
+	}
 	_ = srv.Shutdown(ctx)
 	logger.Info("server stopped")
 }
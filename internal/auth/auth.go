@@ -0,0 +1,221 @@
+// Package auth implements API key authentication and per-set authorization.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"microapi/internal/middleware"
+	"microapi/internal/models"
+)
+
+// Access is a permission level. Each level includes the ones below it.
+type Access int
+
+const (
+	None Access = iota
+	// Read allows fetching, querying, aggregating and following changes.
+	Read
+	// Write adds creating, replacing, patching and deleting documents.
+	Write
+	// Admin adds schemas, indexes, webhooks and dropping collections or sets. Admin on
+	// every set ("*") also allows managing API keys.
+	Admin
+)
+
+var accessNames = map[string]Access{"read": Read, "write": Write, "admin": Admin}
+
+// Scope grants an access level on a set, or on one collection of it. Set "*" matches
+// every set; an empty Collection matches every collection of the set.
+type Scope struct {
+	Set        string `json:"set"`
+	Collection string `json:"collection,omitempty"`
+	Access     string `json:"access"`
+}
+
+func (s Scope) validate() error {
+	if s.Set == "" {
+		return fmt.Errorf("set is required (use \"*\" for all sets)")
+	}
+	if s.Set != "*" {
+		if err := middleware.ValidateNames(s.Set, s.Collection); err != nil {
+			return err
+		}
+	} else if s.Collection != "" {
+		return fmt.Errorf("collection cannot be used with set \"*\"")
+	}
+	if _, ok := accessNames[s.Access]; !ok {
+		return fmt.Errorf("access must be one of read, write, admin")
+	}
+	return nil
+}
+
+func (s Scope) covers(set, collection string) bool {
+	return (s.Set == "*" || s.Set == set) && (s.Collection == "" || s.Collection == collection)
+}
+
+// Principal is the authenticated caller of a request: an API key or a client certificate
+// with scopes, or a JWT whose claims are matched against claim rules (see Guard).
+type Principal struct {
+	KeyID  string // empty for the bootstrap admin key, JWTs and client certificates
+	Scopes []Scope
+	Claims map[string]any // only set for JWTs
+	Cert   string         // identity name of a client certificate (see CertIdentities)
+}
+
+// Can reports whether p has at least need on the collection, or on the whole set when
+// collection is empty. A nil principal can do nothing.
+func (p *Principal) Can(set, collection string, need Access) bool {
+	if p == nil {
+		return false
+	}
+	for _, s := range p.Scopes {
+		if s.covers(set, collection) && accessNames[s.Access] >= need {
+			return true
+		}
+	}
+	return false
+}
+
+type ctxKey struct{}
+
+// FromContext returns the principal stored by Authenticate, or nil.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(ctxKey{}).(*Principal)
+	return p
+}
+
+// Root is the principal of the bootstrap key, of every request when authentication is
+// disabled, and of local tools such as the stdio MCP server.
+var Root = &Principal{Scopes: []Scope{{Set: "*", Access: "admin"}}}
+
+// Authenticate resolves the API key or JWT of each request and stores its principal in
+// the context. The credential is read from "Authorization: Bearer <key>", X-API-Key, or
+// the api_key query parameter for clients that cannot set headers (EventSource,
+// WebSocket). JWTs are only accepted when jwtv is not nil. Requests without a credential
+// act as their verified TLS client certificate when certs maps it.
+// Without an adminKey, a jwtv and certs authentication is off and every request acts as an
+// admin.
+func Authenticate(db *sql.DB, adminKey string, jwtv *JWTVerifier, certs *CertIdentities) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if adminKey == "" && jwtv == nil && certs == nil {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, Root)))
+				return
+			}
+			secret := presentedKey(r)
+			if secret == "" {
+				if p := certs.principal(r.TLS); p != nil {
+					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, p)))
+					return
+				}
+				if certs != nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+					unauthorized(w, "client certificate is not mapped to an identity")
+					return
+				}
+				unauthorized(w, "missing API key, bearer token or client certificate")
+				return
+			}
+			var p *Principal
+			switch {
+			case adminKey != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(adminKey)) == 1:
+				p = Root
+			case jwtv != nil && looksLikeJWT(secret):
+				claims, err := jwtv.Verify(secret)
+				if err != nil {
+					unauthorized(w, "invalid token: "+err.Error())
+					return
+				}
+				p = &Principal{Claims: claims}
+			default:
+				var err error
+				p, err = lookup(db, secret)
+				if err == ErrNotFound {
+					unauthorized(w, "invalid API key")
+					return
+				}
+				if err != nil {
+					slog.Error("auth_lookup_error", slog.String("error", err.Error()))
+					middleware.WriteJSON(w, http.StatusInternalServerError, false, nil, models.Ptr("internal error"))
+					return
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, p)))
+		})
+	}
+}
+
+// Require rejects requests whose principal lacks need on the {set} and {collection} route
+// parameters. Routes without a {set} parameter therefore need access to every set.
+func Require(need Access) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			set := chi.URLParam(r, "set")
+			if set == "" {
+				set = "*"
+			}
+			if !FromContext(r.Context()).Can(set, chi.URLParam(r, "collection"), need) {
+				Forbidden(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Forbidden writes the 403 used for authenticated callers lacking access.
+func Forbidden(w http.ResponseWriter) {
+	middleware.WriteJSON(w, http.StatusForbidden, false, nil, models.Ptr("access denied"))
+}
+
+// ClientKey names the caller of r for rate limiting: its API key, the subject of its JWT,
+// its client certificate identity, or else its IP address (see chi's RealIP middleware). The bootstrap key and requests
+// without authentication are told apart by address.
+func ClientKey(r *http.Request) string {
+	if p := FromContext(r.Context()); p != nil {
+		if p.KeyID != "" {
+			return "key:" + p.KeyID
+		}
+		if sub, ok := p.Claims["sub"].(string); ok && sub != "" {
+			return "jwt:" + sub
+		}
+		if p.Cert != "" {
+			return "cert:" + p.Cert
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="microapi"`)
+	middleware.WriteJSON(w, http.StatusUnauthorized, false, nil, models.Ptr(msg))
+}
+
+func presentedKey(r *http.Request) string {
+	if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(v)
+	}
+	if v := r.Header.Get("X-API-Key"); v != "" {
+		return v
+	}
+	q := r.URL.Query()
+	v := q.Get("api_key")
+	if v != "" {
+		// drop the key from the URL so the request logger does not record it
+		q.Del("api_key")
+		r.URL.RawQuery = q.Encode()
+	}
+	return v
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// CertIdentities maps verified TLS client certificates to scopes. It is read from a JSON
+// file naming each identity by the subject common name or a DNS, URI or email SAN of its
+// certificate:
+//
+//	{"identities": [
+//	  {"name": "billing.internal", "scopes": [{"set": "billing", "access": "write"}]},
+//	  {"name": "spiffe://example.org/reporter", "scopes": [{"set": "*", "access": "read"}]}
+//	]}
+type CertIdentities struct {
+	path   string
+	byName atomic.Pointer[map[string][]Scope]
+}
+
+// LoadCertIdentities returns nil when path is empty.
+func LoadCertIdentities(path string) (*CertIdentities, error) {
+	if path == "" {
+		return nil, nil
+	}
+	c := &CertIdentities{path: path}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload rereads the file. On error the identities loaded before stay in use.
+func (c *CertIdentities) Reload() error {
+	b, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("client identities %s: %w", c.path, err)
+	}
+	var file struct {
+		Identities []struct {
+			Name   string  `json:"name"`
+			Scopes []Scope `json:"scopes"`
+		} `json:"identities"`
+	}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return fmt.Errorf("client identities %s: %w", c.path, err)
+	}
+	byName := map[string][]Scope{}
+	for i, id := range file.Identities {
+		if id.Name == "" {
+			return fmt.Errorf("client identities %s: identities[%d]: name is required", c.path, i)
+		}
+		if _, dup := byName[id.Name]; dup {
+			return fmt.Errorf("client identities %s: identities[%d]: duplicate name %q", c.path, i, id.Name)
+		}
+		if len(id.Scopes) == 0 {
+			return fmt.Errorf("client identities %s: identities[%d]: at least one scope is required", c.path, i)
+		}
+		for j, s := range id.Scopes {
+			if err := s.validate(); err != nil {
+				return fmt.Errorf("client identities %s: identities[%d].scopes[%d]: %w", c.path, i, j, err)
+			}
+		}
+		byName[id.Name] = id.Scopes
+	}
+	c.byName.Store(&byName)
+	return nil
+}
+
+// principal returns the principal of the verified client certificate of a connection, or
+// nil when there is none or it is not mapped. The common name is tried first, then the
+// SANs in the order DNS, URI, email.
+func (c *CertIdentities) principal(cs *tls.ConnectionState) *Principal {
+	if c == nil || cs == nil || len(cs.VerifiedChains) == 0 {
+		return nil
+	}
+	leaf := cs.VerifiedChains[0][0]
+	names := append([]string{leaf.Subject.CommonName}, leaf.DNSNames...)
+	for _, u := range leaf.URIs {
+		names = append(names, u.String())
+	}
+	names = append(names, leaf.EmailAddresses...)
+	byName := *c.byName.Load()
+	for _, name := range names {
+		if scopes, ok := byName[name]; ok && name != "" {
+			return &Principal{Cert: name, Scopes: scopes}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"microapi/internal/middleware"
+	"microapi/internal/models"
+	"microapi/internal/query"
+)
+
+// ClaimRules restrict what JWT callers can do in a collection. Each rule is a where
+// object (see query.ParseWhere) in which {"$claim": "path"} stands for a claim of the
+// caller's token:
+//
+//	{"read": {"owner": {"$eq": {"$claim": "sub"}}}, "write": {"owner": {"$eq": {"$claim": "sub"}}}}
+//
+// Reads only see matching documents. Writes may only touch matching documents and must
+// leave them matching. A missing rule denies that kind of access; {} allows everything.
+type ClaimRules struct {
+	Read  json.RawMessage `json:"read,omitempty"`
+	Write json.RawMessage `json:"write,omitempty"`
+}
+
+// PutClaimRules validates and stores the claim rules of a collection.
+func PutClaimRules(db *sql.DB, set, collection string, rules ClaimRules) error {
+	for name, raw := range map[string]json.RawMessage{"read": rules.Read, "write": rules.Write} {
+		if raw == nil {
+			continue
+		}
+		if _, err := compileClaimRule(raw, placeholderClaim); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	_, err := db.Exec(`INSERT INTO claim_rules (set_name, collection_name, rules, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(set_name, collection_name) DO UPDATE SET rules = excluded.rules, updated_at = excluded.updated_at`,
+		set, collection, mustJSON(rules), time.Now().Unix())
+	return err
+}
+
+// GetClaimRules returns the claim rules of a collection, or ErrNotFound.
+func GetClaimRules(db *sql.DB, set, collection string) (*ClaimRules, error) {
+	var raw string
+	err := db.QueryRow(`SELECT rules FROM claim_rules WHERE set_name = ? AND collection_name = ?`, set, collection).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules ClaimRules
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// DeleteClaimRules removes the claim rules of a collection, denying JWT callers again.
+func DeleteClaimRules(db *sql.DB, set, collection string) error {
+	res, err := db.Exec(`DELETE FROM claim_rules WHERE set_name = ? AND collection_name = ?`, set, collection)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type filterKey struct{}
+
+// RowFilter returns the conditions that documents touched by the request must meet, as
+// set up by Guard. nil means no restriction.
+func RowFilter(ctx context.Context) *query.ParsedWhere {
+	pw, _ := ctx.Value(filterKey{}).(*query.ParsedWhere)
+	return pw
+}
+
+// Guard protects document routes. API key callers are checked like Require. JWT callers
+// are let through by the collection's read or write claim rule, which is compiled with
+// their claims and left in the context for the handler (see RowFilter).
+func Guard(db *sql.DB, need Access) func(http.Handler) http.Handler {
+	require := Require(need)
+	return func(next http.Handler) http.Handler {
+		byKey := require(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := FromContext(r.Context())
+			if p == nil || p.Claims == nil {
+				byKey.ServeHTTP(w, r)
+				return
+			}
+			rules, err := GetClaimRules(db, chi.URLParam(r, "set"), chi.URLParam(r, "collection"))
+			if err == ErrNotFound {
+				Forbidden(w)
+				return
+			}
+			if err != nil {
+				slog.Error("claim_rules_error", slog.String("error", err.Error()))
+				middleware.WriteJSON(w, http.StatusInternalServerError, false, nil, models.Ptr("internal error"))
+				return
+			}
+			raw := rules.Read
+			if need == Write {
+				raw = rules.Write
+			} else if need != Read {
+				raw = nil
+			}
+			if raw == nil {
+				Forbidden(w)
+				return
+			}
+			pw, err := compileClaimRule(raw, func(path string) (any, error) { return claimValue(p.Claims, path) })
+			if err != nil {
+				middleware.WriteJSON(w, http.StatusForbidden, false, nil, models.Ptr(err.Error()))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), filterKey{}, pw)))
+		})
+	}
+}
+
+// compileClaimRule replaces every {"$claim": path} in a rule and parses the result.
+func compileClaimRule(raw json.RawMessage, resolve func(path string) (any, error)) (*query.ParsedWhere, error) {
+	var rule any
+	if err := json.Unmarshal(raw, &rule); err != nil {
+		return nil, fmt.Errorf("rule must be a where object")
+	}
+	if _, ok := rule.(map[string]any); !ok {
+		return nil, fmt.Errorf("rule must be a where object")
+	}
+	resolved, err := substituteClaims(rule, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return query.ParseWhere(mustJSON(resolved))
+}
+
+func substituteClaims(v any, resolve func(path string) (any, error)) (any, error) {
+	switch t := v.(type) {
+	case map[string]any:
+		if path, ok := t["$claim"].(string); ok && len(t) == 1 {
+			return resolve(path)
+		}
+		out := make(map[string]any, len(t))
+		for k, e := range t {
+			r, err := substituteClaims(e, resolve)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(t))
+		for i, e := range t {
+			r, err := substituteClaims(e, resolve)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// claimValue looks a claim up by name, then as a dot path into nested claims, so both
+// "https://example.com/tenant" and "org.id" work.
+func claimValue(claims map[string]any, path string) (any, error) {
+	if v, ok := claims[path]; ok {
+		return v, nil
+	}
+	var cur any = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("token lacks the %q claim", path)
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, fmt.Errorf("token lacks the %q claim", path)
+		}
+	}
+	return cur, nil
+}
+
+// placeholderClaim stands in for claims when a rule is validated. An empty array is
+// accepted by every operator that takes a value, including $in and $nin.
+func placeholderClaim(string) (any, error) { return []any{}, nil }
+
+func mustJSON(v any) string { b, _ := json.Marshal(v); return string(b) }
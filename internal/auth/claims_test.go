@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testClaims = map[string]any{
+	"sub":                        "u1",
+	"groups":                     []any{"eng", "ops"},
+	"org":                        map[string]any{"id": "o1", "plan": map[string]any{"tier": 2.0}},
+	"https://example.com/tenant": "t1",
+	"a.b":                        "flat",
+	"a":                          map[string]any{"b": "nested"},
+}
+
+func resolveTest(path string) (any, error) { return claimValue(testClaims, path) }
+
+func TestClaimValue(t *testing.T) {
+	tests := []struct {
+		path string
+		want any
+	}{
+		{"sub", "u1"},
+		{"groups", []any{"eng", "ops"}},
+		{"org.id", "o1"},
+		{"org.plan.tier", 2.0},
+		{"org.plan", map[string]any{"tier": 2.0}},
+		// a claim named with dots wins over the nested path
+		{"https://example.com/tenant", "t1"},
+		{"a.b", "flat"},
+	}
+	for _, tt := range tests {
+		got, err := claimValue(testClaims, tt.path)
+		if err != nil {
+			t.Errorf("claimValue(%q): %v", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("claimValue(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+	for _, path := range []string{"email", "org.name", "sub.x", "groups.0", "org.plan.tier.x", ""} {
+		if got, err := claimValue(testClaims, path); err == nil {
+			t.Errorf("claimValue(%q) = %v, want an error", path, got)
+		}
+	}
+}
+
+func TestSubstituteClaims(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule, want any
+	}{
+		{"a claim", map[string]any{"$claim": "sub"}, "u1"},
+		{"in an operator",
+			map[string]any{"owner": map[string]any{"$eq": map[string]any{"$claim": "sub"}}},
+			map[string]any{"owner": map[string]any{"$eq": "u1"}}},
+		{"in arrays and logical operators",
+			map[string]any{"$or": []any{
+				map[string]any{"owner": map[string]any{"$eq": map[string]any{"$claim": "sub"}}},
+				map[string]any{"group": map[string]any{"$in": map[string]any{"$claim": "groups"}}},
+				map[string]any{"org": map[string]any{"$in": []any{map[string]any{"$claim": "org.id"}, "public"}}},
+			}},
+			map[string]any{"$or": []any{
+				map[string]any{"owner": map[string]any{"$eq": "u1"}},
+				map[string]any{"group": map[string]any{"$in": []any{"eng", "ops"}}},
+				map[string]any{"org": map[string]any{"$in": []any{"o1", "public"}}},
+			}}},
+		// only an object whose single key is $claim, with a string, is a claim
+		{"$claim beside other keys",
+			map[string]any{"$claim": "sub", "x": 1.0},
+			map[string]any{"$claim": "sub", "x": 1.0}},
+		{"$claim with a number",
+			map[string]any{"$claim": 1.0},
+			map[string]any{"$claim": 1.0}},
+		{"no claims",
+			map[string]any{"status": map[string]any{"$eq": "published"}},
+			map[string]any{"status": map[string]any{"$eq": "published"}}},
+		{"scalars", "x", "x"},
+	}
+	for _, tt := range tests {
+		got, err := substituteClaims(tt.rule, resolveTest)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	rule := map[string]any{"$and": []any{map[string]any{"owner": map[string]any{"$eq": map[string]any{"$claim": "email"}}}}}
+	if _, err := substituteClaims(rule, resolveTest); err == nil {
+		t.Error("missing claim: want an error")
+	}
+	errResolve := errors.New("resolve failed")
+	if _, err := substituteClaims(map[string]any{"$claim": "sub"}, func(string) (any, error) { return nil, errResolve }); !errors.Is(err, errResolve) {
+		t.Errorf("got %v, want the resolve error", err)
+	}
+}
+
+func TestCompileClaimRule(t *testing.T) {
+	tests := []struct {
+		rule string
+		sql  []string
+		args []any
+	}{
+		{`{}`, nil, nil},
+		{`{"owner": {"$eq": {"$claim": "sub"}}}`,
+			[]string{"json_extract(data, '$.owner') = ?"}, []any{"u1"}},
+		{`{"tenant": {"$eq": {"$claim": "https://example.com/tenant"}}}`,
+			[]string{"json_extract(data, '$.tenant') = ?"}, []any{"t1"}},
+		{`{"group": {"$in": {"$claim": "groups"}}}`,
+			[]string{"json_extract(data, '$.group') IN (?, ?)"}, []any{"eng", "ops"}},
+	}
+	for _, tt := range tests {
+		pw, err := compileClaimRule([]byte(tt.rule), resolveTest)
+		if err != nil {
+			t.Errorf("%s: %v", tt.rule, err)
+			continue
+		}
+		var sql []string
+		var args []any
+		for _, c := range pw.Conds {
+			sql = append(sql, c.SQL)
+			args = append(args, c.Args...)
+		}
+		if !reflect.DeepEqual(sql, tt.sql) || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: got %q %v, want %q %v", tt.rule, sql, args, tt.sql, tt.args)
+		}
+	}
+}
+
+// A claim is always a value: a token cannot smuggle operators into a rule.
+func TestCompileClaimRuleValueOnly(t *testing.T) {
+	evil := map[string]any{"$ne": "nobody"}
+	pw, err := compileClaimRule([]byte(`{"owner": {"$eq": {"$claim": "sub"}}}`), func(string) (any, error) { return evil, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pw.Conds) != 1 || pw.Conds[0].SQL != "json_extract(data, '$.owner') = ?" {
+		t.Fatalf("conds = %+v", pw.Conds)
+	}
+	if !reflect.DeepEqual(pw.Conds[0].Args, []any{evil}) {
+		t.Errorf("args = %v, want the claim as a value", pw.Conds[0].Args)
+	}
+}
+
+func TestCompileClaimRuleErrors(t *testing.T) {
+	tests := []string{
+		`[]`,
+		`"owner"`,
+		`null`,
+		`{`,
+		`{"owner": {"$eq": {"$claim": "email"}}}`,
+		`{"owner": {"$nope": {"$claim": "sub"}}}`,
+		// $in needs an array claim
+		`{"group": {"$in": {"$claim": "sub"}}}`,
+	}
+	for _, rule := range tests {
+		if _, err := compileClaimRule([]byte(rule), resolveTest); err == nil {
+			t.Errorf("%s: want an error", rule)
+		}
+	}
+	// rules are validated with a placeholder that every operator accepts
+	for _, rule := range []string{`{"group": {"$in": {"$claim": "groups"}}}`, `{"owner": {"$eq": {"$claim": "sub"}}}`, `{"n": {"$nin": {"$claim": "x"}}}`} {
+		if _, err := compileClaimRule([]byte(rule), placeholderClaim); err != nil {
+			t.Errorf("%s with placeholder claims: %v", rule, err)
+		}
+	}
+}
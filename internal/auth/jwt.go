@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTConfig selects how bearer JWTs are verified. Secret enables HS256; JWKSFile, a
+// local JSON Web Key Set, enables RS256 and ES256. Issuer and Audience are checked
+// when set.
+type JWTConfig struct {
+	Secret   string
+	JWKSFile string
+	Issuer   string
+	Audience string
+}
+
+// JWTVerifier checks bearer JWTs and returns their claims.
+type JWTVerifier struct {
+	secret []byte
+	keys   map[string]any // kid -> *rsa.PublicKey or *ecdsa.PublicKey
+	parser *jwt.Parser
+}
+
+// NewJWTVerifier returns nil when neither a secret nor a JWKS file is configured.
+func NewJWTVerifier(cfg JWTConfig) (*JWTVerifier, error) {
+	if cfg.Secret == "" && cfg.JWKSFile == "" {
+		return nil, nil
+	}
+	v := &JWTVerifier{keys: map[string]any{}}
+	var methods []string
+	if cfg.Secret != "" {
+		v.secret = []byte(cfg.Secret)
+		methods = append(methods, "HS256")
+	}
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("jwks %s: %w", cfg.JWKSFile, err)
+		}
+		v.keys = keys
+		methods = append(methods, "RS256", "ES256")
+	}
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+	return v, nil
+}
+
+// Verify checks the signature, expiry, issuer and audience of token and returns its claims.
+func (v *JWTVerifier) Verify(token string) (map[string]any, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.key); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// key picks the verification key for a token from its alg and kid headers.
+func (v *JWTVerifier) key(t *jwt.Token) (any, error) {
+	if t.Method.Alg() == "HS256" {
+		return v.secret, nil
+	}
+	kid, _ := t.Header["kid"].(string)
+	if k, ok := v.keys[kid]; ok {
+		return k, nil
+	}
+	if kid == "" && len(v.keys) == 1 {
+		for _, k := range v.keys {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// looksLikeJWT tells bearer JWTs (three dot-separated parts) apart from API keys.
+func looksLikeJWT(token string) bool { return strings.Count(token, ".") == 2 }
+
+// loadJWKS reads the RSA and P-256 public keys of a JWKS file, keyed by kid.
+func loadJWKS(path string) (map[string]any, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]any{}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := decodeB64Int(k.N)
+			e, err2 := decodeB64Int(k.E)
+			if err1 != nil || err2 != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("keys[%d]: invalid RSA key", i)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				return nil, fmt.Errorf("keys[%d]: unsupported curve %q", i, k.Crv)
+			}
+			x, err1 := decodeB64Int(k.X)
+			y, err2 := decodeB64Int(k.Y)
+			if err1 != nil || err2 != nil || !elliptic.P256().IsOnCurve(x, y) {
+				return nil, fmt.Errorf("keys[%d]: invalid EC key", i)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		default:
+			return nil, fmt.Errorf("keys[%d]: unsupported key type %q", i, k.Kty)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys found")
+	}
+	return keys, nil
+}
+
+func decodeB64Int(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
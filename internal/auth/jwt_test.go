@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "test-secret-0123456789"
+
+type testKeys struct {
+	rsa, rsa2, other *rsa.PrivateKey
+	ec               *ecdsa.PrivateKey
+	jwks             string // path of a JWKS with rsa ("r1"), rsa2 ("r2") and ec ("e1")
+	single           string // path of a JWKS with rsa only, without a kid
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+	k := &testKeys{}
+	var err error
+	for _, p := range []**rsa.PrivateKey{&k.rsa, &k.rsa2, &k.other} {
+		if *p, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if k.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	k.jwks = writeJWKS(t, dir, "jwks.json", rsaJWK("r1", &k.rsa.PublicKey), rsaJWK("r2", &k.rsa2.PublicKey), ecJWK("e1", &k.ec.PublicKey))
+	k.single = writeJWKS(t, dir, "single.json", rsaJWK("", &k.rsa.PublicKey))
+	return k
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func rsaJWK(kid string, pub *rsa.PublicKey) map[string]any {
+	return map[string]any{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())}
+}
+
+func ecJWK(kid string, pub *ecdsa.PublicKey) map[string]any {
+	x, y := pub.X.FillBytes(make([]byte, 32)), pub.Y.FillBytes(make([]byte, 32))
+	return map[string]any{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(x), "y": b64(y)}
+}
+
+func writeJWKS(t *testing.T, dir, name string, keys ...map[string]any) string {
+	t.Helper()
+	b, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// sign returns a token signed with key, with kid in its header unless it is empty.
+func sign(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func claims(extra map[string]any) jwt.MapClaims {
+	c := jwt.MapClaims{"sub": "u1", "exp": time.Now().Add(time.Hour).Unix()}
+	for k, v := range extra {
+		if v == nil {
+			delete(c, k)
+			continue
+		}
+		c[k] = v
+	}
+	return c
+}
+
+func TestNewJWTVerifierOff(t *testing.T) {
+	v, err := NewJWTVerifier(JWTConfig{Issuer: "https://issuer.example"})
+	if v != nil || err != nil {
+		t.Errorf("NewJWTVerifier without a secret or JWKS = %v, %v; want nil, nil", v, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	k := newTestKeys(t)
+	pubDER, err := x509.MarshalPKIXPublicKey(&k.rsa.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()})
+	noExp := claims(map[string]any{"exp": nil})
+
+	tests := []struct {
+		name  string
+		cfg   JWTConfig
+		token string
+		ok    bool
+	}{
+		// HS256
+		{"HS256 with the secret", JWTConfig{Secret: testSecret},
+			sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", claims(nil)), true},
+		{"HS256 with another secret", JWTConfig{Secret: testSecret},
+			sign(t, jwt.SigningMethodHS256, []byte("another-secret"), "", claims(nil)), false},
+		{"HS512 is not accepted", JWTConfig{Secret: testSecret},
+			sign(t, jwt.SigningMethodHS512, []byte(testSecret), "", claims(nil)), false},
+		{"alg none", JWTConfig{Secret: testSecret},
+			sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", claims(nil)), false},
+
+		// alg and key type mismatches
+		{"HS256 when only a JWKS is configured", JWTConfig{JWKSFile: k.jwks},
+			sign(t, jwt.SigningMethodHS256, []byte(testSecret), "r1", claims(nil)), false},
+		{"HS256 signed with the RSA public key", JWTConfig{JWKSFile: k.jwks},
+			sign(t, jwt.SigningMethodHS256, pubDER, "r1", claims(nil)), false},
+		{"HS256 signed with the RSA public key, secret configured", JWTConfig{Secret: testSecret, JWKSFile: k.jwks},
+			sign(t, jwt.SigningMethodHS256, pubDER, "r1", claims(nil)), false},
+		{"RS256 when only a secret is configured", JWTConfig{Secret: testSecret},
+			sign(t, jwt.SigningMethodRS256, k.rsa, "r1", claims(nil)), false},
+		{"RS256 naming the EC key", JWTConfig{JWKSFile: k.jwks},
+			sign(t, jwt.SigningMethodRS256, k.rsa, "e1", claims(nil)), false},
+		{"ES256 naming an RSA key", JWTConfig{JWKSFile: k.jwks},
+			sign(t, jwt.SigningMethodES256, k.ec, "r1", claims(nil)), false},
+		{"RS512 is not accepted", JWTConfig{JWKSFile: k.jwks},
+			sign(t, jwt.SigningMethodRS512, k.rsa, "r1", claims(nil)), false},
+
+		// kid selection
+		{"RS256 with its kid", JWTConfig{JWKSFile: k.jwks},
+			sign(t, jwt.SigningMethodRS256, k.rsa, "r1", claims(nil)), true},
+		{"RS256 with the second key's kid", JWTConfig{JWKSFile: k.jwks},
+			sign(t, jwt.SigningMethodRS256, k.rsa2, "r2", claims(nil)), true},
+		{"RS256 with the other key's kid", JWTConfig{JWKSFile: k.jwks},
+			sign(t, jwt.SigningMethodRS256, k.rsa, "r2", claims(nil)), false},
+		{"RS256 with an unknown kid", JWTConfig{JWKSFile: k.jwks},
+			sign(t, jwt.SigningMethodRS256, k.rsa, "r3", claims(nil)), false},
+		{"RS256 signed by a key not in the JWKS", JWTConfig{JWKSFile: k.jwks},
+			sign(t, jwt.SigningMethodRS256, k.other, "r1", claims(nil)), false},
+		{"RS256 without a kid, several keys", JWTConfig{JWKSFile: k.jwks},
+			sign(t, jwt.SigningMethodRS256, k.rsa, "", claims(nil)), false},
+		{"RS256 without a kid, one key", JWTConfig{JWKSFile: k.single},
+			sign(t, jwt.SigningMethodRS256, k.rsa, "", claims(nil)), true},
+		{"RS256 with a kid, one key without a kid", JWTConfig{JWKSFile: k.single},
+			sign(t, jwt.SigningMethodRS256, k.rsa, "r1", claims(nil)), false},
+		{"ES256 with its kid", JWTConfig{JWKSFile: k.jwks},
+			sign(t, jwt.SigningMethodES256, k.ec, "e1", claims(nil)), true},
+		{"HS256 and RS256 both configured", JWTConfig{Secret: testSecret, JWKSFile: k.jwks},
+			sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", claims(nil)), true},
+
+		// expiry
+		{"missing exp", JWTConfig{Secret: testSecret},
+			sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", noExp), false},
+		{"expired", JWTConfig{Secret: testSecret},
+			sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", expired), false},
+		{"missing exp, RS256", JWTConfig{JWKSFile: k.jwks},
+			sign(t, jwt.SigningMethodRS256, k.rsa, "r1", noExp), false},
+		{"not valid yet", JWTConfig{Secret: testSecret},
+			sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", claims(map[string]any{"nbf": time.Now().Add(time.Hour).Unix()})), false},
+
+		// issuer and audience
+		{"issuer matches", JWTConfig{Secret: testSecret, Issuer: "https://issuer.example"},
+			sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", claims(map[string]any{"iss": "https://issuer.example"})), true},
+		{"issuer differs", JWTConfig{Secret: testSecret, Issuer: "https://issuer.example"},
+			sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", claims(map[string]any{"iss": "https://evil.example"})), false},
+		{"issuer missing", JWTConfig{Secret: testSecret, Issuer: "https://issuer.example"},
+			sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", claims(nil)), false},
+		{"issuer not checked when not configured", JWTConfig{Secret: testSecret},
+			sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", claims(map[string]any{"iss": "https://any.example"})), true},
+		{"audience matches", JWTConfig{Secret: testSecret, Audience: "micro-api"},
+			sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", claims(map[string]any{"aud": "micro-api"})), true},
+		{"audience in a list", JWTConfig{Secret: testSecret, Audience: "micro-api"},
+			sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", claims(map[string]any{"aud": []any{"other", "micro-api"}})), true},
+		{"audience differs", JWTConfig{Secret: testSecret, Audience: "micro-api"},
+			sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", claims(map[string]any{"aud": "other"})), false},
+		{"audience missing", JWTConfig{Secret: testSecret, Audience: "micro-api"},
+			sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", claims(nil)), false},
+		{"issuer and audience, RS256", JWTConfig{JWKSFile: k.jwks, Issuer: "https://issuer.example", Audience: "micro-api"},
+			sign(t, jwt.SigningMethodRS256, k.rsa, "r1", claims(map[string]any{"iss": "https://issuer.example", "aud": "micro-api"})), true},
+
+		// malformed
+		{"not a JWT", JWTConfig{Secret: testSecret}, "a.b.c", false},
+		{"tampered payload", JWTConfig{Secret: testSecret},
+			tamper(sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", claims(nil))), false},
+	}
+	for _, tt := range tests {
+		v, err := NewJWTVerifier(tt.cfg)
+		if err != nil {
+			t.Fatalf("%s: NewJWTVerifier: %v", tt.name, err)
+		}
+		got, err := v.Verify(tt.token)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: accepted, want an error", tt.name)
+		}
+		if tt.ok && err == nil && got["sub"] != "u1" {
+			t.Errorf("%s: claims = %v", tt.name, got)
+		}
+	}
+}
+
+// tamper replaces the payload of token, keeping its header and signature.
+func tamper(token string) string {
+	payload := b64([]byte(`{"sub":"admin","exp":4102444800}`))
+	first, last := 0, len(token)
+	for i, c := range token {
+		if c == '.' {
+			if first == 0 {
+				first = i
+			} else {
+				last = i
+			}
+		}
+	}
+	return token[:first+1] + payload + token[last:]
+}
+
+func TestLoadJWKS(t *testing.T) {
+	k := newTestKeys(t)
+	keys, err := loadJWKS(k.jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("got %d keys, want 3", len(keys))
+	}
+	if pub, ok := keys["r1"].(*rsa.PublicKey); !ok || !pub.Equal(&k.rsa.PublicKey) {
+		t.Errorf("r1 = %v, want the RSA key", keys["r1"])
+	}
+	if pub, ok := keys["e1"].(*ecdsa.PublicKey); !ok || !pub.Equal(&k.ec.PublicKey) {
+		t.Errorf("e1 = %v, want the EC key", keys["e1"])
+	}
+
+	dir := t.TempDir()
+	enc := rsaJWK("r9", &k.other.PublicKey)
+	enc["use"] = "enc"
+	if keys, err := loadJWKS(writeJWKS(t, dir, "enc.json", rsaJWK("r1", &k.rsa.PublicKey), enc)); err != nil || len(keys) != 1 {
+		t.Errorf("encryption keys: got %v, %v; want only r1", keys, err)
+	}
+	// padded base64 is accepted
+	padded := rsaJWK("r1", &k.rsa.PublicKey)
+	padded["e"] = base64.URLEncoding.EncodeToString(big.NewInt(int64(k.rsa.PublicKey.E)).Bytes())
+	if _, err := loadJWKS(writeJWKS(t, dir, "padded.json", padded)); err != nil {
+		t.Errorf("padded base64: %v", err)
+	}
+
+	p384 := ecJWK("e1", &k.ec.PublicKey)
+	p384["crv"] = "P-384"
+	offCurve := ecJWK("e1", &k.ec.PublicKey)
+	offCurve["y"] = offCurve["x"]
+	badN := rsaJWK("r1", &k.rsa.PublicKey)
+	badN["n"] = "!!!"
+	onlyEnc := rsaJWK("r1", &k.rsa.PublicKey)
+	onlyEnc["use"] = "enc"
+	bad := map[string]string{
+		"unsupported key type": writeJWKS(t, dir, "oct.json", map[string]any{"kty": "oct", "k": "c2VjcmV0"}),
+		"unsupported curve":    writeJWKS(t, dir, "p384.json", p384),
+		"point off the curve":  writeJWKS(t, dir, "offcurve.json", offCurve),
+		"invalid modulus":      writeJWKS(t, dir, "badn.json", badN),
+		"no signing keys":      writeJWKS(t, dir, "onlyenc.json", onlyEnc),
+		"empty set":            writeJWKS(t, dir, "empty.json"),
+		"missing file":         filepath.Join(dir, "missing.json"),
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notjson.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	bad["not JSON"] = filepath.Join(dir, "notjson.json")
+	for name, path := range bad {
+		if _, err := loadJWKS(path); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+	if _, err := NewJWTVerifier(JWTConfig{JWKSFile: bad["unsupported curve"]}); err == nil {
+		t.Error("NewJWTVerifier with an invalid JWKS: want an error")
+	}
+}
+
+func TestLooksLikeJWT(t *testing.T) {
+	tests := map[string]bool{
+		"eyJh.eyJz.c2ln":  true,
+		"mk_live_abc123":  false,
+		"a.b":             false,
+		"a.b.c.d":         false,
+		"key.with.dots.x": false,
+	}
+	for token, want := range tests {
+		if got := looksLikeJWT(token); got != want {
+			t.Errorf("looksLikeJWT(%q) = %v, want %v", token, got, want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// ErrNotFound is returned for unknown or already revoked keys.
+var ErrNotFound = errors.New("not found")
+
+// keyPrefix marks microapi keys so they are easy to spot in configs and logs.
+const keyPrefix = "mapi_"
+
+// Key is an API key. Secret is only filled when a key is created.
+type Key struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Prefix     string  `json:"prefix"`
+	Scopes     []Scope `json:"scopes"`
+	Secret     string  `json:"key,omitempty"`
+	CreatedAt  int64   `json:"created_at"`
+	LastUsedAt *int64  `json:"last_used_at"`
+	RevokedAt  *int64  `json:"revoked_at"`
+}
+
+// Create mints a key with the given scopes. The returned Key carries the secret, which is
+// not stored and cannot be recovered later.
+func Create(db *sql.DB, name string, scopes []Scope) (*Key, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	for i, s := range scopes {
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("scopes[%d]: %w", i, err)
+		}
+	}
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	secret := keyPrefix + hex.EncodeToString(b)
+	k := &Key{ID: xid.New().String(), Name: name, Prefix: secret[:len(keyPrefix)+8], Scopes: scopes, Secret: secret, CreatedAt: time.Now().Unix()}
+	sb, _ := json.Marshal(scopes)
+	_, err := db.Exec(`INSERT INTO api_keys (id, name, prefix, key_hash, scopes, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		k.ID, k.Name, k.Prefix, hashKey(secret), string(sb), k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// List returns every key, revoked ones included, oldest first.
+func List(db *sql.DB) ([]Key, error) {
+	rows, err := db.Query(`SELECT id, name, prefix, scopes, created_at, last_used_at, revoked_at FROM api_keys ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []Key{}
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// Get returns one key by id.
+func Get(db *sql.DB, id string) (*Key, error) {
+	k, err := scanKey(db.QueryRow(`SELECT id, name, prefix, scopes, created_at, last_used_at, revoked_at FROM api_keys WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return k, err
+}
+
+// Revoke disables a key. Revoked keys stay listed so their use can still be audited.
+func Revoke(db *sql.DB, id string) error {
+	res, err := db.Exec(`UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// lookup resolves a presented secret to the principal of an active key.
+func lookup(db *sql.DB, secret string) (*Principal, error) {
+	var id, scopes string
+	err := db.QueryRow(`SELECT id, scopes FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL`, hashKey(secret)).Scan(&id, &scopes)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := &Principal{KeyID: id}
+	if err := json.Unmarshal([]byte(scopes), &p.Scopes); err != nil {
+		return nil, err
+	}
+	// last_used_at is only a hint, so refresh it at most once a minute to spare writes
+	now := time.Now().Unix()
+	_, _ = db.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)`, now, id, now-60)
+	return p, nil
+}
+
+type rowScanner interface{ Scan(dest ...any) error }
+
+func scanKey(row rowScanner) (*Key, error) {
+	var k Key
+	var scopes string
+	if err := row.Scan(&k.ID, &k.Name, &k.Prefix, &scopes, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(scopes), &k.Scopes); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// hashKey returns the stored form of a key. Keys are long random strings, so a plain
+// SHA-256 is enough and keeps lookups to a single indexed query.
+func hashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
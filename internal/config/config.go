@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -17,18 +18,63 @@ type Config struct {
 	AllowDeleteCollections bool
 	CORSOrigins            []string
 	DevMode                bool
+	// AdminKey is the bootstrap API key with full access. Empty disables authentication.
+	AdminKey string
+	// JWTSecret (HS256) and JWTJWKSFile (RS256/ES256) enable bearer JWTs; JWTIssuer and
+	// JWTAudience are checked when set.
+	JWTSecret   string
+	JWTJWKSFile string
+	JWTIssuer   string
+	JWTAudience string
+	// RateLimitRead, RateLimitWrite and RateLimitAdmin are per-client request rates for
+	// each route class, like "600/m". Empty disables limiting for the class.
+	RateLimitRead  string
+	RateLimitWrite string
+	RateLimitAdmin string
+	// RateLimitAuthFailures is the rate of failed authentications (401s) allowed per IP
+	// address, like "20/m". Beyond it the address is refused before its credentials are
+	// checked. Empty or a zero count disables it.
+	RateLimitAuthFailures string
+	// TLSCertFile and TLSKeyFile switch the server to HTTPS. TLSClientCAFile verifies client
+	// certificates against a CA bundle, required with TLSClientCertRequired, and
+	// TLSClientIdentitiesFile maps them to scopes. All files are reloaded on SIGHUP.
+	TLSCertFile             string
+	TLSKeyFile              string
+	TLSClientCAFile         string
+	TLSClientCertRequired   bool
+	TLSClientIdentitiesFile string
+	// ChangelogRetention and ChangelogMaxRows bound the change history kept for the change
+	// feed, live queries and webhooks; zero keeps it forever.
+	ChangelogRetention time.Duration
+	ChangelogMaxRows   int64
 }
 
 func Load() (*Config, error) {
 	_ = godotenv.Load() // load .env if present
 	cfg := &Config{
-		Port:                   getEnv("PORT", "8080"),
-		DBPath:                 getEnv("DB_PATH", "./data.db"),
-		MaxRequestSize:         getEnvInt64("MAX_REQUEST_SIZE", 1048576),
-		AllowDeleteSets:        getEnvBool("ALLOW_DELETE_SETS", false),
-		AllowDeleteCollections: getEnvBool("ALLOW_DELETE_COLLECTIONS", false),
-		CORSOrigins:            parseCSV(os.Getenv("CORS")),
-		DevMode:                getEnvBool("DEV", false),
+		Port:                    getEnv("PORT", "8080"),
+		DBPath:                  getEnv("DB_PATH", "./data.db"),
+		MaxRequestSize:          getEnvInt64("MAX_REQUEST_SIZE", 1048576),
+		AllowDeleteSets:         getEnvBool("ALLOW_DELETE_SETS", false),
+		AllowDeleteCollections:  getEnvBool("ALLOW_DELETE_COLLECTIONS", false),
+		CORSOrigins:             parseCSV(os.Getenv("CORS")),
+		DevMode:                 getEnvBool("DEV", false),
+		AdminKey:                os.Getenv("ADMIN_KEY"),
+		JWTSecret:               os.Getenv("JWT_SECRET"),
+		JWTJWKSFile:             os.Getenv("JWT_JWKS_FILE"),
+		JWTIssuer:               os.Getenv("JWT_ISSUER"),
+		JWTAudience:             os.Getenv("JWT_AUDIENCE"),
+		RateLimitRead:           os.Getenv("RATE_LIMIT_READ"),
+		RateLimitWrite:          os.Getenv("RATE_LIMIT_WRITE"),
+		RateLimitAdmin:          os.Getenv("RATE_LIMIT_ADMIN"),
+		RateLimitAuthFailures:   getEnv("RATE_LIMIT_AUTH_FAILURES", "20/m"),
+		TLSCertFile:             os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:              os.Getenv("TLS_KEY_FILE"),
+		TLSClientCAFile:         os.Getenv("TLS_CLIENT_CA_FILE"),
+		TLSClientCertRequired:   getEnvBool("TLS_CLIENT_CERT_REQUIRED", false),
+		TLSClientIdentitiesFile: os.Getenv("TLS_CLIENT_IDENTITIES_FILE"),
+		ChangelogRetention:      getEnvDuration("CHANGELOG_RETENTION", 7*24*time.Hour),
+		ChangelogMaxRows:        getEnvInt64("CHANGELOG_MAX_ROWS", 0),
 	}
 	if cfg.Port == "" {
 		return nil, errors.New("PORT cannot be empty")
@@ -36,6 +82,15 @@ func Load() (*Config, error) {
 	if cfg.DBPath == "" {
 		return nil, errors.New("DB_PATH cannot be empty")
 	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.TLSClientCAFile != "" && cfg.TLSCertFile == "" {
+		return nil, errors.New("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	if (cfg.TLSClientCertRequired || cfg.TLSClientIdentitiesFile != "") && cfg.TLSClientCAFile == "" {
+		return nil, errors.New("TLS_CLIENT_CERT_REQUIRED and TLS_CLIENT_IDENTITIES_FILE require TLS_CLIENT_CA_FILE")
+	}
 	return cfg, nil
 }
 
@@ -66,6 +121,16 @@ func getEnvInt64(key string, def int64) int64 {
 	return def
 }
 
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return def
+}
+
 func parseCSV(s string) []string {
 	if strings.TrimSpace(s) == "" {
 		return []string{}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	// changelogPruneInterval is how often old changelog rows are removed.
+	changelogPruneInterval = time.Minute
+	// changelogPruneBatch bounds the rows removed per statement, so pruning a large
+	// backlog does not hold the write lock for long.
+	changelogPruneBatch = 5000
+)
+
+// PruneChangelog removes changelog rows older than maxAge or beyond the newest maxRows;
+// zero disables either bound. Rows a webhook of their collection has not queued yet are
+// kept whatever their age, and so is the newest row, which marks the head of the feed.
+// It returns the number of rows removed.
+func PruneChangelog(db *sql.DB, maxAge time.Duration, maxRows int64) (int64, error) {
+	var bounds []string
+	var args []any
+	if maxAge > 0 {
+		bounds = append(bounds, "at < ?")
+		args = append(args, time.Now().Add(-maxAge).Unix())
+	}
+	if maxRows > 0 {
+		bounds = append(bounds, "seq < (SELECT seq FROM changelog ORDER BY seq DESC LIMIT 1 OFFSET ?)")
+		args = append(args, maxRows-1)
+	}
+	if len(bounds) == 0 {
+		return 0, nil
+	}
+	stmt := `DELETE FROM changelog WHERE seq IN (SELECT seq FROM changelog c WHERE (` + strings.Join(bounds, " OR ") + `)
+		AND seq < (SELECT MAX(seq) FROM changelog)
+		AND NOT EXISTS (SELECT 1 FROM webhooks w WHERE w.set_name = c.set_name AND w.collection_name = c.collection_name AND w.last_seq < c.seq)
+		ORDER BY seq LIMIT ?)`
+	args = append(args, changelogPruneBatch)
+	var total int64
+	for {
+		res, err := db.Exec(stmt, args...)
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+		if n < changelogPruneBatch {
+			return total, nil
+		}
+	}
+}
+
+// RunChangelogPruner prunes the changelog (see PruneChangelog) until ctx is done.
+func RunChangelogPruner(ctx context.Context, db *sql.DB, maxAge time.Duration, maxRows int64) {
+	if maxAge <= 0 && maxRows <= 0 {
+		return
+	}
+	t := time.NewTicker(changelogPruneInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			n, err := PruneChangelog(db, maxAge, maxRows)
+			if err != nil {
+				slog.Error("changelog_prune_error", slog.String("error", err.Error()))
+			} else if n > 0 {
+				slog.Info("changelog_pruned", slog.Int64("rows", n))
+			}
+		}
+	}
+}
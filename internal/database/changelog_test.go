@@ -0,0 +1,106 @@
+package database
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"microapi/internal/config"
+)
+
+func TestPruneChangelog(t *testing.T) {
+	db, err := Open(&config.Config{DBPath: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour).Unix()
+	recent := time.Now().Unix()
+
+	reset := func() {
+		t.Helper()
+		for _, stmt := range []string{`DELETE FROM changelog`, `DELETE FROM webhooks`} {
+			if _, err := db.Exec(stmt); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// seq 1-4 are old, 5-6 recent; 1-3 and 5 are in s/a, the rest in s/b
+		for i, row := range []struct {
+			coll string
+			at   int64
+		}{{"a", old}, {"a", old}, {"a", old}, {"b", old}, {"a", recent}, {"b", recent}} {
+			if _, err := db.Exec(`INSERT INTO changelog (seq, set_name, collection_name, doc_id, op, data, version, at) VALUES (?, 's', ?, 'd', 'update', '{}', 1, ?)`,
+				i+1, row.coll, row.at); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	seqs := func() []int64 {
+		t.Helper()
+		rows, err := db.Query(`SELECT seq FROM changelog ORDER BY seq`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		out := []int64{}
+		for rows.Next() {
+			var s int64
+			if err := rows.Scan(&s); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, s)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name    string
+		maxAge  time.Duration
+		maxRows int64
+		// hook is the last_seq of a webhook on s/a, or -1 for none
+		hook int64
+		want []int64
+	}{
+		{"disabled", 0, 0, -1, []int64{1, 2, 3, 4, 5, 6}},
+		{"by age", 24 * time.Hour, 0, -1, []int64{5, 6}},
+		{"by count", 0, 3, -1, []int64{4, 5, 6}},
+		{"by age or count", 24 * time.Hour, 1, -1, []int64{6}},
+		{"keeps rows a webhook has not queued", 24 * time.Hour, 0, 1, []int64{2, 3, 5, 6}},
+		{"webhooks only hold their collection", 0, 1, 3, []int64{5, 6}},
+	}
+	for _, tt := range tests {
+		reset()
+		if tt.hook >= 0 {
+			if _, err := db.Exec(`INSERT INTO webhooks (id, set_name, collection_name, url, secret, events, last_seq, created_at) VALUES ('h', 's', 'a', 'http://x', 's', 'update', ?, 0)`, tt.hook); err != nil {
+				t.Fatal(err)
+			}
+		}
+		n, err := PruneChangelog(db, tt.maxAge, tt.maxRows)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got := seqs()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: kept %v, want %v", tt.name, got, tt.want)
+		}
+		if n != int64(6-len(got)) {
+			t.Errorf("%s: reported %d rows removed, want %d", tt.name, n, 6-len(got))
+		}
+	}
+
+	// only the newest row survives when everything is old
+	reset()
+	if _, err := db.Exec(`UPDATE changelog SET at = ?`, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PruneChangelog(db, time.Hour, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := seqs(); !reflect.DeepEqual(got, []int64{6}) {
+		t.Errorf("all old: kept %v, want [6]", got)
+	}
+}
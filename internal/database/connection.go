@@ -11,7 +11,12 @@ import (
 )
 
 func Open(cfg *config.Config) (*sql.DB, error) {
-	dsn := fmt.Sprintf("file:%s?_busy_timeout=5000&_pragma=journal_mode(WAL)&_pragma=foreign_keys(ON)&_pragma=synchronous(NORMAL)", cfg.DBPath)
+	if err := registerRegexp(); err != nil {
+		return nil, err
+	}
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(ON)&_pragma=synchronous(NORMAL)&_txlock=immediate", cfg.DBPath)
+	// immediate transactions take the write lock up front, so read-then-write batches
+	// wait on busy_timeout instead of failing when another writer commits first
 	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,222 @@
+package database
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"microapi/internal/query"
+)
+
+// ErrFullTextNotFound is returned when a collection has no full-text index.
+var ErrFullTextNotFound = errors.New("no full-text index for this collection")
+
+// Tokenizers accepted for full-text indexes, by the name clients use.
+var tokenizers = map[string]string{
+	"unicode61": "unicode61 remove_diacritics 2",
+	"porter":    "porter unicode61 remove_diacritics 2",
+	"trigram":   "trigram",
+}
+
+// FullTextIndex declares which fields of a collection are searchable. Column i of the FTS5
+// table holds Fields[i].
+type FullTextIndex struct {
+	Fields    []string `json:"fields"`
+	Tokenizer string   `json:"tokenizer"`
+	CreatedAt int64    `json:"created_at"`
+}
+
+// Columns maps each field to its column in the FTS5 table.
+func (f *FullTextIndex) Columns() map[string]string {
+	cols := make(map[string]string, len(f.Fields))
+	for i, p := range f.Fields {
+		cols[p] = fmt.Sprintf("c%d", i)
+	}
+	return cols
+}
+
+// FullTextTable returns the FTS5 table of a collection. The collection is hashed so any
+// pair of set and collection names gives a distinct, valid table name.
+func FullTextTable(set, collection string) string {
+	sum := sha1.Sum([]byte(collection))
+	return fmt.Sprintf("fts_%s_%s", set, hex.EncodeToString(sum[:])[:10])
+}
+
+// SetFullText (re)builds the full-text index of a collection over fields, which must be
+// normalized JSONPaths. Index rows share the rowid of their document, the rid column of the
+// set table. Triggers keep it in step with every write; the documents already stored are
+// indexed before this returns.
+func SetFullText(db *sql.DB, set, collection string, fields []string, tokenizer string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("at least one field is required")
+	}
+	if tokenizer == "" {
+		tokenizer = "unicode61"
+	}
+	tok, ok := tokenizers[tokenizer]
+	if !ok {
+		return fmt.Errorf("tokenizer must be one of unicode61, porter, trigram")
+	}
+	fts := FullTextTable(set, collection)
+	cols := make([]string, len(fields))
+	newVals := make([]string, len(fields))
+	for i, p := range fields {
+		cols[i] = fmt.Sprintf("c%d", i)
+		newVals[i] = fmt.Sprintf("json_extract(NEW.data, '%s')", strings.ReplaceAll(p, "'", "''"))
+	}
+	colList, newList := strings.Join(cols, ", "), strings.Join(newVals, ", ")
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := dropFullText(tx, set, collection); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`
+	CREATE VIRTUAL TABLE %[1]s USING fts5(%[2]s, tokenize = '%[3]s');
+	CREATE TRIGGER trg_%[1]s_insert AFTER INSERT ON %[4]s WHEN NEW.collection = '%[5]s' BEGIN
+		INSERT INTO %[1]s (rowid, %[2]s) VALUES (NEW.rowid, %[6]s);
+	END;
+	CREATE TRIGGER trg_%[1]s_update AFTER UPDATE OF data ON %[4]s WHEN NEW.collection = '%[5]s' BEGIN
+		DELETE FROM %[1]s WHERE rowid = OLD.rowid;
+		INSERT INTO %[1]s (rowid, %[2]s) VALUES (NEW.rowid, %[6]s);
+	END;
+	CREATE TRIGGER trg_%[1]s_delete AFTER DELETE ON %[4]s WHEN OLD.collection = '%[5]s' BEGIN
+		DELETE FROM %[1]s WHERE rowid = OLD.rowid;
+	END;
+	`, fts, colList, tok, tableName(set), collection, newList)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (rowid, %s) SELECT rowid, %s FROM %s WHERE collection = ?`,
+		fts, colList, strings.ReplaceAll(newList, "NEW.data", "data"), tableName(set)), collection); err != nil {
+		return err
+	}
+	b, _ := json.Marshal(fields)
+	if _, err := tx.Exec(`INSERT INTO fulltext (set_name, collection_name, fields, tokenizer, created_at) VALUES (?, ?, ?, ?, ?)`,
+		set, collection, string(b), tokenizer, time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetFullText returns the full-text index of a collection, or ErrFullTextNotFound.
+func GetFullText(db *sql.DB, set, collection string) (*FullTextIndex, error) {
+	var f FullTextIndex
+	var fields string
+	err := db.QueryRow(`SELECT fields, tokenizer, created_at FROM fulltext WHERE set_name = ? AND collection_name = ?`, set, collection).
+		Scan(&fields, &f.Tokenizer, &f.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrFullTextNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(fields), &f.Fields); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Indexes lets where clauses on a collection use its full-text and geo indexes (see
+// query.ParseWhereWith).
+func Indexes(db *sql.DB, set, collection string) query.Indexes {
+	return collectionIndexes{db: db, set: set, collection: collection}
+}
+
+type collectionIndexes struct {
+	db              *sql.DB
+	set, collection string
+}
+
+func (c collectionIndexes) FullText() (*query.FullText, error) {
+	f, err := GetFullText(c.db, c.set, c.collection)
+	if err == ErrFullTextNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &query.FullText{Table: FullTextTable(c.set, c.collection), Columns: f.Columns()}, nil
+}
+
+// GeoTable returns the geo index on path once it is ready.
+func (c collectionIndexes) GeoTable(path string) (string, error) {
+	var name string
+	err := c.db.QueryRow(`SELECT idx_name FROM idx_metadata WHERE set_name = ? AND collection_name = ? AND kind = 'geo' AND paths = ? AND status = 'ready'`,
+		c.set, c.collection, path).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return name, err
+}
+
+// DeleteFullText drops the full-text index of a collection.
+func DeleteFullText(db *sql.DB, set, collection string) error {
+	if _, err := GetFullText(db, set, collection); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := dropFullText(tx, set, collection); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DropSetFullText drops every full-text index of a set, for when the set is deleted.
+func DropSetFullText(db *sql.DB, set string) error {
+	rows, err := db.Query(`SELECT collection_name FROM fulltext WHERE set_name = ?`, set)
+	if err != nil {
+		return err
+	}
+	var collections []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err == nil {
+			collections = append(collections, c)
+		}
+	}
+	rows.Close()
+	for _, c := range collections {
+		if _, err := db.Exec("DROP TABLE IF EXISTS " + FullTextTable(set, c)); err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec(`DELETE FROM fulltext WHERE set_name = ?`, set)
+	return err
+}
+
+func dropFullText(tx *sql.Tx, set, collection string) error {
+	fts := FullTextTable(set, collection)
+	_, err := tx.Exec(fmt.Sprintf(`
+	DROP TRIGGER IF EXISTS trg_%[1]s_insert;
+	DROP TRIGGER IF EXISTS trg_%[1]s_update;
+	DROP TRIGGER IF EXISTS trg_%[1]s_delete;
+	DROP TABLE IF EXISTS %[1]s;
+	`, fts))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM fulltext WHERE set_name = ? AND collection_name = ?`, set, collection)
+	return err
+}
+
+// IsSearchSyntax reports whether err is FTS5 rejecting a MATCH expression. The where parser
+// quotes search strings, so this only guards queries it did not build.
+func IsSearchSyntax(err error) bool {
+	if err == nil {
+		return false
+	}
+	s := err.Error()
+	return strings.Contains(s, "fts5: syntax error") || strings.Contains(s, "unterminated string") || strings.Contains(s, "unknown special query")
+}
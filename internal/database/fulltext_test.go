@@ -0,0 +1,166 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestIsSearchSyntax(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE VIRTUAL TABLE ft USING fts5(c0)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO ft (c0) VALUES ('the quick brown fox')`); err != nil {
+		t.Fatal(err)
+	}
+	search := func(match string) error {
+		rows, err := db.Query(`SELECT rowid FROM ft WHERE ft MATCH ?`, match)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+		}
+		return rows.Err()
+	}
+	for _, match := range []string{`quick AND`, `"unterminated`, `NEAR(quick`, `quick OR OR fox`, `*`} {
+		err := search(match)
+		if !IsSearchSyntax(err) {
+			t.Errorf("MATCH %s: IsSearchSyntax(%v) = false", match, err)
+		}
+	}
+	if err := search(`"quick" "fox"`); err != nil {
+		t.Errorf("MATCH \"quick\" \"fox\": %v", err)
+	}
+	if IsSearchSyntax(sql.ErrNoRows) {
+		t.Error("IsSearchSyntax(sql.ErrNoRows) = true")
+	}
+}
+
+// openSetDB returns a migrated in-memory database.
+func openSetDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func searchIDs(t *testing.T, db *sql.DB, set, collection, match string) []string {
+	t.Helper()
+	rows, err := db.Query(fmt.Sprintf(`SELECT id FROM %s WHERE rowid IN (SELECT rowid FROM %[2]s WHERE %[2]s MATCH ?) ORDER BY id`,
+		tableName(set), FullTextTable(set, collection)), match)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func countRows(t *testing.T, db *sql.DB, q string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(q, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// A set table created before rid existed is rebuilt with the same rowids, so its full-text
+// index still finds the right documents, before and after a VACUUM.
+func TestMigrateRowidKey(t *testing.T) {
+	db := openSetDB(t)
+	if _, err := db.Exec(`
+	CREATE TABLE data_s (
+		id TEXT PRIMARY KEY,
+		collection TEXT NOT NULL,
+		data JSON NOT NULL,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		version INTEGER NOT NULL DEFAULT 1
+	);
+	CREATE INDEX idx_s_collection ON data_s(collection);`); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureChangeTriggers(db, "s"); err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range [][2]string{{"a", "red fox"}, {"b", "brown fox"}, {"c", "lazy dog"}, {"d", "quick fox"}} {
+		if _, err := db.Exec(`INSERT INTO data_s (id, collection, data, created_at, updated_at) VALUES (?, 'c', json_object('t', ?), 1, 1)`, doc[0], doc[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// leave a gap in the rowids for VACUUM to close
+	if _, err := db.Exec(`DELETE FROM data_s WHERE id IN ('a', 'c')`); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetFullText(db, "s", "c", []string{"$.t"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	changes := countRows(t, db, `SELECT COUNT(*) FROM changelog`)
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, `SELECT COUNT(*) FROM pragma_table_info('data_s') WHERE name = 'rid' AND pk = 1`); n != 1 {
+		t.Fatal("data_s has no rid primary key after Migrate")
+	}
+	if n := countRows(t, db, `SELECT COUNT(*) FROM data_s WHERE (id = 'b' AND rid = 2) OR (id = 'd' AND rid = 4)`); n != 2 {
+		t.Error("rowids changed in the rebuild")
+	}
+	if n := countRows(t, db, `SELECT COUNT(*) FROM changelog`); n != changes {
+		t.Errorf("the rebuild wrote %d changes", n-changes)
+	}
+	if n := countRows(t, db, `SELECT COUNT(*) FROM sqlite_master WHERE tbl_name = 'data_s' AND name = 'idx_s_collection'`); n != 1 {
+		t.Error("idx_s_collection was not recreated")
+	}
+	// the change and full-text triggers came back with the table
+	if _, err := db.Exec(`INSERT INTO data_s (id, collection, data, created_at, updated_at) VALUES ('e', 'c', json_object('t', 'fox cub'), 2, 2)`); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, `SELECT COUNT(*) FROM changelog`); n != changes+1 {
+		t.Errorf("changelog has %d new entries, want 1", n-changes)
+	}
+	want := "[b d e]"
+	if got := fmt.Sprint(searchIDs(t, db, "s", "c", "fox")); got != want {
+		t.Errorf("search fox = %s, want %s", got, want)
+	}
+	if _, err := db.Exec(`VACUUM`); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(searchIDs(t, db, "s", "c", "fox")); got != want {
+		t.Errorf("search fox after VACUUM = %s, want %s", got, want)
+	}
+
+	// a second Migrate leaves the table alone
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, `SELECT COUNT(*) FROM data_s`); n != 3 {
+		t.Errorf("data_s has %d rows, want 3", n)
+	}
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"modernc.org/sqlite"
+
+	"microapi/internal/query"
+)
+
+// The functions below back the geo operators of where clauses and the geo index triggers.
+// Points are document values in any form query.ParsePoint reads; anything else gives NULL.
+//
+//	geo_lat(point), geo_lng(point)              coordinates in degrees
+//	geo_distance(point, lat, lng)               great-circle distance in meters
+//	geo_within_box(point, s, w, n, e)           1 when inside the box, else 0
+//	geo_within_polygon(point, ring)             1 when inside the ring of [lng, lat] pairs, else 0
+func init() {
+	point := func(v driver.Value) (query.Point, bool) {
+		s, ok := v.(string)
+		if !ok {
+			return query.Point{}, false
+		}
+		return query.ParsePointJSON(s)
+	}
+	num := func(v driver.Value) float64 {
+		switch t := v.(type) {
+		case float64:
+			return t
+		case int64:
+			return float64(t)
+		}
+		return 0
+	}
+	sqlite.MustRegisterDeterministicScalarFunction("geo_lat", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		if p, ok := point(args[0]); ok {
+			return p.Lat, nil
+		}
+		return nil, nil
+	})
+	sqlite.MustRegisterDeterministicScalarFunction("geo_lng", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		if p, ok := point(args[0]); ok {
+			return p.Lng, nil
+		}
+		return nil, nil
+	})
+	sqlite.MustRegisterDeterministicScalarFunction("geo_distance", 3, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		p, ok := point(args[0])
+		if !ok {
+			return nil, nil
+		}
+		return query.Distance(p, query.Point{Lat: num(args[1]), Lng: num(args[2])}), nil
+	})
+	sqlite.MustRegisterDeterministicScalarFunction("geo_within_box", 5, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		p, ok := point(args[0])
+		if !ok {
+			return nil, nil
+		}
+		return query.Box{South: num(args[1]), West: num(args[2]), North: num(args[3]), East: num(args[4])}.Contains(p), nil
+	})
+	sqlite.MustRegisterDeterministicScalarFunction("geo_within_polygon", 2, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		p, ok := point(args[0])
+		if !ok {
+			return nil, nil
+		}
+		ring, _ := args[1].(string)
+		pg, err := cachedPolygon(ring)
+		if err != nil {
+			return nil, err
+		}
+		return pg.Contains(p), nil
+	})
+}
+
+type polygonEntry struct {
+	ring string
+	pg   query.Polygon
+}
+
+// lastPolygon saves parsing the same ring for every row a query tests.
+var lastPolygon atomic.Pointer[polygonEntry]
+
+func cachedPolygon(ring string) (query.Polygon, error) {
+	if e := lastPolygon.Load(); e != nil && e.ring == ring {
+		return e.pg, nil
+	}
+	var v any
+	if err := json.Unmarshal([]byte(ring), &v); err != nil {
+		return nil, err
+	}
+	pg, err := query.ParsePolygon(v)
+	if err != nil {
+		return nil, err
+	}
+	lastPolygon.Store(&polygonEntry{ring: ring, pg: pg})
+	return pg, nil
+}
+
+// GeoIndexName returns the name of the geo index on path, which is also its R*Tree table.
+func GeoIndexName(set, collection, path string) string {
+	sum := sha1.Sum([]byte(collection + "|" + path))
+	return fmt.Sprintf("geo_%s_%s", set, hex.EncodeToString(sum[:])[:10])
+}
+
+// CreateGeoIndexMetadata records a geo index with status creating.
+func CreateGeoIndexMetadata(db *sql.DB, set, collection, idxName, path string) error {
+	return upsertIndexMetadata(db, set, collection, idxName, path, "geo", nil)
+}
+
+// CreateGeoIndex (re)builds the R*Tree of a geo index from the documents of the collection.
+// Rows are keyed by the rowid of the set table, its rid column, like full-text indexes, and
+// triggers keep them in step with every write. Documents without a point at path are left out.
+func CreateGeoIndex(db *sql.DB, set, collection, idxName, path string) error {
+	pt := fmt.Sprintf("json_extract(NEW.data, '%s')", strings.ReplaceAll(path, "'", "''"))
+	ins := fmt.Sprintf(`INSERT INTO %[1]s (id, min_lat, max_lat, min_lng, max_lng) SELECT NEW.rowid, lat, lat, lng, lng FROM (SELECT geo_lat(%[2]s) AS lat, geo_lng(%[2]s) AS lng) WHERE lat IS NOT NULL`, idxName, pt)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := dropTableIndex(tx, idxName); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`
+	CREATE VIRTUAL TABLE %[1]s USING rtree(id, min_lat, max_lat, min_lng, max_lng);
+	CREATE TRIGGER trg_%[1]s_insert AFTER INSERT ON %[2]s WHEN NEW.collection = '%[3]s' BEGIN
+		%[4]s;
+	END;
+	CREATE TRIGGER trg_%[1]s_update AFTER UPDATE OF data ON %[2]s WHEN NEW.collection = '%[3]s' BEGIN
+		DELETE FROM %[1]s WHERE id = OLD.rowid;
+		%[4]s;
+	END;
+	CREATE TRIGGER trg_%[1]s_delete AFTER DELETE ON %[2]s WHEN OLD.collection = '%[3]s' BEGIN
+		DELETE FROM %[1]s WHERE id = OLD.rowid;
+	END;
+	`, idxName, tableName(set), collection, ins)); err != nil {
+		return err
+	}
+	backfill := fmt.Sprintf(`INSERT INTO %[1]s (id, min_lat, max_lat, min_lng, max_lng) SELECT rid, lat, lat, lng, lng FROM (SELECT rowid AS rid, geo_lat(%[2]s) AS lat, geo_lng(%[2]s) AS lng FROM %[3]s WHERE collection = ?) WHERE lat IS NOT NULL`,
+		idxName, strings.ReplaceAll(pt, "NEW.data", "data"), tableName(set))
+	if _, err := tx.Exec(backfill, collection); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
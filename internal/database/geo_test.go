@@ -0,0 +1,75 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+)
+
+// The R*Tree of a geo index is keyed by rowid, so it must survive the rid rebuild of an
+// old set table and a VACUUM.
+func TestGeoIndexRowidKey(t *testing.T) {
+	db := openSetDB(t)
+	if _, err := db.Exec(`
+	CREATE TABLE data_s (
+		id TEXT PRIMARY KEY,
+		collection TEXT NOT NULL,
+		data JSON NOT NULL,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		version INTEGER NOT NULL DEFAULT 1
+	)`); err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range []string{"a", "b", "c", "d"} {
+		if _, err := db.Exec(`INSERT INTO data_s (id, collection, data, created_at, updated_at) VALUES (?, 'c', json_object('loc', json_object('lat', ?, 'lng', 4)), 1, 1)`, id, 50+i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`DELETE FROM data_s WHERE id IN ('a', 'c')`); err != nil {
+		t.Fatal(err)
+	}
+	idx := GeoIndexName("s", "c", "$.loc")
+	if err := CreateGeoIndex(db, "s", "c", idx, "$.loc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	// the trigger still maintains the index after the rebuild
+	if _, err := db.Exec(`INSERT INTO data_s (id, collection, data, created_at, updated_at) VALUES ('e', 'c', json_object('loc', json_object('lat', 54, 'lng', 4)), 2, 2)`); err != nil {
+		t.Fatal(err)
+	}
+	near := func(lat float64) []string {
+		t.Helper()
+		rows, err := db.Query(fmt.Sprintf(`SELECT id FROM data_s WHERE rowid IN (SELECT id FROM %s WHERE min_lat <= ? AND max_lat >= ?) ORDER BY id`, idx), lat+0.5, lat-0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return ids
+	}
+	check := func(when string) {
+		t.Helper()
+		for lat, want := range map[float64]string{50: "[]", 51: "[b]", 52: "[]", 53: "[d]", 54: "[e]"} {
+			if got := fmt.Sprint(near(lat)); got != want {
+				t.Errorf("%s: points at lat %v = %s, want %s", when, lat, got, want)
+			}
+		}
+	}
+	check("after Migrate")
+	if _, err := db.Exec(`VACUUM`); err != nil {
+		t.Fatal(err)
+	}
+	check("after VACUUM")
+}
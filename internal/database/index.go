@@ -82,23 +82,26 @@ func DropSQLIndex(db *sql.DB, idxName string) error {
 }
 
 func ListIndexes(db *sql.DB, set, collection string) ([]map[string]any, error) {
-	rows, err := db.Query(`SELECT idx_name, paths, status, error, usage_count, last_used_at, created_at FROM idx_metadata WHERE set_name = ? AND collection_name = ? ORDER BY created_at DESC`, set, collection)
+	rows, err := db.Query(`SELECT idx_name, paths, status, error, usage_count, last_used_at, created_at, kind, dimensions FROM idx_metadata WHERE set_name = ? AND collection_name = ? ORDER BY created_at DESC`, set, collection)
 	if err != nil { return nil, err }
 	defer rows.Close()
 	var out []map[string]any
 	for rows.Next() {
-		var name, paths, status, errtxt sql.NullString
-		var usage, last, created sql.NullInt64
-		_ = rows.Scan(&name, &paths, &status, &errtxt, &usage, &last, &created)
-		out = append(out, map[string]any{
+		var name, paths, status, errtxt, kind sql.NullString
+		var usage, last, created, dims sql.NullInt64
+		_ = rows.Scan(&name, &paths, &status, &errtxt, &usage, &last, &created, &kind, &dims)
+		idx := map[string]any{
 			"name":         name.String,
+			"type":         kind.String,
 			"paths":        strings.Split(paths.String, ","),
 			"status":       status.String,
 			"error":        errtxt.String,
 			"usage_count":  usage.Int64,
 			"last_used_at": last.Int64,
 			"created_at":   created.Int64,
-		})
+		}
+		if dims.Valid { idx["dimensions"] = dims.Int64 }
+		out = append(out, idx)
 	}
 	return out, nil
 }
@@ -106,7 +109,7 @@ func ListIndexes(db *sql.DB, set, collection string) ([]map[string]any, error) {
 func UpdateIndexUsage(db *sql.DB, set, collection string, usedPaths []string) {
 	if len(usedPaths) == 0 { return }
 	// Fetch existing indexes
-	rows, err := db.Query(`SELECT idx_name, paths FROM idx_metadata WHERE set_name = ? AND collection_name = ? AND status = 'ready'`, set, collection)
+	rows, err := db.Query(`SELECT idx_name, paths FROM idx_metadata WHERE set_name = ? AND collection_name = ? AND status = 'ready' AND kind != 'vector'`, set, collection)
 	if err != nil { return }
 	defer rows.Close()
 	used := make(map[string]struct{}, len(usedPaths))
@@ -125,3 +128,63 @@ func UpdateIndexUsage(db *sql.DB, set, collection string, usedPaths []string) {
 		}
 	}
 }
+
+// MarkIndexUsed counts a use of the named index, for indexes not picked by path matching.
+func MarkIndexUsed(db *sql.DB, set, collection, idxName string) {
+	_, _ = db.Exec(`UPDATE idx_metadata SET usage_count = usage_count + 1, last_used_at = ? WHERE set_name = ? AND collection_name = ? AND idx_name = ?`, time.Now().Unix(), set, collection, idxName)
+}
+
+// upsertIndexMetadata records a vector or geo index on path with status creating, resetting
+// an existing one for a rebuild.
+func upsertIndexMetadata(db *sql.DB, set, collection, idxName, path, kind string, dims any) error {
+	_, err := db.Exec(`INSERT INTO idx_metadata (set_name, collection_name, idx_name, paths, status, created_at, kind, dimensions) VALUES (?, ?, ?, ?, 'creating', ?, ?, ?)
+		ON CONFLICT (set_name, collection_name, idx_name) DO UPDATE SET status = 'creating', error = NULL, dimensions = excluded.dimensions`,
+		set, collection, idxName, path, time.Now().Unix(), kind, dims)
+	return err
+}
+
+// DropTableIndex drops the table and triggers of a vector or geo index.
+func DropTableIndex(db *sql.DB, idxName string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := dropTableIndex(tx, idxName); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DropSetTableIndexes drops every vector and geo index of a set, for when the set is deleted.
+func DropSetTableIndexes(db *sql.DB, set string) error {
+	rows, err := db.Query(`SELECT idx_name FROM idx_metadata WHERE set_name = ? AND kind IN ('vector', 'geo')`, set)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for rows.Next() {
+		var n string
+		if err := rows.Scan(&n); err == nil {
+			names = append(names, n)
+		}
+	}
+	rows.Close()
+	for _, n := range names {
+		if _, err := db.Exec("DROP TABLE IF EXISTS " + n); err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec(`DELETE FROM idx_metadata WHERE set_name = ? AND kind IN ('vector', 'geo')`, set)
+	return err
+}
+
+func dropTableIndex(tx *sql.Tx, idxName string) error {
+	_, err := tx.Exec(fmt.Sprintf(`
+	DROP TRIGGER IF EXISTS trg_%[1]s_insert;
+	DROP TRIGGER IF EXISTS trg_%[1]s_update;
+	DROP TRIGGER IF EXISTS trg_%[1]s_delete;
+	DROP TABLE IF EXISTS %[1]s;
+	`, idxName))
+	return err
+}
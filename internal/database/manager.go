@@ -11,18 +11,51 @@ func tableName(set string) string { return fmt.Sprintf("data_%s", set) }
 // TableName exposes the underlying physical table name for a set.
 func TableName(set string) string { return tableName(set) }
 
-func EnsureSetTable(db *sql.DB, set string) error {
-	_, err := db.Exec(fmt.Sprintf(`
-	CREATE TABLE IF NOT EXISTS %s (
-		id TEXT PRIMARY KEY,
+// setTableColumns is the layout of a set table. rid makes the rowid an INTEGER PRIMARY
+// KEY, whose values VACUUM keeps: full-text and geo indexes link documents by rowid.
+const setTableColumns = `
+		rid INTEGER PRIMARY KEY,
+		id TEXT NOT NULL UNIQUE,
 		collection TEXT NOT NULL,
 		data JSON NOT NULL,
 		created_at INTEGER NOT NULL,
-		updated_at INTEGER NOT NULL
-	);
+		updated_at INTEGER NOT NULL,
+		-- bumped on every write; exposed as the document ETag
+		version INTEGER NOT NULL DEFAULT 1
+	`
+
+func EnsureSetTable(db *sql.DB, set string) error {
+	_, err := db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (%s);
 	CREATE INDEX IF NOT EXISTS idx_%s_collection ON %s(collection);
 	CREATE INDEX IF NOT EXISTS idx_%s_collection_created ON %s(collection, created_at DESC);
-	`, tableName(set), set, tableName(set), set, tableName(set)))
+	`, tableName(set), setTableColumns, set, tableName(set), set, tableName(set)))
+	if err != nil {
+		return err
+	}
+	if err := ensureChangeTriggers(db, set); err != nil {
+		return err
+	}
+	return ensureQuotaTriggers(db, set)
+}
+
+// ensureChangeTriggers records every insert, update and delete on a set table in the
+// changelog, so writes from any code path (REST, MCP, transactions) reach the change feed.
+func ensureChangeTriggers(db *sql.DB, set string) error {
+	_, err := db.Exec(fmt.Sprintf(`
+	CREATE TRIGGER IF NOT EXISTS trg_%[1]s_changes_insert AFTER INSERT ON %[2]s BEGIN
+		INSERT INTO changelog (set_name, collection_name, doc_id, op, data, version, at)
+		VALUES ('%[1]s', NEW.collection, NEW.id, 'create', NEW.data, NEW.version, NEW.updated_at);
+	END;
+	CREATE TRIGGER IF NOT EXISTS trg_%[1]s_changes_update AFTER UPDATE ON %[2]s BEGIN
+		INSERT INTO changelog (set_name, collection_name, doc_id, op, data, version, at)
+		VALUES ('%[1]s', NEW.collection, NEW.id, 'update', NEW.data, NEW.version, NEW.updated_at);
+	END;
+	CREATE TRIGGER IF NOT EXISTS trg_%[1]s_changes_delete AFTER DELETE ON %[2]s BEGIN
+		INSERT INTO changelog (set_name, collection_name, doc_id, op, data, version, at)
+		VALUES ('%[1]s', OLD.collection, OLD.id, 'delete', OLD.data, OLD.version, unixepoch());
+	END;
+	`, set, tableName(set)))
 	return err
 }
 
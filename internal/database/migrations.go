@@ -2,6 +2,8 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 )
 
 func Migrate(db *sql.DB) error {
@@ -25,6 +27,8 @@ func Migrate(db *sql.DB) error {
 		usage_count INTEGER NOT NULL DEFAULT 0,
 		last_used_at INTEGER,
 		created_at INTEGER NOT NULL,
+		kind TEXT NOT NULL DEFAULT 'btree', -- btree | vector | geo
+		dimensions INTEGER, -- vector indexes only
 		PRIMARY KEY (set_name, collection_name, idx_name)
 	);
 
@@ -36,6 +40,207 @@ func Migrate(db *sql.DB) error {
 		updated_at INTEGER NOT NULL,
 		PRIMARY KEY (set_name, collection_name)
 	);
+
+	-- Document-level access rules (see internal/rules), one JSON object per collection.
+	CREATE TABLE IF NOT EXISTS rules (
+		set_name TEXT NOT NULL,
+		collection_name TEXT NOT NULL,
+		rules JSON NOT NULL,
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (set_name, collection_name)
+	);
+
+	-- Change feed: one row per document write, filled by triggers on the set tables.
+	-- AUTOINCREMENT keeps seq strictly increasing so clients can resume after it.
+	CREATE TABLE IF NOT EXISTS changelog (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		set_name TEXT NOT NULL,
+		collection_name TEXT NOT NULL,
+		doc_id TEXT NOT NULL,
+		op TEXT NOT NULL, -- create | update | delete
+		-- document after the write, or before it for deletes
+		data JSON,
+		version INTEGER NOT NULL,
+		at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_changelog_collection ON changelog(set_name, collection_name, seq);
+
+	-- API keys; only the SHA-256 of each key is stored
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		scopes JSON NOT NULL, -- [{"set": "*", "collection": "", "access": "read|write|admin"}]
+		created_at INTEGER NOT NULL,
+		last_used_at INTEGER,
+		revoked_at INTEGER
+	);
+
+	-- Claim rules per collection: where objects restricting JWT callers (see auth.ClaimRules)
+	CREATE TABLE IF NOT EXISTS claim_rules (
+		set_name TEXT NOT NULL,
+		collection_name TEXT NOT NULL,
+		rules JSON NOT NULL,
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (set_name, collection_name)
+	);
+
+	-- Optional storage quotas per set; NULL means no limit
+	CREATE TABLE IF NOT EXISTS quotas (
+		set_name TEXT PRIMARY KEY,
+		max_documents INTEGER,
+		max_bytes INTEGER,
+		updated_at INTEGER NOT NULL
+	);
+
+	-- Documents and bytes stored per set, kept up to date by triggers on the set tables
+	CREATE TABLE IF NOT EXISTS set_usage (
+		set_name TEXT PRIMARY KEY,
+		documents INTEGER NOT NULL DEFAULT 0,
+		bytes INTEGER NOT NULL DEFAULT 0
+	);
+
+	-- Full-text indexes; each has an FTS5 table fts_<set>_<hash> fed by triggers
+	CREATE TABLE IF NOT EXISTS fulltext (
+		set_name TEXT NOT NULL,
+		collection_name TEXT NOT NULL,
+		fields JSON NOT NULL, -- JSONPaths, one per FTS5 column
+		tokenizer TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (set_name, collection_name)
+	);
+
+	-- Outgoing webhooks per collection; last_seq is the changelog position already queued
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		set_name TEXT NOT NULL,
+		collection_name TEXT NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL, -- comma-separated: create,update,delete
+		last_seq INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhooks_collection ON webhooks(set_name, collection_name);
+
+	-- Delivery log; status 'dead' rows form the dead-letter list
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		hook_id TEXT NOT NULL,
+		seq INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		payload JSON NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending', -- pending | delivered | dead
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at INTEGER NOT NULL,
+		response_code INTEGER,
+		last_error TEXT,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending ON webhook_deliveries(status, next_attempt_at);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_hook ON webhook_deliveries(hook_id, id);
 	`)
+	if err != nil {
+		return err
+	}
+	if err := addColumn(db, "idx_metadata", "kind", "TEXT NOT NULL DEFAULT 'btree'"); err != nil {
+		return err
+	}
+	if err := addColumn(db, "idx_metadata", "dimensions", "INTEGER"); err != nil {
+		return err
+	}
+	return migrateSetTables(db)
+}
+
+// addColumn adds a column to a table created by an older version, if it lacks it.
+func addColumn(db *sql.DB, table, column, decl string) error {
+	var n int
+	if err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM pragma_table_info('%s') WHERE name = ?`, table), column).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, decl))
 	return err
 }
+
+// migrateSetTables brings set tables created by older versions up to the current layout.
+func migrateSetTables(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE 'data\_%' ESCAPE '\'`)
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			tables = append(tables, name)
+		}
+	}
+	rows.Close()
+	for _, t := range tables {
+		if err := ensureChangeTriggers(db, strings.TrimPrefix(t, "data_")); err != nil {
+			return err
+		}
+		if err := addColumn(db, t, "version", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+			return err
+		}
+		if err := addRowidKey(db, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addRowidKey rebuilds a set table created without rid, the INTEGER PRIMARY KEY that keeps
+// rowids stable across VACUUM. Rows keep their rowids, so full-text and geo indexes stay
+// linked, and the indexes and triggers of the table are recreated as they were.
+func addRowidKey(db *sql.DB, table string) error {
+	var n int
+	if err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM pragma_table_info('%s') WHERE name = 'rid'`, table)).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`SELECT sql FROM sqlite_master WHERE tbl_name = ? AND type IN ('index', 'trigger') AND sql IS NOT NULL`, table)
+	if err != nil {
+		return err
+	}
+	var schema []string
+	for rows.Next() {
+		var stmt string
+		if err := rows.Scan(&stmt); err != nil {
+			rows.Close()
+			return err
+		}
+		schema = append(schema, stmt)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	// the copy goes into a table without triggers, so it is not a change or a write
+	tmp := table + "_rebuild"
+	stmts := []string{
+		fmt.Sprintf(`CREATE TABLE %s (%s)`, tmp, setTableColumns),
+		fmt.Sprintf(`INSERT INTO %s (rid, id, collection, data, created_at, updated_at, version)
+			SELECT rowid, id, collection, data, created_at, updated_at, version FROM %s`, tmp, table),
+		fmt.Sprintf(`DROP TABLE %s`, table),
+		fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`, tmp, table),
+	}
+	for _, stmt := range append(stmts, schema...) {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
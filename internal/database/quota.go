@@ -0,0 +1,130 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// quotaMessage is raised by the quota triggers; IsQuotaExceeded recognizes it.
+const quotaMessage = "set quota exceeded"
+
+// ErrQuotaNotFound is returned when a set has no quota.
+var ErrQuotaNotFound = errors.New("no quota for this set")
+
+// Quota limits how much a set may store. A nil field is not limited.
+type Quota struct {
+	MaxDocuments *int64 `json:"max_documents"`
+	MaxBytes     *int64 `json:"max_bytes"`
+}
+
+// Usage is what a set currently stores; Bytes counts the JSON text of its documents.
+type Usage struct {
+	Documents int64 `json:"documents"`
+	Bytes     int64 `json:"bytes"`
+}
+
+// SetQuota stores the quota of a set. Documents already stored above it stay; further
+// inserts, and updates that grow documents, are rejected until usage drops.
+func SetQuota(db *sql.DB, set string, q Quota) error {
+	if (q.MaxDocuments != nil && *q.MaxDocuments < 0) || (q.MaxBytes != nil && *q.MaxBytes < 0) {
+		return fmt.Errorf("quota limits cannot be negative")
+	}
+	_, err := db.Exec(`INSERT INTO quotas (set_name, max_documents, max_bytes, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(set_name) DO UPDATE SET max_documents = excluded.max_documents, max_bytes = excluded.max_bytes, updated_at = excluded.updated_at`,
+		set, q.MaxDocuments, q.MaxBytes, time.Now().Unix())
+	return err
+}
+
+// GetQuota returns the quota of a set, or ErrQuotaNotFound.
+func GetQuota(db *sql.DB, set string) (*Quota, error) {
+	var q Quota
+	err := db.QueryRow(`SELECT max_documents, max_bytes FROM quotas WHERE set_name = ?`, set).Scan(&q.MaxDocuments, &q.MaxBytes)
+	if err == sql.ErrNoRows {
+		return nil, ErrQuotaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// DeleteQuota lifts the quota of a set.
+func DeleteQuota(db *sql.DB, set string) error {
+	res, err := db.Exec(`DELETE FROM quotas WHERE set_name = ?`, set)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrQuotaNotFound
+	}
+	return nil
+}
+
+// GetUsage returns what a set stores. Call EnsureSetTable first so the counters exist.
+func GetUsage(db *sql.DB, set string) (Usage, error) {
+	var u Usage
+	err := db.QueryRow(`SELECT documents, bytes FROM set_usage WHERE set_name = ?`, set).Scan(&u.Documents, &u.Bytes)
+	if err == sql.ErrNoRows {
+		return Usage{}, nil
+	}
+	return u, err
+}
+
+// IsQuotaExceeded reports whether err is a write rejected by a set quota.
+func IsQuotaExceeded(err error) bool {
+	return err != nil && strings.Contains(err.Error(), quotaMessage)
+}
+
+// ensureQuotaTriggers keeps set_usage in step with a set table and rejects writes beyond
+// the set's quota, whichever code path they come from. The first time, usage is counted
+// from the table in the same transaction that creates the triggers, so no write is missed.
+func ensureQuotaTriggers(db *sql.DB, set string) error {
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = ?`, "trg_"+set+"_usage_insert").Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(fmt.Sprintf(`
+	CREATE TRIGGER IF NOT EXISTS trg_%[1]s_quota_insert BEFORE INSERT ON %[2]s
+	WHEN EXISTS (SELECT 1 FROM quotas q LEFT JOIN set_usage u ON u.set_name = q.set_name WHERE q.set_name = '%[1]s' AND (
+		COALESCE(u.documents, 0) + 1 > q.max_documents OR COALESCE(u.bytes, 0) + octet_length(NEW.data) > q.max_bytes))
+	BEGIN
+		SELECT RAISE(ABORT, '%[3]s');
+	END;
+	CREATE TRIGGER IF NOT EXISTS trg_%[1]s_quota_update BEFORE UPDATE OF data ON %[2]s
+	WHEN octet_length(NEW.data) > octet_length(OLD.data) AND EXISTS (SELECT 1 FROM quotas q LEFT JOIN set_usage u ON u.set_name = q.set_name WHERE q.set_name = '%[1]s' AND
+		COALESCE(u.bytes, 0) - octet_length(OLD.data) + octet_length(NEW.data) > q.max_bytes)
+	BEGIN
+		SELECT RAISE(ABORT, '%[3]s');
+	END;
+	CREATE TRIGGER IF NOT EXISTS trg_%[1]s_usage_insert AFTER INSERT ON %[2]s BEGIN
+		INSERT INTO set_usage (set_name, documents, bytes) VALUES ('%[1]s', 1, octet_length(NEW.data))
+		ON CONFLICT(set_name) DO UPDATE SET documents = documents + 1, bytes = bytes + excluded.bytes;
+	END;
+	CREATE TRIGGER IF NOT EXISTS trg_%[1]s_usage_update AFTER UPDATE OF data ON %[2]s BEGIN
+		UPDATE set_usage SET bytes = bytes - octet_length(OLD.data) + octet_length(NEW.data) WHERE set_name = '%[1]s';
+	END;
+	CREATE TRIGGER IF NOT EXISTS trg_%[1]s_usage_delete AFTER DELETE ON %[2]s BEGIN
+		UPDATE set_usage SET documents = documents - 1, bytes = bytes - octet_length(OLD.data) WHERE set_name = '%[1]s';
+	END;
+	`, set, tableName(set), quotaMessage)); err != nil {
+		return err
+	}
+	// WHERE true disambiguates the upsert from a join constraint
+	if _, err := tx.Exec(`INSERT INTO set_usage (set_name, documents, bytes)
+		SELECT ?, COUNT(*), COALESCE(SUM(octet_length(data)), 0) FROM `+tableName(set)+` WHERE true
+		ON CONFLICT(set_name) DO UPDATE SET documents = excluded.documents, bytes = excluded.bytes`, set); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"database/sql/driver"
+	"regexp"
+	"sync"
+
+	"modernc.org/sqlite"
+
+	"microapi/internal/query"
+)
+
+// maxCachedRegexps bounds the compiled patterns kept between queries.
+const maxCachedRegexps = 256
+
+var regexps = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: map[string]*regexp.Regexp{}}
+
+// registerRegexp makes SQLite's X REGEXP Y operator, which calls regexp(Y, X), available
+// to every connection. It backs the $regex operator, whose patterns are checked by
+// query.CompileRegexp; values other than strings never match.
+var registerRegexp = sync.OnceValue(func() error {
+	return sqlite.RegisterDeterministicScalarFunction("regexp", 2, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		pattern, _ := args[0].(string)
+		s, ok := args[1].(string)
+		if !ok {
+			return false, nil
+		}
+		re, err := cachedRegexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(s), nil
+	})
+})
+
+// cachedRegexp compiles pattern once for all the rows a query tests, and for later queries
+// using it. The cache is emptied when full rather than tracking use.
+func cachedRegexp(pattern string) (*regexp.Regexp, error) {
+	regexps.Lock()
+	defer regexps.Unlock()
+	if re, ok := regexps.m[pattern]; ok {
+		return re, nil
+	}
+	re, err := query.CompileRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(regexps.m) >= maxCachedRegexps {
+		clear(regexps.m)
+	}
+	regexps.m[pattern] = re
+	return re, nil
+}
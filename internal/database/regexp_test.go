@@ -0,0 +1,76 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+)
+
+func TestCachedRegexp(t *testing.T) {
+	clear(regexps.m)
+	re, err := cachedRegexp("^a+$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := cachedRegexp("^a+$"); again != re {
+		t.Error("the same pattern was compiled twice")
+	}
+	if _, err := cachedRegexp("("); err == nil {
+		t.Error(`cachedRegexp("("): want an error`)
+	}
+	if _, err := cachedRegexp("(abcdefgh){700}"); err == nil {
+		t.Error("a pattern too complex for $regex was compiled")
+	}
+	if len(regexps.m) != 1 {
+		t.Errorf("cache holds %d patterns after rejected ones, want 1", len(regexps.m))
+	}
+	for i := 1; i < maxCachedRegexps; i++ {
+		if _, err := cachedRegexp(fmt.Sprintf("^%d$", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(regexps.m) != maxCachedRegexps {
+		t.Fatalf("cache holds %d patterns, want %d", len(regexps.m), maxCachedRegexps)
+	}
+	// a full cache is emptied before the next pattern goes in
+	if _, err := cachedRegexp("^b+$"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := regexps.m["^a+$"]; ok || len(regexps.m) != 1 {
+		t.Errorf("cache holds %d patterns after overflowing, want only the new one", len(regexps.m))
+	}
+}
+
+func TestRegexpFunction(t *testing.T) {
+	if err := registerRegexp(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`'Report' REGEXP '(?i)^report$'`, true},
+		{`'Report' REGEXP '^report$'`, false},
+		// only strings match
+		{`7 REGEXP '7'`, false},
+		{`NULL REGEXP ''`, false},
+	}
+	for _, tt := range tests {
+		var got bool
+		if err := db.QueryRow(`SELECT ` + tt.expr).Scan(&got); err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+	if err := db.QueryRow(`SELECT 'a' REGEXP '('`).Scan(new(bool)); err == nil {
+		t.Error("an invalid pattern: want an error")
+	}
+}
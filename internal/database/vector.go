@@ -0,0 +1,204 @@
+package database
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"modernc.org/sqlite"
+)
+
+// MaxVectorDimensions bounds the length of indexed vectors.
+const MaxVectorDimensions = 16000
+
+// ErrVectorIndexNotFound is returned when a collection has no vector index on a path.
+var ErrVectorIndexNotFound = errors.New("no vector index for this collection")
+
+// Vectors are stored as little-endian float32 blobs. The functions below are registered
+// with the driver so triggers can encode documents and queries can rank them without cgo:
+//
+//	vec_from_json(json, dims)     encoded vector, or NULL unless json is an array of dims finite numbers
+//	vec_distance_cosine(a, b)     1 - cosine similarity
+//	vec_distance_dot(a, b)        negative inner product
+//	vec_distance_l2(a, b)         Euclidean distance
+//
+// The distances are NULL when the vectors differ in length (or, for cosine, one is zero).
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("vec_from_json", 2, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		s, ok := args[0].(string)
+		dims, _ := args[1].(int64)
+		if !ok {
+			return nil, nil
+		}
+		var v []float64
+		if err := json.Unmarshal([]byte(s), &v); err != nil || int64(len(v)) != dims {
+			return nil, nil
+		}
+		b, err := EncodeVector(v)
+		if err != nil {
+			return nil, nil
+		}
+		return b, nil
+	})
+	distance := func(f func(a, b []float32) (float64, bool)) func(*sqlite.FunctionContext, []driver.Value) (driver.Value, error) {
+		return func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			a, _ := args[0].([]byte)
+			b, _ := args[1].([]byte)
+			if len(a) == 0 || len(a) != len(b) || len(a)%4 != 0 {
+				return nil, nil
+			}
+			d, ok := f(decodeVector(a), decodeVector(b))
+			if !ok {
+				return nil, nil
+			}
+			return d, nil
+		}
+	}
+	sqlite.MustRegisterDeterministicScalarFunction("vec_distance_cosine", 2, distance(func(a, b []float32) (float64, bool) {
+		var dot, na, nb float64
+		for i := range a {
+			dot += float64(a[i]) * float64(b[i])
+			na += float64(a[i]) * float64(a[i])
+			nb += float64(b[i]) * float64(b[i])
+		}
+		if na == 0 || nb == 0 {
+			return 0, false
+		}
+		return 1 - dot/math.Sqrt(na*nb), true
+	}))
+	sqlite.MustRegisterDeterministicScalarFunction("vec_distance_dot", 2, distance(func(a, b []float32) (float64, bool) {
+		var dot float64
+		for i := range a {
+			dot += float64(a[i]) * float64(b[i])
+		}
+		return -dot, true
+	}))
+	sqlite.MustRegisterDeterministicScalarFunction("vec_distance_l2", 2, distance(func(a, b []float32) (float64, bool) {
+		var sum float64
+		for i := range a {
+			d := float64(a[i]) - float64(b[i])
+			sum += d * d
+		}
+		return math.Sqrt(sum), true
+	}))
+}
+
+// EncodeVector encodes v the way vector indexes store it. Values must be finite and fit
+// a float32.
+func EncodeVector(v []float64) ([]byte, error) {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		f := float32(x)
+		if math.IsNaN(x) || math.IsInf(float64(f), 0) {
+			return nil, fmt.Errorf("vector[%d] is not a finite float32", i)
+		}
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+	return b, nil
+}
+
+func decodeVector(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
+
+// VectorIndex is a vector index of a collection, as recorded in idx_metadata.
+type VectorIndex struct {
+	// Name is also the table holding the encoded vectors
+	Name       string
+	Path       string
+	Dimensions int
+	Status     string
+}
+
+// VectorIndexName returns the name of the vector index on path. The collection and path
+// are hashed so any of them gives a distinct, valid table name.
+func VectorIndexName(set, collection, path string) string {
+	sum := sha1.Sum([]byte(collection + "|" + path))
+	return fmt.Sprintf("vec_%s_%s", set, hex.EncodeToString(sum[:])[:10])
+}
+
+// CreateVectorIndexMetadata records a vector index with status creating. Declaring it
+// again, e.g. with other dimensions, resets it for a rebuild.
+func CreateVectorIndexMetadata(db *sql.DB, set, collection, idxName, path string, dims int) error {
+	return upsertIndexMetadata(db, set, collection, idxName, path, "vector", dims)
+}
+
+// CreateVectorIndex (re)builds the table of a vector index from the documents of the
+// collection. Triggers on the set table keep it in step with every write; documents whose
+// path does not hold an array of dims numbers are left out.
+func CreateVectorIndex(db *sql.DB, set, collection, idxName, path string, dims int) error {
+	vec := fmt.Sprintf("vec_from_json(json_extract(NEW.data, '%s'), %d)", strings.ReplaceAll(path, "'", "''"), dims)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := dropTableIndex(tx, idxName); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`
+	CREATE TABLE %[1]s (id TEXT PRIMARY KEY, vec BLOB NOT NULL) WITHOUT ROWID;
+	CREATE TRIGGER trg_%[1]s_insert AFTER INSERT ON %[2]s WHEN NEW.collection = '%[3]s' BEGIN
+		INSERT INTO %[1]s (id, vec) SELECT NEW.id, v FROM (SELECT %[4]s AS v) WHERE v IS NOT NULL;
+	END;
+	CREATE TRIGGER trg_%[1]s_update AFTER UPDATE OF data ON %[2]s WHEN NEW.collection = '%[3]s' BEGIN
+		DELETE FROM %[1]s WHERE id = OLD.id;
+		INSERT INTO %[1]s (id, vec) SELECT NEW.id, v FROM (SELECT %[4]s AS v) WHERE v IS NOT NULL;
+	END;
+	CREATE TRIGGER trg_%[1]s_delete AFTER DELETE ON %[2]s WHEN OLD.collection = '%[3]s' BEGIN
+		DELETE FROM %[1]s WHERE id = OLD.id;
+	END;
+	`, idxName, tableName(set), collection, vec)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (id, vec) SELECT id, v FROM (SELECT id, %s AS v FROM %s WHERE collection = ?) WHERE v IS NOT NULL`,
+		idxName, strings.ReplaceAll(vec, "NEW.data", "data"), tableName(set)), collection); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetVectorIndex returns the vector index of a collection on path, or on its only vector
+// index when path is empty.
+func GetVectorIndex(db *sql.DB, set, collection, path string) (*VectorIndex, error) {
+	q := `SELECT idx_name, paths, dimensions, status FROM idx_metadata WHERE set_name = ? AND collection_name = ? AND kind = 'vector'`
+	args := []any{set, collection}
+	if path != "" {
+		q += ` AND paths = ?`
+		args = append(args, path)
+	}
+	rows, err := db.Query(q+` LIMIT 2`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var found []VectorIndex
+	for rows.Next() {
+		var v VectorIndex
+		if err := rows.Scan(&v.Name, &v.Path, &v.Dimensions, &v.Status); err != nil {
+			return nil, err
+		}
+		found = append(found, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	switch len(found) {
+	case 0:
+		return nil, ErrVectorIndexNotFound
+	case 1:
+		return &found[0], nil
+	}
+	return nil, fmt.Errorf("the collection has several vector indexes: set path")
+}
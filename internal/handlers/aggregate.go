@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"microapi/internal/auth"
+	"microapi/internal/database"
+	"microapi/internal/middleware"
+	"microapi/internal/query"
+)
+
+// Aggregate computes counts, sums, averages, minimums and maximums over a collection,
+// optionally filtered by where and grouped by JSON paths.
+// GET /{set}/{collection}/_aggregate?where=...&group_by=status&aggs=count,sum:price
+func (h *Handlers) Aggregate(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	q := r.URL.Query()
+	restrict, err := whereWithRules(r, auth.RowFilter(r.Context()))
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	out, err := h.aggregate(set, collection, q.Get("where"), q.Get("group_by"), q.Get("aggs"), restrict)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, true, out, nil)
+}
+
+// aggregate runs an aggregation and returns one row per group (see query.AggregateOpts.Scan).
+func (h *Handlers) aggregate(set, collection, whereStr, groupBy, aggs string, restrict *query.ParsedWhere) ([]map[string]any, error) {
+	if err := middleware.ValidateNames(set, collection); err != nil {
+		return nil, err
+	}
+	if err := database.EnsureSetTable(h.db, set); err != nil {
+		return nil, err
+	}
+	pw, err := query.ParseWhereWith(whereStr, database.Indexes(h.db, set, collection))
+	if err != nil {
+		return nil, &middleware.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	pw = pw.And(restrict)
+	groups, err := query.ParseGroupBy(groupBy)
+	if err != nil {
+		return nil, &middleware.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	aggregates, err := query.ParseAggregates(aggs)
+	if err != nil {
+		return nil, &middleware.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	opts := query.AggregateOpts{Set: set, Collection: collection, Where: pw, GroupBy: groups, Aggregates: aggregates}
+	sqlStr, args := query.BuildAggregate(opts)
+	rows, err := h.db.Query(sqlStr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out, err := opts.Scan(rows)
+	if err != nil {
+		return nil, err
+	}
+	// Track potential index usage based on referenced paths
+	database.UpdateIndexUsage(h.db, set, collection, append(pw.Paths, groups...))
+	return out, nil
+}
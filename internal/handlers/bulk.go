@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/xid"
+
+	"microapi/internal/database"
+	"microapi/internal/middleware"
+	"microapi/internal/models"
+	"microapi/internal/rules"
+	"microapi/internal/validation"
+)
+
+// bulkItem reports the outcome of one document of a bulk insert.
+type bulkItem struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// BulkCreateDocuments inserts many documents in a single transaction.
+// The body is a JSON array of objects or, with Content-Type application/x-ndjson,
+// one object per line. ?mode=atomic (default) rolls everything back if any document
+// is rejected; ?mode=best-effort inserts the valid ones and reports the rest.
+func (h *Handlers) BulkCreateDocuments(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	atomic := true
+	switch r.URL.Query().Get("mode") {
+	case "", "atomic":
+	case "best-effort":
+		atomic = false
+	default:
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("mode must be atomic or best-effort"))
+		return
+	}
+	if err := database.EnsureSetTable(h.db, set); err != nil { writeErr(w, err); return }
+	if err := database.EnsureCollectionMetadata(h.db, set, collection); err != nil { writeErr(w, err); return }
+	validator, err := validation.NewValidator(h.db, set, collection)
+	if err != nil { writeErr(w, err); return }
+
+	docs, err := decodeBulkBody(r)
+	if err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error())); return }
+	if len(docs) == 0 { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("no documents provided")); return }
+
+	tx, err := h.db.Begin()
+	if err != nil { writeErr(w, err); return }
+	defer tx.Rollback()
+	stmt, err := tx.Prepare("INSERT INTO " + tableName(set) + " (id, collection, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?)")
+	if err != nil { writeErr(w, err); return }
+	defer stmt.Close()
+
+	now := time.Now().Unix()
+	items := make([]bulkItem, 0, len(docs))
+	failed := 0
+	for i, raw := range docs {
+		item := bulkItem{Index: i}
+		if err := insertBulkDoc(tx, stmt, r, validator, collection, raw, now, &item); err != nil {
+			item.Error = err.Error()
+			failed++
+		}
+		items = append(items, item)
+	}
+
+	if atomic && failed > 0 {
+		// nothing was stored: drop the ids handed out to valid documents
+		for i := range items { items[i].ID = "" }
+		middleware.WriteJSON(w, http.StatusBadRequest, false, map[string]any{"inserted": 0, "failed": failed, "items": items},
+			models.Ptr(fmt.Sprintf("bulk insert aborted: %d of %d documents rejected", failed, len(docs))))
+		return
+	}
+	if err := tx.Commit(); err != nil { writeErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusCreated, true, map[string]any{"inserted": len(docs) - failed, "failed": failed, "items": items}, nil)
+}
+
+// insertBulkDoc sanitizes, validates, checks claim rules and inserts a single bulk document, filling item.ID on success.
+func insertBulkDoc(tx *sql.Tx, stmt *sql.Stmt, r *http.Request, validator *validation.Validator, collection string, raw json.RawMessage, now int64, item *bulkItem) error {
+	var body map[string]any
+	if err := json.Unmarshal(raw, &body); err != nil || body == nil {
+		return errors.New("document must be a JSON object")
+	}
+	sanitized, verr := sanitizeForCreate(body)
+	if verr != nil { return verr }
+	if err := validator.Validate(sanitized); err != nil { return err }
+	if err := checkClaims(tx, r, sanitized); err != nil { return err }
+	if err := checkRule(tx, r, rules.Create, nil, sanitized); err != nil { return err }
+	id := xid.New().String()
+	if _, err := stmt.Exec(id, collection, mustJSON(sanitized), now, now); err != nil {
+		if database.IsQuotaExceeded(err) { return errors.New("set quota exceeded") }
+		return err
+	}
+	item.ID = id
+	return nil
+}
+
+// decodeBulkBody reads a JSON array or an NDJSON stream into raw documents.
+func decodeBulkBody(r *http.Request) ([]json.RawMessage, error) {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	dec := json.NewDecoder(r.Body)
+	var docs []json.RawMessage
+	if ct == "application/x-ndjson" || ct == "application/ndjson" {
+		for {
+			var raw json.RawMessage
+			err := dec.Decode(&raw)
+			if err == io.EOF { return docs, nil }
+			if err != nil { return nil, fmt.Errorf("invalid NDJSON body at document %d", len(docs)) }
+			docs = append(docs, raw)
+		}
+	}
+	if err := dec.Decode(&docs); err != nil {
+		return nil, errors.New("invalid JSON body: expected an array of documents")
+	}
+	return docs, nil
+}
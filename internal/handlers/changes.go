@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"microapi/internal/database"
+	"microapi/internal/middleware"
+	"microapi/internal/models"
+	"microapi/internal/query"
+)
+
+const (
+	// changesPollInterval is how often an open change stream checks the changelog.
+	changesPollInterval = 500 * time.Millisecond
+	// changesHeartbeat keeps idle streams alive through proxies.
+	changesHeartbeat = 15 * time.Second
+)
+
+// changeEvent is the payload of one SSE event of the change feed.
+type changeEvent struct {
+	Seq     int64          `json:"seq"`
+	Op      string         `json:"op"`
+	ID      string         `json:"id"`
+	Version int64          `json:"version"`
+	At      int64          `json:"at"`
+	Data    map[string]any `json:"data"`
+}
+
+// Changes streams create/update/delete events of a collection as Server-Sent Events.
+// Each event id is the changelog sequence number; a client resumes after it with the
+// Last-Event-ID header (or ?since=<seq>). Without either only new changes are sent.
+// ?where=... filters events on the document (its previous state for deletes).
+func (h *Handlers) Changes(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil {
+		writeErr(w, err)
+		return
+	}
+	pw, err := query.ParseWhere(r.URL.Query().Get("where"))
+	if err != nil {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
+		return
+	}
+	// claim and read rules apply to the changelog's copy of the document just like to the document
+	pw = whereWithClaims(r, pw)
+	if pw, err = whereWithRules(r, pw); err != nil {
+		writeErr(w, err)
+		return
+	}
+	// make sure the set exists with its changelog triggers before anyone writes to it
+	if err := database.EnsureSetTable(h.db, set); err != nil {
+		writeErr(w, err)
+		return
+	}
+	last, err := changesStart(r)
+	if err != nil {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
+		return
+	}
+	if last < 0 {
+		if err := h.db.QueryRow(`SELECT COALESCE(MAX(seq), 0) FROM changelog`).Scan(&last); err != nil {
+			writeErr(w, err)
+			return
+		}
+	}
+
+	sqlStr := `SELECT seq, doc_id, op, data, version, at FROM changelog WHERE set_name = ? AND collection_name = ? AND seq > ? AND seq <= ?`
+	for _, c := range pw.Conds {
+		sqlStr += " AND " + c.SQL
+	}
+	sqlStr += " ORDER BY seq"
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	poll := time.NewTicker(changesPollInterval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(changesHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		// bound each scan by the current head so rows skipped by the filter are not rescanned
+		var head int64
+		if err := h.db.QueryRowContext(r.Context(), `SELECT COALESCE(MAX(seq), 0) FROM changelog`).Scan(&head); err != nil {
+			return
+		}
+		if head > last {
+			args := []any{set, collection, last, head}
+			for _, c := range pw.Conds {
+				args = append(args, c.Args...)
+			}
+			if err := h.writeChanges(w, r, sqlStr, args); err != nil {
+				return
+			}
+			last = head
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-poll.C:
+		}
+	}
+}
+
+// writeChanges writes one SSE event per changelog row returned by sqlStr.
+func (h *Handlers) writeChanges(w http.ResponseWriter, r *http.Request, sqlStr string, args []any) error {
+	rows, err := h.db.QueryContext(r.Context(), sqlStr, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ev changeEvent
+		var dataStr string
+		if err := rows.Scan(&ev.Seq, &ev.ID, &ev.Op, &dataStr, &ev.Version, &ev.At); err != nil {
+			return err
+		}
+		_ = json.Unmarshal([]byte(dataStr), &ev.Data)
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Op, mustJSON(ev)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// changesStart returns the sequence number to resume after, or -1 to start at the head.
+func changesStart(r *http.Request) (int64, error) {
+	v := strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+	if v == "" {
+		v = strings.TrimSpace(r.URL.Query().Get("since"))
+	}
+	if v == "" {
+		return -1, nil
+	}
+	seq, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, fmt.Errorf("invalid Last-Event-ID/since: expected a sequence number")
+	}
+	return seq, nil
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"microapi/internal/auth"
+	"microapi/internal/middleware"
+	"microapi/internal/models"
+	"microapi/internal/query"
+)
+
+// PutClaimRules stores the rules that restrict JWT callers in a collection (see auth.ClaimRules).
+func (h *Handlers) PutClaimRules(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	var rules auth.ClaimRules
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("invalid JSON body: expected {\"read\": {...}, \"write\": {...}}")); return }
+	if err := auth.PutClaimRules(h.db, set, collection, rules); err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error())); return }
+	middleware.WriteJSON(w, http.StatusOK, true, rules, nil)
+}
+
+func (h *Handlers) GetClaimRules(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	rules, err := auth.GetClaimRules(h.db, set, collection)
+	if err != nil { writeClaimRulesErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, rules, nil)
+}
+
+func (h *Handlers) DeleteClaimRules(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	if err := auth.DeleteClaimRules(h.db, set, collection); err != nil { writeClaimRulesErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"deleted": true}, nil)
+}
+
+func writeClaimRulesErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, auth.ErrNotFound) { middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("no claim rules for this collection")); return }
+	writeErr(w, err)
+}
+
+// rowFilter returns " AND ..." and its args, limiting a statement on a set table to the
+// documents the caller's claim rules allow. Both are empty for unrestricted callers.
+func rowFilter(r *http.Request) (string, []any) {
+	pw := auth.RowFilter(r.Context())
+	if pw == nil { return "", nil }
+	var s string
+	var args []any
+	for _, c := range pw.Conds { s += " AND " + c.SQL; args = append(args, c.Args...) }
+	return s, args
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// checkClaims rejects a document the caller is about to write when their claim rules
+// would not allow it, so writes cannot move documents out of the caller's reach.
+func checkClaims(q queryRower, r *http.Request, doc map[string]any) error {
+	s, args := rowFilter(r)
+	if s == "" { return nil }
+	var ok bool
+	// the rule conditions read a "data" column, so feed them the document as one
+	if err := q.QueryRow("SELECT EXISTS(SELECT 1 FROM (SELECT ? AS data) WHERE 1=1"+s+")", append([]any{mustJSON(doc)}, args...)...).Scan(&ok); err != nil { return err }
+	if !ok { return &middleware.HTTPError{Code: http.StatusForbidden, Message: "document does not satisfy the collection's claim rules"} }
+	return nil
+}
+
+// whereWithClaims narrows a parsed client filter by the caller's claim rules.
+func whereWithClaims(r *http.Request, pw *query.ParsedWhere) *query.ParsedWhere {
+	return pw.And(auth.RowFilter(r.Context()))
+}
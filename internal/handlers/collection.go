@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -30,17 +31,45 @@ func (h *Handlers) QueryCollection(w http.ResponseWriter, r *http.Request) {
 	whereStr := r.URL.Query().Get("where")
 	reqID := chimw.GetReqID(r.Context())
 
-	pw, err := query.ParseWhere(whereStr)
+	pw, err := query.ParseWhereWith(whereStr, database.Indexes(h.db, set, collection))
 	if err != nil {
 		// surface parse error with raw input
 		slog.Error("parse_where_error", slog.String("req_id", reqID), slog.String("where_raw", whereStr), slog.String("error", err.Error()))
 		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
 		return
 	}
+	// q is a full-text search across every indexed field
+	if q := r.URL.Query().Get("q"); q != "" {
+		sw, err := query.Search(q, database.Indexes(h.db, set, collection))
+		if err != nil {
+			middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
+			return
+		}
+		pw = pw.And(sw)
+	}
+	pw = whereWithClaims(r, pw)
+	if pw, err = whereWithRules(r, pw); err != nil {
+		writeErr(w, err)
+		return
+	}
 
-	orderBy := r.URL.Query().Get("order_by")
+	orderBy, err := query.ParseOrderBy(r.URL.Query().Get("order_by"))
+	if err != nil {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
+		return
+	}
+	fields, err := query.ParseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
+		return
+	}
 	limit := parseInt(r.URL.Query().Get("limit"), 0)
 	offset := parseInt(r.URL.Query().Get("offset"), -1)
+	after, err := parseCursor(r.URL.Query().Get("cursor"), orderBy, offset)
+	if err != nil {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
+		return
+	}
 
 	// total count for pagination (ignores limit/offset)
 	countSQL, countArgs := query.BuildCount(query.BuildOpts{Set: set, Collection: collection, Where: pw})
@@ -49,7 +78,12 @@ func (h *Handlers) QueryCollection(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("X-Total-Items", fmt.Sprintf("%d", total))
 	}
 
-	sqlStr, args := query.BuildSelect(query.BuildOpts{Set: set, Collection: collection, Where: pw, OrderBy: orderBy, Limit: limit, Offset: offset})
+	opts := query.BuildOpts{Set: set, Collection: collection, Where: pw, OrderBy: orderBy, Fields: fields, Limit: limit, Offset: offset, After: after, Snippets: r.URL.Query().Get("snippet") == "1"}
+	if after != nil && opts.Ranked() {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("cursor cannot be used with results ranked by relevance or distance: page with offset or set order_by"))
+		return
+	}
+	sqlStr, args := query.BuildSelect(opts)
 
 	// Optional EXPLAIN QUERY PLAN in debug mode
 	if r.URL.Query().Get("debug") == "1" {
@@ -71,22 +105,36 @@ func (h *Handlers) QueryCollection(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 	var results []map[string]any
+	keys := opts.KeyColumns()
 	for rows.Next() {
 		var id string
 		var dataStr string
-		var created, updated int64
-		if err := rows.Scan(&id, &dataStr, &created, &updated); err == nil {
-			var m map[string]any
-			_ = json.Unmarshal([]byte(dataStr), &m)
-			if !suppressMeta(r) {
-				m["_meta"] = map[string]any{
-					"id":         id,
-					"created_at": created,
-					"updated_at": updated,
-				}
+		var created, updated, version int64
+		var distance sql.NullFloat64
+		if err := rows.Scan(append([]any{&id, &dataStr, &created, &updated, &version, &distance}, keys...)...); err != nil {
+			writeErr(w, err)
+			return
+		}
+		var m map[string]any
+		_ = json.Unmarshal([]byte(dataStr), &m)
+		if !suppressMeta(r) {
+			meta := map[string]any{
+				"id":         id,
+				"created_at": created,
+				"updated_at": updated,
+				"version":    version,
+			}
+			// meters to the point of a $near
+			if distance.Valid {
+				meta["distance"] = distance.Float64
 			}
-			results = append(results, m)
+			m["_meta"] = meta
 		}
+		results = append(results, m)
+	}
+	if err := rows.Err(); err != nil {
+		writeErr(w, err)
+		return
 	}
 	// Track potential index usage based on referenced paths
 	if pw != nil && len(pw.Paths) > 0 {
@@ -95,9 +143,39 @@ func (h *Handlers) QueryCollection(w http.ResponseWriter, r *http.Request) {
 	if results == nil {
 		results = []map[string]any{}
 	}
+	next, err := nextCursor(opts, len(results), keys)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
 	middleware.WriteJSON(w, http.StatusOK, true, results, nil)
 }
 
+// parseCursor decodes the optional cursor query parameter; cursors replace offsets.
+func parseCursor(token string, orderBy []query.OrderField, offset int) (*query.Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	if offset > 0 {
+		return nil, fmt.Errorf("cursor cannot be combined with offset")
+	}
+	return query.DecodeCursor(token, orderBy)
+}
+
+// nextCursor returns the token for the page following a full page of results, or "" when
+// the listing is exhausted, unpaginated or ranked by relevance or distance. keys hold the
+// sort values of the last row (see query.BuildOpts.KeyColumns).
+func nextCursor(opts query.BuildOpts, n int, keys []any) (string, error) {
+	c, err := opts.NextCursor(n, keys)
+	if err != nil || c == nil {
+		return "", err
+	}
+	return query.EncodeCursor(*c), nil
+}
+
 func parseInt(s string, def int) int {
 	if s == "" {
 		return def
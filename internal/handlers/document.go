@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"strings"
 	"net/http"
+	"regexp"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -14,6 +15,7 @@ import (
 	"microapi/internal/middleware"
 	"microapi/internal/models"
 	"microapi/internal/query"
+	"microapi/internal/rules"
 	"microapi/internal/validation"
 )
 
@@ -34,6 +36,8 @@ func (h *Handlers) CreateDocument(w http.ResponseWriter, r *http.Request) {
 		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
 		return
 	}
+	if err := checkClaims(h.db, r, sanitized); err != nil { writeErr(w, err); return }
+	if err := checkRule(h.db, r, rules.Create, nil, sanitized); err != nil { writeErr(w, err); return }
 
 	id := xid.New().String()
 	now := time.Now().Unix()
@@ -41,7 +45,7 @@ func (h *Handlers) CreateDocument(w http.ResponseWriter, r *http.Request) {
 	_, err := h.db.Exec("INSERT INTO "+tableName(set)+" (id, collection, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?)", id, collection, string(dataBytes), now, now)
 	if err != nil { writeErr(w, err); return }
 
-	writeDocResponse(w, r, http.StatusCreated, sanitized, id, now, now)
+	writeDocResponse(w, r, http.StatusCreated, sanitized, id, now, now, 1)
 }
 
 func (h *Handlers) GetDocument(w http.ResponseWriter, r *http.Request) {
@@ -50,13 +54,24 @@ func (h *Handlers) GetDocument(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
 	if err := database.EnsureSetTable(h.db, set); err != nil { writeErr(w, err); return }
-	var dataStr string; var created, updated int64
-	err := h.db.QueryRow("SELECT data, created_at, updated_at FROM "+tableName(set)+" WHERE id = ? AND collection = ?", id, collection).Scan(&dataStr, &created, &updated)
+	fields, err := query.ParseFields(r.URL.Query().Get("fields"))
+	if err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error())); return }
+	var dataStr string; var created, updated, version int64
+	fs, fargs := rowFilter(r)
+	rs, rargs, err := ruleFilter(r, rules.Read)
+	if err != nil { writeErr(w, err); return }
+	fs, fargs = fs+rs, append(fargs, rargs...)
+	err = h.db.QueryRow("SELECT "+query.DataExpr(fields)+", created_at, updated_at, version FROM "+tableName(set)+" WHERE id = ? AND collection = ?"+fs, append([]any{id, collection}, fargs...)...).Scan(&dataStr, &created, &updated, &version)
 	if err == sql.ErrNoRows { middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("not found")); return }
 	if err != nil { writeErr(w, err); return }
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, version) {
+		w.Header().Set("ETag", etag(version))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	var m map[string]any
 	_ = json.Unmarshal([]byte(dataStr), &m)
-	writeDocResponse(w, r, http.StatusOK, m, id, created, updated)
+	writeDocResponse(w, r, http.StatusOK, m, id, created, updated, version)
 }
 
 func (h *Handlers) ReplaceDocument(w http.ResponseWriter, r *http.Request) {
@@ -73,13 +88,33 @@ func (h *Handlers) ReplaceDocument(w http.ResponseWriter, r *http.Request) {
 		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
 		return
 	}
+	if err := checkClaims(h.db, r, sanitized); err != nil { writeErr(w, err); return }
+	if err := database.EnsureSetTable(h.db, set); err != nil { writeErr(w, err); return }
+	old, current, exists, err := docState(h.db, r, set, collection, id)
+	if err != nil { writeErr(w, err); return }
+	if perr := checkPreconditions(r, exists, current); perr != nil { writeErr(w, perr); return }
 	now := time.Now().Unix()
-	_, err := h.db.Exec("UPDATE "+tableName(set)+" SET data = ?, updated_at = ? WHERE id = ? AND collection = ?", mustJSON(sanitized), now, id, collection)
+	if !exists {
+		if r.URL.Query().Get("upsert") != "1" { middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("not found")); return }
+		if !docIDRe.MatchString(id) { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("invalid document id")); return }
+		if err := checkRule(h.db, r, rules.Create, nil, sanitized); err != nil { writeErr(w, err); return }
+		if err := database.EnsureCollectionMetadata(h.db, set, collection); err != nil { writeErr(w, err); return }
+		// ids are unique per set, so a clash means another writer (or collection) got there first
+		res, err := h.db.Exec("INSERT INTO "+tableName(set)+" (id, collection, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING", id, collection, mustJSON(sanitized), now, now)
+		if err != nil { writeErr(w, err); return }
+		if n, _ := res.RowsAffected(); n == 0 { middleware.WriteJSON(w, http.StatusConflict, false, nil, models.Ptr("document id already in use")); return }
+		writeDocResponse(w, r, http.StatusCreated, sanitized, id, now, now, 1)
+		return
+	}
+	// the UPDATE below only applies to the version the rule saw
+	if err := checkRule(h.db, r, rules.Update, old, sanitized); err != nil { writeErr(w, err); return }
+	res, err := h.db.Exec("UPDATE "+tableName(set)+" SET data = ?, updated_at = ?, version = version + 1 WHERE id = ? AND collection = ? AND version = ?", mustJSON(sanitized), now, id, collection, current)
 	if err != nil { writeErr(w, err); return }
-	var created, updated int64
-	err = h.db.QueryRow("SELECT created_at, updated_at FROM "+tableName(set)+" WHERE id = ? AND collection = ?", id, collection).Scan(&created, &updated)
+	if n, _ := res.RowsAffected(); n == 0 { writeErr(w, conflictErr(r)); return }
+	var created, updated, version int64
+	err = h.db.QueryRow("SELECT created_at, updated_at, version FROM "+tableName(set)+" WHERE id = ? AND collection = ?", id, collection).Scan(&created, &updated, &version)
 	if err != nil { writeErr(w, err); return }
-	writeDocResponse(w, r, http.StatusOK, sanitized, id, created, updated)
+	writeDocResponse(w, r, http.StatusOK, sanitized, id, created, updated, version)
 }
 
 func (h *Handlers) UpdateDocument(w http.ResponseWriter, r *http.Request) {
@@ -87,30 +122,101 @@ func (h *Handlers) UpdateDocument(w http.ResponseWriter, r *http.Request) {
 	collection := chi.URLParam(r, "collection")
 	id := chi.URLParam(r, "id")
 	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
-	var patch map[string]any
-	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("invalid JSON body")); return }
-	sanitized, verr := sanitizeForPutPatch(patch, id)
-	if verr != nil { middleware.WriteJSON(w, verr.Code, false, nil, models.Ptr(verr.Message)); return }
+	apply, upd, verr := decodePatch(r, id)
+	if verr != nil { writeErr(w, verr); return }
+	if upd != nil { h.applyUpdate(w, r, set, collection, id, upd); return }
 	// Load existing
 	var dataStr string
-	err := h.db.QueryRow("SELECT data FROM "+tableName(set)+" WHERE id = ? AND collection = ?", id, collection).Scan(&dataStr)
-	if err == sql.ErrNoRows { middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("not found")); return }
+	var current int64
+	fs, fargs := rowFilter(r)
+	err := h.db.QueryRow("SELECT data, version FROM "+tableName(set)+" WHERE id = ? AND collection = ?"+fs, append([]any{id, collection}, fargs...)...).Scan(&dataStr, &current)
+	if err == sql.ErrNoRows {
+		if perr := checkPreconditions(r, false, 0); perr != nil { writeErr(w, perr); return }
+		middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("not found"))
+		return
+	}
 	if err != nil { writeErr(w, err); return }
-	var m map[string]any
+	if perr := checkPreconditions(r, true, current); perr != nil { writeErr(w, perr); return }
+	var old, m map[string]any
+	_ = json.Unmarshal([]byte(dataStr), &old)
 	_ = json.Unmarshal([]byte(dataStr), &m)
-	for k, v := range sanitized { m[k] = v }
+	if m == nil { m = map[string]any{} }
+	m, verr = apply(m)
+	if verr != nil { writeErr(w, verr); return }
 	// Schema validation
 	if err := validation.ValidateDocument(h.db, set, collection, m); err != nil {
 		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
 		return
 	}
+	if err := checkClaims(h.db, r, m); err != nil { writeErr(w, err); return }
+	if err := checkRule(h.db, r, rules.Update, old, m); err != nil { writeErr(w, err); return }
 	now := time.Now().Unix()
-	_, err = h.db.Exec("UPDATE "+tableName(set)+" SET data = ?, updated_at = ? WHERE id = ? AND collection = ?", mustJSON(m), now, id, collection)
+	// only apply the merge if nobody wrote in between, so concurrent patches are not lost
+	res, err := h.db.Exec("UPDATE "+tableName(set)+" SET data = ?, updated_at = ?, version = version + 1 WHERE id = ? AND collection = ? AND version = ?", mustJSON(m), now, id, collection, current)
 	if err != nil { writeErr(w, err); return }
-	var created, updated int64
-	err = h.db.QueryRow("SELECT created_at, updated_at FROM "+tableName(set)+" WHERE id = ? AND collection = ?", id, collection).Scan(&created, &updated)
+	if n, _ := res.RowsAffected(); n == 0 { writeErr(w, conflictErr(r)); return }
+	var created, updated, version int64
+	err = h.db.QueryRow("SELECT created_at, updated_at, version FROM "+tableName(set)+" WHERE id = ? AND collection = ?", id, collection).Scan(&created, &updated, &version)
 	if err != nil { writeErr(w, err); return }
-	writeDocResponse(w, r, http.StatusOK, m, id, created, updated)
+	writeDocResponse(w, r, http.StatusOK, m, id, created, updated, version)
+}
+
+// applyUpdate runs compiled update operators as a single UPDATE so concurrent increments and
+// array edits never lose writes. The result is validated against the schema before commit.
+func (h *Handlers) applyUpdate(w http.ResponseWriter, r *http.Request, set, collection, id string, upd *query.Update) {
+	if err := database.EnsureSetTable(h.db, set); err != nil { writeErr(w, err); return }
+	validator, err := validation.NewValidator(h.db, set, collection)
+	if err != nil { writeErr(w, err); return }
+	tx, err := h.db.Begin()
+	if err != nil { writeErr(w, err); return }
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	fs, fargs := rowFilter(r)
+	args := append([]any{}, upd.Args...)
+	args = append(args, now, id, collection)
+	args = append(args, fargs...)
+	var dataStr string
+	var created, updated, version int64
+	// update rules compare against the stored document; the transaction keeps it from changing
+	var old map[string]any
+	if rules.FromContext(r.Context()).Active() {
+		err = tx.QueryRow("SELECT data FROM "+tableName(set)+" WHERE id = ? AND collection = ?", id, collection).Scan(&dataStr)
+		if err != nil && err != sql.ErrNoRows { writeErr(w, err); return }
+		_ = json.Unmarshal([]byte(dataStr), &old)
+	}
+	err = tx.QueryRow("UPDATE "+tableName(set)+" SET data = "+upd.Expr+", updated_at = ?, version = version + 1 WHERE id = ? AND collection = ? AND "+upd.GuardSQL()+fs+" RETURNING data, created_at, updated_at, version", args...).Scan(&dataStr, &created, &updated, &version)
+	if err == sql.ErrNoRows {
+		// either the document is missing (or hidden by claim rules) or a field has the wrong type for its operator
+		var current int64
+		err = tx.QueryRow("SELECT version FROM "+tableName(set)+" WHERE id = ? AND collection = ?"+fs, append([]any{id, collection}, fargs...)...).Scan(&current)
+		if err == sql.ErrNoRows {
+			if perr := checkPreconditions(r, false, 0); perr != nil { writeErr(w, perr); return }
+			middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("not found"))
+			return
+		}
+		if err != nil { writeErr(w, err); return }
+		if perr := checkPreconditions(r, true, current); perr != nil { writeErr(w, perr); return }
+		for _, g := range upd.Guards {
+			var ok bool
+			if err := tx.QueryRow("SELECT "+g.SQL+" FROM "+tableName(set)+" WHERE id = ? AND collection = ?", id, collection).Scan(&ok); err != nil { writeErr(w, err); return }
+			if !ok { middleware.WriteJSON(w, http.StatusUnprocessableEntity, false, nil, models.Ptr(g.Message)); return }
+		}
+		writeErr(w, conflictErr(r))
+		return
+	}
+	if err != nil { writeErr(w, err); return }
+	// the row is locked by our write, so version-1 is exactly what we updated
+	if perr := checkPreconditions(r, true, version-1); perr != nil { writeErr(w, perr); return }
+	var m map[string]any
+	_ = json.Unmarshal([]byte(dataStr), &m)
+	if err := validator.Validate(m); err != nil {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
+		return
+	}
+	if err := checkClaims(tx, r, m); err != nil { writeErr(w, err); return }
+	if err := checkRule(tx, r, rules.Update, old, m); err != nil { writeErr(w, err); return }
+	if err := tx.Commit(); err != nil { writeErr(w, err); return }
+	writeDocResponse(w, r, http.StatusOK, m, id, created, updated, version)
 }
 
 func (h *Handlers) DeleteDocument(w http.ResponseWriter, r *http.Request) {
@@ -118,8 +224,33 @@ func (h *Handlers) DeleteDocument(w http.ResponseWriter, r *http.Request) {
 	collection := chi.URLParam(r, "collection")
 	id := chi.URLParam(r, "id")
 	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
-	_, _ = h.db.Exec("DELETE FROM "+tableName(set)+" WHERE id = ? AND collection = ?", id, collection)
-	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"deleted": id}, nil)
+	if err := database.EnsureSetTable(h.db, set); err != nil { writeErr(w, err); return }
+	var res sql.Result
+	fs, fargs := rowFilter(r)
+	rs, rargs, err := ruleFilter(r, rules.Delete)
+	if err != nil { writeErr(w, err); return }
+	if r.Header.Get("If-Match") != "" || r.Header.Get("If-None-Match") != "" {
+		current, exists, verr := docVersion(h.db, r, set, collection, id)
+		if verr != nil { writeErr(w, verr); return }
+		if perr := checkPreconditions(r, exists, current); perr != nil { writeErr(w, perr); return }
+		if !exists { middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("not found")); return }
+		res, err = h.db.Exec("DELETE FROM "+tableName(set)+" WHERE id = ? AND collection = ? AND version = ?"+fs+rs, append(append([]any{id, collection, current}, fargs...), rargs...)...)
+		if err != nil { writeErr(w, err); return }
+	} else {
+		res, err = h.db.Exec("DELETE FROM "+tableName(set)+" WHERE id = ? AND collection = ?"+fs+rs, append(append([]any{id, collection}, fargs...), rargs...)...)
+		if err != nil { writeErr(w, err); return }
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		// nothing deleted: find out whether the document is gone, was changed meanwhile or is protected by the delete rule
+		current, exists, verr := docVersion(h.db, r, set, collection, id)
+		if verr != nil { writeErr(w, verr); return }
+		if !exists { middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("not found")); return }
+		if rs != "" && checkPreconditions(r, true, current) == nil { writeErr(w, rules.Denied(rules.Delete)); return }
+		writeErr(w, conflictErr(r))
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"deleted": id, "rows_affected": n}, nil)
 }
 
 func (h *Handlers) DeleteCollection(w http.ResponseWriter, r *http.Request) {
@@ -127,19 +258,124 @@ func (h *Handlers) DeleteCollection(w http.ResponseWriter, r *http.Request) {
 	set := chi.URLParam(r, "set")
 	collection := chi.URLParam(r, "collection")
 	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	// the delete rule narrows which documents go, rather than failing the whole request
+	rs, rargs, err := ruleFilter(r, rules.Delete)
+	if err != nil { writeErr(w, err); return }
 	whereStr := r.URL.Query().Get("where")
 	if strings.TrimSpace(whereStr) == "" {
-		_, _ = h.db.Exec("DELETE FROM "+tableName(set)+" WHERE collection = ?", collection)
-		middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"deleted_collection": collection}, nil)
+		res, err := h.db.Exec("DELETE FROM "+tableName(set)+" WHERE collection = ?"+rs, append([]any{collection}, rargs...)...)
+		if err != nil { writeErr(w, err); return }
+		n, _ := res.RowsAffected()
+		middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"deleted_collection": collection, "rows_affected": n}, nil)
 		return
 	}
-	pw, err := query.ParseWhere(whereStr)
+	pw, err := query.ParseWhereWith(whereStr, database.Indexes(h.db, set, collection))
 	if err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error())); return }
 	sqlStr := "DELETE FROM "+tableName(set)+" WHERE collection = ?"
 	args := []any{collection}
 	for _, c := range pw.Conds { sqlStr += " AND " + c.SQL; args = append(args, c.Args...) }
-	_, _ = h.db.Exec(sqlStr, args...)
-	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"deleted_conditional": true}, nil)
+	sqlStr += rs
+	args = append(args, rargs...)
+	res, err := h.db.Exec(sqlStr, args...)
+	if err != nil { writeErr(w, err); return }
+	n, _ := res.RowsAffected()
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"deleted_conditional": true, "rows_affected": n}, nil)
+}
+
+// UpdateCollection patches every document matching ?where=... inside one transaction,
+// accepting the same body formats as UpdateDocument. Each result is re-validated against the schema; a single failure aborts all.
+// An explicit where={} targets the whole collection.
+func (h *Handlers) UpdateCollection(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	whereStr := r.URL.Query().Get("where")
+	if strings.TrimSpace(whereStr) == "" { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("where is required (use {} to update every document)")); return }
+	pw, err := query.ParseWhereWith(whereStr, database.Indexes(h.db, set, collection))
+	if err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error())); return }
+	pw = whereWithClaims(r, pw)
+	apply, upd, verr := decodePatch(r, "")
+	if verr != nil { writeErr(w, verr); return }
+	if upd != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("update operators are only supported when patching a single document")); return }
+	if err := database.EnsureSetTable(h.db, set); err != nil { writeErr(w, err); return }
+	validator, err := validation.NewValidator(h.db, set, collection)
+	if err != nil { writeErr(w, err); return }
+
+	tx, err := h.db.Begin()
+	if err != nil { writeErr(w, err); return }
+	defer tx.Rollback()
+	sqlStr := "SELECT id, data FROM "+tableName(set)+" WHERE collection = ?"
+	args := []any{collection}
+	for _, c := range pw.Conds { sqlStr += " AND " + c.SQL; args = append(args, c.Args...) }
+	rows, err := tx.Query(sqlStr, args...)
+	if err != nil { writeErr(w, err); return }
+	type pending struct{ id, data string }
+	var updates []pending
+	for rows.Next() {
+		var id, dataStr string
+		if err := rows.Scan(&id, &dataStr); err != nil { rows.Close(); writeErr(w, err); return }
+		var old, m map[string]any
+		_ = json.Unmarshal([]byte(dataStr), &old)
+		_ = json.Unmarshal([]byte(dataStr), &m)
+		if m == nil { m = map[string]any{} }
+		m, verr := apply(m)
+		if verr != nil {
+			rows.Close()
+			middleware.WriteJSON(w, verr.Code, false, map[string]any{"id": id}, models.Ptr(verr.Message))
+			return
+		}
+		if err := validator.Validate(m); err != nil {
+			rows.Close()
+			middleware.WriteJSON(w, http.StatusBadRequest, false, map[string]any{"id": id}, models.Ptr(err.Error()))
+			return
+		}
+		err := checkClaims(tx, r, m)
+		if err == nil { err = checkRule(tx, r, rules.Update, old, m) }
+		if err != nil {
+			rows.Close()
+			if he, ok := err.(*middleware.HTTPError); ok { middleware.WriteJSON(w, he.Code, false, map[string]any{"id": id}, models.Ptr(he.Message)); return }
+			writeErr(w, err)
+			return
+		}
+		updates = append(updates, pending{id: id, data: mustJSON(m)})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil { writeErr(w, err); return }
+
+	now := time.Now().Unix()
+	var affected int64
+	for _, u := range updates {
+		res, err := tx.Exec("UPDATE "+tableName(set)+" SET data = ?, updated_at = ?, version = version + 1 WHERE id = ? AND collection = ?", u.data, now, u.id, collection)
+		if err != nil { writeErr(w, err); return }
+		n, _ := res.RowsAffected()
+		affected += n
+	}
+	if err := tx.Commit(); err != nil { writeErr(w, err); return }
+	if len(pw.Paths) > 0 { database.UpdateIndexUsage(h.db, set, collection, pw.Paths) }
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"updated": affected, "updated_at": now}, nil)
+}
+
+// docIDRe restricts client-chosen ids used by upserts.
+var docIDRe = regexp.MustCompile(`^[a-zA-Z0-9_\-]{1,128}$`)
+
+// docVersion returns the current version of a document and whether it exists for the
+// caller of r; documents hidden by claim rules do not.
+func docVersion(db *sql.DB, r *http.Request, set, collection, id string) (int64, bool, error) {
+	_, v, exists, err := docState(db, r, set, collection, id)
+	return v, exists, err
+}
+
+// docState is docVersion plus the stored document, for callers evaluating update rules.
+func docState(db *sql.DB, r *http.Request, set, collection, id string) (map[string]any, int64, bool, error) {
+	var dataStr string
+	var v int64
+	fs, fargs := rowFilter(r)
+	err := db.QueryRow("SELECT data, version FROM "+tableName(set)+" WHERE id = ? AND collection = ?"+fs, append([]any{id, collection}, fargs...)...).Scan(&dataStr, &v)
+	if err == sql.ErrNoRows { return nil, 0, false, nil }
+	if err != nil { return nil, 0, false, err }
+	var m map[string]any
+	_ = json.Unmarshal([]byte(dataStr), &m)
+	return m, v, true, nil
 }
 
 func mustJSON(v any) string { b, _ := json.Marshal(v); return string(b) }
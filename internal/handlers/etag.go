@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"microapi/internal/middleware"
+)
+
+// etag renders a document version as a strong entity tag.
+func etag(version int64) string { return `"` + strconv.FormatInt(version, 10) + `"` }
+
+// etagMatches reports whether an If-Match / If-None-Match header value lists the given
+// version or "*". Weak tags compare like strong ones since versions are exact.
+func etagMatches(header string, version int64) bool {
+	want := etag(version)
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == want {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions evaluates If-Match and If-None-Match for a write against the current
+// state of a document (exists, version) and returns a 412 error when they fail.
+func checkPreconditions(r *http.Request, exists bool, version int64) *middleware.HTTPError {
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !exists || !etagMatches(im, version) {
+			return &middleware.HTTPError{Code: http.StatusPreconditionFailed, Message: "precondition failed: document version does not match If-Match"}
+		}
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if exists && etagMatches(inm, version) {
+			return &middleware.HTTPError{Code: http.StatusPreconditionFailed, Message: "precondition failed: document matches If-None-Match"}
+		}
+	}
+	return nil
+}
+
+// conflictErr reports a write that lost a race against another writer between read and update.
+func conflictErr(r *http.Request) *middleware.HTTPError {
+	if r.Header.Get("If-Match") != "" {
+		return &middleware.HTTPError{Code: http.StatusPreconditionFailed, Message: "precondition failed: document version does not match If-Match"}
+	}
+	return &middleware.HTTPError{Code: http.StatusConflict, Message: "document was modified concurrently, retry"}
+}
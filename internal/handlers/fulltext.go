@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"microapi/internal/database"
+	"microapi/internal/middleware"
+	"microapi/internal/models"
+	"microapi/internal/query"
+)
+
+type putFullTextReq struct {
+	Fields    []string `json:"fields"`
+	Tokenizer string   `json:"tokenizer"`
+}
+
+// PutFullText indexes fields of a collection for $search: {"fields": ["title", "body"], "tokenizer": "porter"}.
+// Replacing an index rebuilds it from the stored documents.
+func (h *Handlers) PutFullText(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	var body putFullTextReq
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("invalid JSON body: expected {\"fields\": [...]}")); return }
+	var fields []string
+	seen := map[string]bool{}
+	for _, f := range body.Fields {
+		p, err := query.ParseFields(f)
+		if err != nil || len(p) != 1 { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(fmt.Sprintf("invalid field path %q", f))); return }
+		if !seen[p[0]] { seen[p[0]] = true; fields = append(fields, p[0]) }
+	}
+	if err := database.EnsureSetTable(h.db, set); err != nil { writeErr(w, err); return }
+	if err := database.EnsureCollectionMetadata(h.db, set, collection); err != nil { writeErr(w, err); return }
+	if err := database.SetFullText(h.db, set, collection, fields, body.Tokenizer); err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error())); return }
+	h.GetFullText(w, r)
+}
+
+// GetFullText describes the full-text index of a collection and how many documents it holds.
+func (h *Handlers) GetFullText(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	f, err := database.GetFullText(h.db, set, collection)
+	if errors.Is(err, database.ErrFullTextNotFound) { middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr(err.Error())); return }
+	if err != nil { writeErr(w, err); return }
+	var n int64
+	if err := h.db.QueryRow("SELECT COUNT(*) FROM " + database.FullTextTable(set, collection)).Scan(&n); err != nil { writeErr(w, err); return }
+	fields := make([]string, len(f.Fields))
+	for i, p := range f.Fields { fields[i] = p[len("$."):] }
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"fields": fields, "tokenizer": f.Tokenizer, "created_at": f.CreatedAt, "documents": n}, nil)
+}
+
+func (h *Handlers) DeleteFullText(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	err := database.DeleteFullText(h.db, set, collection)
+	if errors.Is(err, database.ErrFullTextNotFound) { middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr(err.Error())); return }
+	if err != nil { writeErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"deleted": true}, nil)
+}
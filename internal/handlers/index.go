@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -18,6 +19,11 @@ import (
 type createIndexReq struct {
 	Path  string   `json:"path"`
 	Paths []string `json:"paths"`
+	// Type is "btree" (default), "vector" or "geo". A vector index covers one path holding
+	// arrays of Dimensions numbers and backs _knn; a geo index covers one path holding
+	// points and speeds up the geo operators
+	Type       string `json:"type"`
+	Dimensions int    `json:"dimensions"`
 }
 
 func (h *Handlers) CreateIndex(w http.ResponseWriter, r *http.Request) {
@@ -41,6 +47,15 @@ func (h *Handlers) CreateIndex(w http.ResponseWriter, r *http.Request) {
 		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("path or paths required"))
 		return
 	}
+	switch body.Type {
+	case "", "btree":
+	case "vector", "geo":
+		h.createTableIndex(w, body.Type, set, collection, paths, body.Dimensions)
+		return
+	default:
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("type must be btree, vector or geo"))
+		return
+	}
 	// verify at least one path exists in some document
 	for _, p := range paths {
 		exists, err := database.EnsurePathExists(h.db, set, collection, p)
@@ -63,6 +78,37 @@ func (h *Handlers) CreateIndex(w http.ResponseWriter, r *http.Request) {
 	middleware.WriteJSON(w, http.StatusAccepted, true, map[string]any{"name": idxName, "status": "creating"}, nil)
 }
 
+// createTableIndex builds a vector or geo index in the background, like CreateIndex.
+// Documents need not hold the path yet: they are indexed as they are written.
+func (h *Handlers) createTableIndex(w http.ResponseWriter, kind, set, collection string, paths []string, dims int) {
+	if len(paths) != 1 { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(fmt.Sprintf("a %s index covers exactly one path", kind))); return }
+	path := paths[0]
+	idxName := tableIndexName(kind, set, collection, path)
+	build := func() error { return database.CreateGeoIndex(h.db, set, collection, idxName, path) }
+	if kind == "vector" {
+		if dims < 1 || dims > database.MaxVectorDimensions {
+			middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(fmt.Sprintf("dimensions must be between 1 and %d", database.MaxVectorDimensions)))
+			return
+		}
+		if err := database.CreateVectorIndexMetadata(h.db, set, collection, idxName, path, dims); err != nil { writeErr(w, err); return }
+		build = func() error { return database.CreateVectorIndex(h.db, set, collection, idxName, path, dims) }
+	} else if err := database.CreateGeoIndexMetadata(h.db, set, collection, idxName, path); err != nil { writeErr(w, err); return }
+	go func() {
+		if err := build(); err != nil {
+			_ = database.SetIndexStatus(h.db, set, collection, idxName, "error", err.Error())
+			return
+		}
+		_ = database.SetIndexStatus(h.db, set, collection, idxName, "ready", "")
+	}()
+	middleware.WriteJSON(w, http.StatusAccepted, true, map[string]any{"name": idxName, "type": kind, "status": "creating"}, nil)
+}
+
+// tableIndexName names the vector or geo index of kind on path.
+func tableIndexName(kind, set, collection, path string) string {
+	if kind == "vector" { return database.VectorIndexName(set, collection, path) }
+	return database.GeoIndexName(set, collection, path)
+}
+
 func (h *Handlers) ListIndexes(w http.ResponseWriter, r *http.Request) {
 	set := chi.URLParam(r, "set")
 	collection := chi.URLParam(r, "collection")
@@ -80,20 +126,23 @@ func (h *Handlers) GetIndexStatus(w http.ResponseWriter, r *http.Request) {
 	p, _ := url.PathUnescape(pathEnc)
 	paths := database.NormalizePaths([]string{p})
 	idxName := database.IndexName(collection, paths)
-	row := h.db.QueryRow(`SELECT status, error, usage_count, last_used_at, created_at FROM idx_metadata WHERE set_name = ? AND collection_name = ? AND idx_name = ?`, set, collection, idxName)
-	var status, errtxt string
-	var usage, last, created int64
-	if err := row.Scan(&status, &errtxt, &usage, &last, &created); err != nil {
+	if kind := r.URL.Query().Get("type"); (kind == "vector" || kind == "geo") && len(paths) == 1 { idxName = tableIndexName(kind, set, collection, paths[0]) }
+	row := h.db.QueryRow(`SELECT status, error, usage_count, last_used_at, created_at, kind FROM idx_metadata WHERE set_name = ? AND collection_name = ? AND idx_name = ?`, set, collection, idxName)
+	var status, errtxt sql.NullString
+	var usage, last, created sql.NullInt64
+	var kind string
+	if err := row.Scan(&status, &errtxt, &usage, &last, &created, &kind); err != nil {
 		middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("index not found"))
 		return
 	}
 	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{
 		"name":         idxName,
-		"status":       status,
-		"error":        errtxt,
-		"usage_count":  usage,
-		"last_used_at": last,
-		"created_at":   created,
+		"type":         kind,
+		"status":       status.String,
+		"error":        errtxt.String,
+		"usage_count":  usage.Int64,
+		"last_used_at": last.Int64,
+		"created_at":   created.Int64,
 	}, nil)
 }
 
@@ -113,7 +162,13 @@ func (h *Handlers) DeleteIndex(w http.ResponseWriter, r *http.Request) {
 		pp := database.NormalizePaths([]string{p})
 		idxName = database.IndexName(collection, pp)
 	}
-	if err := database.DropSQLIndex(h.db, idxName); err != nil { writeErr(w, err); return }
+	if kind := r.URL.Query().Get("type"); kind == "vector" || kind == "geo" {
+		p, _ := url.PathUnescape(chi.URLParam(r, "path"))
+		pp := database.NormalizePaths([]string{p})
+		if len(pp) != 1 { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(fmt.Sprintf("a %s index covers exactly one path", kind))); return }
+		idxName = tableIndexName(kind, set, collection, pp[0])
+		if err := database.DropTableIndex(h.db, idxName); err != nil { writeErr(w, err); return }
+	} else if err := database.DropSQLIndex(h.db, idxName); err != nil { writeErr(w, err); return }
 	_, _ = h.db.Exec(`DELETE FROM idx_metadata WHERE set_name = ? AND collection_name = ? AND idx_name = ?`, set, collection, idxName)
 	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"deleted": idxName, "at": time.Now().Unix()}, nil)
 }
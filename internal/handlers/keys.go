@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"microapi/internal/auth"
+	"microapi/internal/middleware"
+	"microapi/internal/models"
+)
+
+type createKeyReq struct {
+	Name   string       `json:"name"`
+	Scopes []auth.Scope `json:"scopes"`
+}
+
+// CreateKey mints an API key. The response is the only place the key itself is returned.
+func (h *Handlers) CreateKey(w http.ResponseWriter, r *http.Request) {
+	var body createKeyReq
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("invalid JSON body")); return }
+	key, err := auth.Create(h.db, body.Name, body.Scopes)
+	if err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error())); return }
+	middleware.WriteJSON(w, http.StatusCreated, true, key, nil)
+}
+
+func (h *Handlers) ListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := auth.List(h.db)
+	if err != nil { writeErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, keys, nil)
+}
+
+func (h *Handlers) GetKey(w http.ResponseWriter, r *http.Request) {
+	key, err := auth.Get(h.db, chi.URLParam(r, "key"))
+	if err != nil { writeKeyErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, key, nil)
+}
+
+// RevokeKey disables a key; it stays listed with its revoked_at time.
+func (h *Handlers) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "key")
+	if err := auth.Revoke(h.db, id); err != nil { writeKeyErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"revoked": id}, nil)
+}
+
+func writeKeyErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, auth.ErrNotFound) { middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("API key not found or already revoked")); return }
+	writeErr(w, err)
+}
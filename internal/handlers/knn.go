@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"microapi/internal/auth"
+	"microapi/internal/database"
+	"microapi/internal/middleware"
+	"microapi/internal/models"
+	"microapi/internal/query"
+)
+
+// maxKNN bounds how many neighbours a kNN query returns.
+const maxKNN = 1000
+
+type knnReq struct {
+	// Path selects the vector index; it may be left out when the collection has one
+	Path   string    `json:"path"`
+	Vector []float64 `json:"vector"`
+	K      int       `json:"k"`
+	Metric string    `json:"metric"`
+	// Where is a where filter, as an object or a JSON string
+	Where  json.RawMessage `json:"where"`
+	Fields string          `json:"fields"`
+}
+
+// KNN returns the documents whose vectors are nearest to a query vector, nearest first.
+// POST /{set}/{collection}/_knn {"vector": [...], "k": 10, "metric": "cosine", "where": {...}}
+func (h *Handlers) KNN(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	var body knnReq
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("invalid JSON body: expected {\"vector\": [...]}")); return }
+	restrict, err := whereWithRules(r, auth.RowFilter(r.Context()))
+	if err != nil { writeErr(w, err); return }
+	out, err := h.knn(set, collection, body, restrict, !suppressMeta(r))
+	if err != nil { writeErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, out, nil)
+}
+
+// knn runs a kNN query over the documents matching both the request's where and restrict.
+func (h *Handlers) knn(set, collection string, req knnReq, restrict *query.ParsedWhere, includeMeta bool) ([]map[string]any, error) {
+	if err := middleware.ValidateNames(set, collection); err != nil { return nil, err }
+	if err := database.EnsureSetTable(h.db, set); err != nil { return nil, err }
+	bad := func(msg string) error { return &middleware.HTTPError{Code: http.StatusBadRequest, Message: msg} }
+	var path string
+	if req.Path != "" {
+		p, err := query.ParseFields(req.Path)
+		if err != nil || len(p) != 1 { return nil, bad(fmt.Sprintf("invalid path %q", req.Path)) }
+		path = p[0]
+	}
+	idx, err := database.GetVectorIndex(h.db, set, collection, path)
+	if errors.Is(err, database.ErrVectorIndexNotFound) { return nil, &middleware.HTTPError{Code: http.StatusNotFound, Message: err.Error()} }
+	if err != nil { return nil, bad(err.Error()) }
+	if idx.Status != "ready" { return nil, &middleware.HTTPError{Code: http.StatusConflict, Message: fmt.Sprintf("vector index on %s is %s", idx.Path[len("$."):], idx.Status)} }
+	if len(req.Vector) != idx.Dimensions { return nil, bad(fmt.Sprintf("vector must have %d dimensions, got %d", idx.Dimensions, len(req.Vector))) }
+	vec, err := database.EncodeVector(req.Vector)
+	if err != nil { return nil, bad(err.Error()) }
+	metric, err := query.ParseMetric(req.Metric)
+	if err != nil { return nil, bad(err.Error()) }
+	k := req.K
+	if k == 0 { k = 10 }
+	if k < 1 || k > maxKNN { return nil, bad(fmt.Sprintf("k must be between 1 and %d", maxKNN)) }
+	whereStr := string(req.Where)
+	var s string
+	if json.Unmarshal(req.Where, &s) == nil { whereStr = s }
+	if whereStr == "null" { whereStr = "" }
+	pw, err := query.ParseWhereWith(whereStr, database.Indexes(h.db, set, collection))
+	if err != nil { return nil, bad(err.Error()) }
+	pw = pw.And(restrict)
+	fields, err := query.ParseFields(req.Fields)
+	if err != nil { return nil, bad(err.Error()) }
+
+	sqlStr, args := query.BuildKNN(query.KNNOpts{Set: set, Collection: collection, Table: idx.Name, Vector: vec, Metric: metric, K: k, Where: pw, Fields: fields})
+	rows, err := h.db.Query(sqlStr, args...)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	out := []map[string]any{}
+	for rows.Next() {
+		var id, dataStr string
+		var created, updated, version int64
+		if err := rows.Scan(&id, &dataStr, &created, &updated, &version); err != nil { return nil, err }
+		var m map[string]any
+		_ = json.Unmarshal([]byte(dataStr), &m)
+		if includeMeta { m["_meta"] = map[string]any{"id": id, "created_at": created, "updated_at": updated, "version": version} }
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil { return nil, err }
+	database.MarkIndexUsed(h.db, set, collection, idx.Name)
+	if pw != nil { database.UpdateIndexUsage(h.db, set, collection, pw.Paths) }
+	return out, nil
+}
@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"microapi/internal/auth"
+	"microapi/internal/database"
+	"microapi/internal/middleware"
+	"microapi/internal/query"
+	"microapi/internal/rules"
+)
+
+const (
+	// liveDefaultLimit and liveMaxLimit bound the result set a live query keeps in sync.
+	liveDefaultLimit = 100
+	liveMaxLimit     = 1000
+	// liveMaxSubs caps subscriptions per connection.
+	liveMaxSubs = 64
+	// liveMaxUnacked is how many result/diff messages a subscription may have in flight;
+	// further changes are coalesced into one diff once the client acks.
+	liveMaxUnacked   = 8
+	livePingInterval = 30 * time.Second
+	livePongWait     = 60 * time.Second
+)
+
+// liveClientMsg is a message from the client:
+//
+//	{"type": "subscribe", "id": "q1", "set": "shop", "collection": "orders", "where": {...}, "order_by": "-created_at", "limit": 20, "fields": "total"}
+//	{"type": "unsubscribe", "id": "q1"}
+//	{"type": "ack", "id": "q1", "seq": 42}
+type liveClientMsg struct {
+	Type       string          `json:"type"`
+	ID         string          `json:"id"`
+	Set        string          `json:"set"`
+	Collection string          `json:"collection"`
+	Where      json.RawMessage `json:"where"`
+	OrderBy    string          `json:"order_by"`
+	Limit      int             `json:"limit"`
+	Fields     string          `json:"fields"`
+	Seq        int64           `json:"seq"`
+}
+
+// liveServerMsg is a message to the client. "result" carries the initial items; "diff"
+// carries the documents that entered (added), changed within (updated) or left (removed)
+// the result set, plus the new order of ids. Seq is the changelog position both reflect.
+type liveServerMsg struct {
+	Type    string           `json:"type"`
+	ID      string           `json:"id,omitempty"`
+	Seq     int64            `json:"seq,omitempty"`
+	Items   []map[string]any `json:"items,omitempty"`
+	Added   []map[string]any `json:"added,omitempty"`
+	Updated []map[string]any `json:"updated,omitempty"`
+	Removed []string         `json:"removed,omitempty"`
+	Order   []string         `json:"order,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// liveSub is one live query and the result set last sent for it.
+type liveSub struct {
+	id       string
+	opts     query.BuildOpts
+	seq      int64            // changelog position of the last snapshot
+	versions map[string]int64 // id -> version of the documents last sent
+	sent     []int64          // seqs sent but not yet acked
+}
+
+// Live upgrades to a WebSocket speaking the live query protocol (see liveClientMsg).
+// Each subscription first receives its result set, then a diff whenever writes to its
+// collection change which documents match or their contents.
+func (h *Handlers) Live(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || len(h.cfg.CORSOrigins) == 0 || slices.Contains(h.cfg.CORSOrigins, origin)
+	}}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader already replied
+	}
+	defer conn.Close()
+
+	incoming := make(chan liveClientMsg)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.SetReadDeadline(time.Now().Add(livePongWait))
+		conn.SetPongHandler(func(string) error { return conn.SetReadDeadline(time.Now().Add(livePongWait)) })
+		for {
+			var msg liveClientMsg
+			if err := conn.ReadJSON(&msg); err != nil {
+				var syntaxErr *json.SyntaxError
+				var typeErr *json.UnmarshalTypeError
+				if !errors.As(err, &syntaxErr) && !errors.As(err, &typeErr) {
+					return // closed or broken connection
+				}
+				msg = liveClientMsg{Type: "invalid"}
+			}
+			select {
+			case incoming <- msg:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	subs := map[string]*liveSub{}
+	poll := time.NewTicker(changesPollInterval)
+	defer poll.Stop()
+	ping := time.NewTicker(livePingInterval)
+	defer ping.Stop()
+	var head int64
+	for {
+		select {
+		case <-done:
+			return
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		case msg := <-incoming:
+			if err := h.handleLiveMsg(r.Context(), conn, subs, msg); err != nil {
+				return
+			}
+		case <-poll.C:
+			if err := h.db.QueryRowContext(r.Context(), `SELECT COALESCE(MAX(seq), 0) FROM changelog`).Scan(&head); err != nil {
+				return
+			}
+			for _, sub := range subs {
+				if err := h.refreshLive(r.Context(), conn, sub, head); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// handleLiveMsg applies one client message. Protocol errors are reported to the client;
+// only a failed write is returned.
+func (h *Handlers) handleLiveMsg(ctx context.Context, conn *websocket.Conn, subs map[string]*liveSub, msg liveClientMsg) error {
+	fail := func(err string) error {
+		return conn.WriteJSON(liveServerMsg{Type: "error", ID: msg.ID, Error: err})
+	}
+	if msg.Type != "invalid" && msg.ID == "" {
+		return fail("id is required")
+	}
+	switch msg.Type {
+	case "subscribe":
+		if _, ok := subs[msg.ID]; ok {
+			return fail("subscription id already in use")
+		}
+		if len(subs) >= liveMaxSubs {
+			return fail(fmt.Sprintf("too many subscriptions (max %d)", liveMaxSubs))
+		}
+		opts, err := liveOpts(msg)
+		if err != nil {
+			return fail(err.Error())
+		}
+		p := auth.FromContext(ctx)
+		if !p.Can(opts.Set, opts.Collection, auth.Read) {
+			return fail("access denied")
+		}
+		// the read rule is fixed for the life of the subscription
+		ck, err := rules.NewChecker(h.db, opts.Set, opts.Collection, rules.IdentityOf(p, opts.Set, opts.Collection))
+		if err != nil {
+			return fail(err.Error())
+		}
+		restrict, err := ck.Filter(rules.Read)
+		if err != nil {
+			return fail(err.Error())
+		}
+		opts.Where = opts.Where.And(restrict)
+		if err := database.EnsureSetTable(h.db, opts.Set); err != nil {
+			return fail(err.Error())
+		}
+		sub := &liveSub{id: msg.ID, opts: opts}
+		items, seq, err := h.snapshotLive(ctx, sub)
+		if err != nil {
+			return fail(err.Error())
+		}
+		subs[msg.ID] = sub
+		sub.sent = append(sub.sent, seq)
+		return conn.WriteJSON(liveServerMsg{Type: "result", ID: sub.id, Seq: seq, Items: items})
+	case "unsubscribe":
+		if _, ok := subs[msg.ID]; !ok {
+			return fail("unknown subscription")
+		}
+		delete(subs, msg.ID)
+		return conn.WriteJSON(liveServerMsg{Type: "unsubscribed", ID: msg.ID})
+	case "ack":
+		sub, ok := subs[msg.ID]
+		if !ok {
+			return fail("unknown subscription")
+		}
+		// an ack covers every message up to and including seq
+		n := 0
+		for n < len(sub.sent) && sub.sent[n] <= msg.Seq {
+			n++
+		}
+		sub.sent = sub.sent[n:]
+		return nil
+	case "invalid":
+		return fail("invalid message: expected a JSON object")
+	default:
+		return fail("type must be one of subscribe, unsubscribe, ack")
+	}
+}
+
+// liveOpts validates a subscribe message and turns it into query options.
+func liveOpts(msg liveClientMsg) (query.BuildOpts, error) {
+	if msg.Set == "" || msg.Collection == "" {
+		return query.BuildOpts{}, fmt.Errorf("set and collection are required")
+	}
+	if err := middleware.ValidateNames(msg.Set, msg.Collection); err != nil {
+		return query.BuildOpts{}, err
+	}
+	// where may be sent as an object or, like the query parameter, as a JSON string
+	whereStr := string(msg.Where)
+	var s string
+	if err := json.Unmarshal(msg.Where, &s); err == nil {
+		whereStr = s
+	}
+	pw, err := query.ParseWhere(whereStr)
+	if err != nil {
+		return query.BuildOpts{}, err
+	}
+	orderBy, err := query.ParseOrderBy(msg.OrderBy)
+	if err != nil {
+		return query.BuildOpts{}, err
+	}
+	fields, err := query.ParseFields(msg.Fields)
+	if err != nil {
+		return query.BuildOpts{}, err
+	}
+	limit := msg.Limit
+	if limit == 0 {
+		limit = liveDefaultLimit
+	}
+	if limit < 0 || limit > liveMaxLimit {
+		return query.BuildOpts{}, fmt.Errorf("limit must be between 1 and %d", liveMaxLimit)
+	}
+	return query.BuildOpts{Set: msg.Set, Collection: msg.Collection, Where: pw, OrderBy: orderBy, Fields: fields, Limit: limit, Offset: -1}, nil
+}
+
+// refreshLive re-runs a subscription's query when its collection changed since the last
+// snapshot and sends the differences. While too many messages are unacked it waits, so
+// the next diff covers everything that happened meanwhile.
+func (h *Handlers) refreshLive(ctx context.Context, conn *websocket.Conn, sub *liveSub, head int64) error {
+	if head <= sub.seq || len(sub.sent) >= liveMaxUnacked {
+		return nil
+	}
+	var changed bool
+	err := h.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM changelog WHERE set_name = ? AND collection_name = ? AND seq > ? AND seq <= ?)`,
+		sub.opts.Set, sub.opts.Collection, sub.seq, head).Scan(&changed)
+	if err != nil || !changed {
+		sub.seq = head
+		return nil
+	}
+	prev := sub.versions
+	items, seq, err := h.snapshotLive(ctx, sub)
+	if err != nil {
+		return conn.WriteJSON(liveServerMsg{Type: "error", ID: sub.id, Error: err.Error()})
+	}
+	msg := liveServerMsg{Type: "diff", ID: sub.id, Seq: seq, Order: make([]string, 0, len(items))}
+	for _, doc := range items {
+		meta := doc["_meta"].(map[string]any)
+		id := meta["id"].(string)
+		msg.Order = append(msg.Order, id)
+		old, ok := prev[id]
+		switch {
+		case !ok:
+			msg.Added = append(msg.Added, doc)
+		case old != meta["version"].(int64):
+			msg.Updated = append(msg.Updated, doc)
+		}
+	}
+	for id := range prev {
+		if _, ok := sub.versions[id]; !ok {
+			msg.Removed = append(msg.Removed, id)
+		}
+	}
+	if len(msg.Added) == 0 && len(msg.Updated) == 0 && len(msg.Removed) == 0 {
+		return nil
+	}
+	sub.sent = append(sub.sent, seq)
+	return conn.WriteJSON(msg)
+}
+
+// snapshotLive runs a subscription's query, records the versions it returned and
+// returns the documents with their _meta along with the changelog position they reflect.
+func (h *Handlers) snapshotLive(ctx context.Context, sub *liveSub) ([]map[string]any, int64, error) {
+	// a read-only transaction gives one consistent view of the changelog and the data
+	// without taking the write lock
+	tx, err := h.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+	var seq int64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(seq), 0) FROM changelog`).Scan(&seq); err != nil {
+		return nil, 0, err
+	}
+	sqlStr, args := query.BuildSelect(sub.opts)
+	rows, err := tx.Query(sqlStr, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	items := []map[string]any{}
+	versions := map[string]int64{}
+	// live queries are not resumed by cursors, but the page query selects the sort keys
+	keys := sub.opts.KeyColumns()
+	for rows.Next() {
+		var id, dataStr string
+		var created, updated, version int64
+		var distance sql.NullFloat64
+		if err := rows.Scan(append([]any{&id, &dataStr, &created, &updated, &version, &distance}, keys...)...); err != nil {
+			return nil, 0, err
+		}
+		var m map[string]any
+		_ = json.Unmarshal([]byte(dataStr), &m)
+		if m == nil {
+			m = map[string]any{}
+		}
+		meta := map[string]any{"id": id, "created_at": created, "updated_at": updated, "version": version}
+		if distance.Valid {
+			meta["distance"] = distance.Float64
+		}
+		m["_meta"] = meta
+		items = append(items, m)
+		versions[id] = version
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	sub.versions = versions
+	sub.seq = seq
+	return items, seq, nil
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -9,10 +10,13 @@ import (
 
 	"github.com/rs/xid"
 
+	"microapi/internal/auth"
 	"microapi/internal/database"
 	"microapi/internal/middleware"
 	"microapi/internal/models"
 	"microapi/internal/query"
+	"microapi/internal/rules"
+	"microapi/internal/txn"
 )
 
 // MCPDiscovery returns tool definitions for MCP clients.
@@ -45,6 +49,7 @@ func (h *Handlers) MCPDiscovery(w http.ResponseWriter, r *http.Request) {
 					"set":        map[string]any{"type": "string"},
 					"collection": map[string]any{"type": "string"},
 					"id":         map[string]any{"type": "string"},
+					"fields":     map[string]any{"type": "string", "description": "comma-separated JSON paths to return (e.g. title,user.name)"},
 				},
 				"required": []string{"set", "collection", "id"},
 			},
@@ -85,14 +90,67 @@ func (h *Handlers) MCPDiscovery(w http.ResponseWriter, r *http.Request) {
 					"set":          map[string]any{"type": "string"},
 					"collection":   map[string]any{"type": "string"},
 					"where":        map[string]any{"type": "string", "description": "JSON string of where filters"},
-					"order_by":     map[string]any{"type": "string"},
+					"search":       map[string]any{"type": "string", "description": "full-text search across the collection's indexed fields; results are ranked by relevance unless order_by is set"},
+					"snippet":      map[string]any{"type": "boolean", "description": "add a highlighted snippet to each search result"},
+					"order_by":     map[string]any{"type": "string", "description": "comma-separated fields, '-' prefix for descending (e.g. -priority,created_at)"},
 					"limit":        map[string]any{"type": "integer"},
 					"offset":       map[string]any{"type": "integer"},
+					"fields":       map[string]any{"type": "string", "description": "comma-separated JSON paths to return (e.g. title,user.name)"},
+					"cursor":       map[string]any{"type": "string", "description": "opaque token from X-Next-Cursor to fetch the next page"},
 					"include_meta": map[string]any{"type": "boolean", "default": true},
 				},
 				"required": []string{"set", "collection"},
 			},
 		},
+		{
+			"name":        "aggregate_collection",
+			"description": "Compute count/sum/avg/min/max/count_distinct over a collection, optionally filtered and grouped",
+			"parameters": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"set":        map[string]any{"type": "string"},
+					"collection": map[string]any{"type": "string"},
+					"where":      map[string]any{"type": "string", "description": "JSON string of where filters"},
+					"group_by":   map[string]any{"type": "string", "description": "comma-separated JSON paths to group by"},
+					"aggs":       map[string]any{"type": "string", "description": "comma-separated aggregates (e.g. count,sum:price,avg:price); defaults to count"},
+				},
+				"required": []string{"set", "collection"},
+			},
+		},
+		{
+			"name":        "knn_search",
+			"description": "Find the documents whose embedding is nearest to a query vector, using the collection's vector index; nearest first, with the distance in _knn",
+			"parameters": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"set":          map[string]any{"type": "string"},
+					"collection":   map[string]any{"type": "string"},
+					"vector":       map[string]any{"type": "array", "items": map[string]any{"type": "number"}, "description": "query vector, with as many dimensions as the index"},
+					"k":            map[string]any{"type": "integer", "description": "number of neighbours to return (default 10)"},
+					"metric":       map[string]any{"type": "string", "enum": []string{"cosine", "dot", "l2"}, "description": "distance metric (default cosine)"},
+					"path":         map[string]any{"type": "string", "description": "vector field to search; only needed when the collection has several vector indexes"},
+					"where":        map[string]any{"type": "string", "description": "JSON string of where filters applied before ranking"},
+					"fields":       map[string]any{"type": "string", "description": "comma-separated JSON paths to return (e.g. title,user.name)"},
+					"include_meta": map[string]any{"type": "boolean", "default": true},
+				},
+				"required": []string{"set", "collection", "vector"},
+			},
+		},
+		{
+			"name":        "transaction",
+			"description": "Run create/replace/patch/delete operations across sets and collections atomically; all are rolled back on the first failure",
+			"parameters": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"operations": map[string]any{
+						"type":        "array",
+						"description": "ordered operations: {op, set, collection, id?, ref?, data?, if_match?}; \"$ref:<name>\" stands for the id created by an earlier op with that ref",
+						"items":       map[string]any{"type": "object"},
+					},
+				},
+				"required": []string{"operations"},
+			},
+		},
 	}
 	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"tools": tools}, nil)
 }
@@ -111,25 +169,73 @@ func (h *Handlers) MCPCall(w http.ResponseWriter, r *http.Request) {
 		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("invalid JSON body"))
 		return
 	}
+	if !mcpAllowed(r.Context(), req) {
+		auth.Forbidden(w)
+		return
+	}
+	ck, err := mcpRules(r.Context(), h.db, req)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
 	switch req.Tool {
 	case "list_sets":
-		listSetsMCP(h.db, w)
+		listSetsMCP(h.db, w, auth.FromContext(r.Context()))
 	case "create_document":
-		createDocMCP(h, w, req.Args)
+		createDocMCP(h, w, req.Args, ck)
 	case "get_document":
-		getDocMCP(h, w, req.Args)
+		getDocMCP(h, w, req.Args, ck)
 	case "update_document":
-		updateDocMCP(h, w, req.Args)
+		updateDocMCP(h, w, req.Args, ck)
 	case "delete_document":
-		deleteDocMCP(h, w, req.Args)
+		deleteDocMCP(h, w, req.Args, ck)
 	case "query_collection":
-		queryCollectionMCP(h, w, req.Args)
+		queryCollectionMCP(h, w, req.Args, ck)
+	case "aggregate_collection":
+		aggregateCollectionMCP(h, w, req.Args, ck)
+	case "knn_search":
+		knnSearchMCP(h, w, req.Args, ck)
+	case "transaction":
+		transactionMCP(h, w, req.Args, auth.FromContext(r.Context()))
 	default:
 		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("unknown tool"))
 	}
 }
 
-func listSetsMCP(db *sql.DB, w http.ResponseWriter) {
+// mcpAllowed checks the caller's access to the set and collection a tool call targets.
+// list_sets filters its own output and transaction is checked per operation.
+func mcpAllowed(ctx context.Context, req mcpRequest) bool {
+	p := auth.FromContext(ctx)
+	set, _ := req.Args["set"].(string)
+	collection, _ := req.Args["collection"].(string)
+	switch req.Tool {
+	case "create_document", "update_document", "delete_document":
+		return p.Can(set, collection, auth.Write)
+	case "get_document", "query_collection", "aggregate_collection", "knn_search":
+		return p.Can(set, collection, auth.Read)
+	case "transaction":
+		var ops []txn.Operation
+		b, _ := json.Marshal(req.Args["operations"])
+		if err := json.Unmarshal(b, &ops); err != nil {
+			return true // reported as a bad request by the tool itself
+		}
+		return authorizeOps(ctx, ops) == nil
+	}
+	return true
+}
+
+// mcpRules loads the document rules of the collection a tool call targets. Tools without
+// one get a nil checker, which allows everything; transactions check their own operations.
+func mcpRules(ctx context.Context, db *sql.DB, req mcpRequest) (*rules.Checker, error) {
+	set, _ := req.Args["set"].(string)
+	collection, _ := req.Args["collection"].(string)
+	if set == "" || collection == "" || middleware.ValidateNames(set, collection) != nil {
+		return nil, nil
+	}
+	return rules.NewChecker(db, set, collection, rules.IdentityOf(auth.FromContext(ctx), set, collection))
+}
+
+func listSetsMCP(db *sql.DB, w http.ResponseWriter, p *auth.Principal) {
 	rows, err := db.Query(`SELECT DISTINCT set_name FROM metadata ORDER BY set_name`)
 	if err != nil {
 		middleware.WriteJSON(w, http.StatusInternalServerError, false, nil, models.Ptr(err.Error()))
@@ -140,12 +246,14 @@ func listSetsMCP(db *sql.DB, w http.ResponseWriter) {
 	for rows.Next() {
 		var s string
 		_ = rows.Scan(&s)
-		sets = append(sets, s)
+		if p.Can(s, "", auth.Read) {
+			sets = append(sets, s)
+		}
 	}
 	middleware.WriteJSON(w, http.StatusOK, true, sets, nil)
 }
 
-func createDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any) {
+func createDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any, ck *rules.Checker) {
 	set, _ := args["set"].(string)
 	collection, _ := args["collection"].(string)
 	rawDoc, _ := args["document"].(map[string]any)
@@ -170,6 +278,10 @@ func createDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any) {
 		middleware.WriteJSON(w, verr.Code, false, nil, models.Ptr(verr.Message))
 		return
 	}
+	if err := ck.Check(h.db, rules.Create, nil, body); err != nil {
+		writeErr(w, err)
+		return
+	}
 	id := xid.New().String()
 	now := time.Now().Unix()
 	b, _ := json.Marshal(body)
@@ -182,14 +294,15 @@ func createDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any) {
 	if body == nil {
 		body = map[string]any{}
 	}
-	body["_meta"] = map[string]any{"id": id, "created_at": now, "updated_at": now}
+	body["_meta"] = map[string]any{"id": id, "created_at": now, "updated_at": now, "version": 1}
 	middleware.WriteJSON(w, http.StatusCreated, true, body, nil)
 }
 
-func getDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any) {
+func getDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any, ck *rules.Checker) {
 	set, _ := args["set"].(string)
 	collection, _ := args["collection"].(string)
 	id, _ := args["id"].(string)
+	fieldSpec, _ := args["fields"].(string)
 	if set == "" || collection == "" || id == "" {
 		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("set, collection and id are required"))
 		return
@@ -202,9 +315,27 @@ func getDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any) {
 		writeErr(w, err)
 		return
 	}
+	fields, err := query.ParseFields(fieldSpec)
+	if err != nil {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
+		return
+	}
+	restrict, err := ck.Filter(rules.Read)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	sqlStr := "SELECT " + query.DataExpr(fields) + ", created_at, updated_at, version FROM " + tableName(set) + " WHERE id = ? AND collection = ?"
+	sqlArgs := []any{id, collection}
+	if restrict != nil {
+		for _, c := range restrict.Conds {
+			sqlStr += " AND " + c.SQL
+			sqlArgs = append(sqlArgs, c.Args...)
+		}
+	}
 	var dataStr string
-	var created, updated int64
-	err := h.db.QueryRow("SELECT data, created_at, updated_at FROM "+tableName(set)+" WHERE id = ? AND collection = ?", id, collection).Scan(&dataStr, &created, &updated)
+	var created, updated, version int64
+	err = h.db.QueryRow(sqlStr, sqlArgs...).Scan(&dataStr, &created, &updated, &version)
 	if err == sql.ErrNoRows {
 		middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("not found"))
 		return
@@ -218,11 +349,11 @@ func getDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any) {
 	if m == nil {
 		m = map[string]any{}
 	}
-	m["_meta"] = map[string]any{"id": id, "created_at": created, "updated_at": updated}
+	m["_meta"] = map[string]any{"id": id, "created_at": created, "updated_at": updated, "version": version}
 	middleware.WriteJSON(w, http.StatusOK, true, m, nil)
 }
 
-func updateDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any) {
+func updateDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any, ck *rules.Checker) {
 	set, _ := args["set"].(string)
 	collection, _ := args["collection"].(string)
 	id, _ := args["id"].(string)
@@ -242,7 +373,8 @@ func updateDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any) {
 	}
 	// read existing
 	var dataStr string
-	err := h.db.QueryRow("SELECT data FROM "+tableName(set)+" WHERE id = ? AND collection = ?", id, collection).Scan(&dataStr)
+	var current int64
+	err := h.db.QueryRow("SELECT data, version FROM "+tableName(set)+" WHERE id = ? AND collection = ?", id, collection).Scan(&dataStr, &current)
 	if err == sql.ErrNoRows {
 		middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("not found"))
 		return
@@ -251,7 +383,8 @@ func updateDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any) {
 		writeErr(w, err)
 		return
 	}
-	var m map[string]any
+	var old, m map[string]any
+	_ = json.Unmarshal([]byte(dataStr), &old)
 	_ = json.Unmarshal([]byte(dataStr), &m)
 	if m == nil {
 		m = map[string]any{}
@@ -259,23 +392,32 @@ func updateDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any) {
 	for k, v := range sanitized {
 		m[k] = v
 	}
+	if err := ck.Check(h.db, rules.Update, old, m); err != nil {
+		writeErr(w, err)
+		return
+	}
 	now := time.Now().Unix()
-	_, err = h.db.Exec("UPDATE "+tableName(set)+" SET data = ?, updated_at = ? WHERE id = ? AND collection = ?", mustJSON(m), now, id, collection)
+	// only write over the version the rule was checked against
+	res, err := h.db.Exec("UPDATE "+tableName(set)+" SET data = ?, updated_at = ?, version = version + 1 WHERE id = ? AND collection = ? AND version = ?", mustJSON(m), now, id, collection, current)
 	if err != nil {
 		writeErr(w, err)
 		return
 	}
-	var created, updated int64
-	err = h.db.QueryRow("SELECT created_at, updated_at FROM "+tableName(set)+" WHERE id = ? AND collection = ?", id, collection).Scan(&created, &updated)
+	if n, _ := res.RowsAffected(); n == 0 {
+		middleware.WriteJSON(w, http.StatusConflict, false, nil, models.Ptr("document was modified concurrently, retry"))
+		return
+	}
+	var created, updated, version int64
+	err = h.db.QueryRow("SELECT created_at, updated_at, version FROM "+tableName(set)+" WHERE id = ? AND collection = ?", id, collection).Scan(&created, &updated, &version)
 	if err != nil {
 		writeErr(w, err)
 		return
 	}
-	m["_meta"] = map[string]any{"id": id, "created_at": created, "updated_at": updated}
+	m["_meta"] = map[string]any{"id": id, "created_at": created, "updated_at": updated, "version": version}
 	middleware.WriteJSON(w, http.StatusOK, true, m, nil)
 }
 
-func deleteDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any) {
+func deleteDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any, ck *rules.Checker) {
 	set, _ := args["set"].(string)
 	collection, _ := args["collection"].(string)
 	id, _ := args["id"].(string)
@@ -287,15 +429,47 @@ func deleteDocMCP(h *Handlers, w http.ResponseWriter, args map[string]any) {
 		writeErr(w, err)
 		return
 	}
-	_, _ = h.db.Exec("DELETE FROM "+tableName(set)+" WHERE id = ? AND collection = ?", id, collection)
-	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"deleted": id}, nil)
+	restrict, err := ck.Filter(rules.Delete)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	sqlStr := "DELETE FROM " + tableName(set) + " WHERE id = ? AND collection = ?"
+	sqlArgs := []any{id, collection}
+	if restrict != nil {
+		for _, c := range restrict.Conds {
+			sqlStr += " AND " + c.SQL
+			sqlArgs = append(sqlArgs, c.Args...)
+		}
+	}
+	res, err := h.db.Exec(sqlStr, sqlArgs...)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		var exists bool
+		_ = h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM "+tableName(set)+" WHERE id = ? AND collection = ?)", id, collection).Scan(&exists)
+		if exists && restrict != nil {
+			writeErr(w, rules.Denied(rules.Delete))
+			return
+		}
+		middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("not found"))
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"deleted": id, "rows_affected": n}, nil)
 }
 
-func queryCollectionMCP(h *Handlers, w http.ResponseWriter, args map[string]any) {
+func queryCollectionMCP(h *Handlers, w http.ResponseWriter, args map[string]any, ck *rules.Checker) {
 	set, _ := args["set"].(string)
 	collection, _ := args["collection"].(string)
 	whereStr, _ := args["where"].(string)
-	orderBy, _ := args["order_by"].(string)
+	search, _ := args["search"].(string)
+	snippet, _ := args["snippet"].(bool)
+	orderSpec, _ := args["order_by"].(string)
+	cursor, _ := args["cursor"].(string)
+	fieldSpec, _ := args["fields"].(string)
 	// limit/offset may be float64 when decoded into interface{}
 	var limit, offset int
 	if v, ok := args["limit"].(float64); ok {
@@ -320,7 +494,36 @@ func queryCollectionMCP(h *Handlers, w http.ResponseWriter, args map[string]any)
 		writeErr(w, err)
 		return
 	}
-	pw, err := query.ParseWhere(whereStr)
+	pw, err := query.ParseWhereWith(whereStr, database.Indexes(h.db, set, collection))
+	if err != nil {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
+		return
+	}
+	if search != "" {
+		sw, err := query.Search(search, database.Indexes(h.db, set, collection))
+		if err != nil {
+			middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
+			return
+		}
+		pw = pw.And(sw)
+	}
+	restrict, err := ck.Filter(rules.Read)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	pw = pw.And(restrict)
+	orderBy, err := query.ParseOrderBy(orderSpec)
+	if err != nil {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
+		return
+	}
+	fields, err := query.ParseFields(fieldSpec)
+	if err != nil {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
+		return
+	}
+	after, err := parseCursor(cursor, orderBy, offset)
 	if err != nil {
 		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error()))
 		return
@@ -333,7 +536,12 @@ func queryCollectionMCP(h *Handlers, w http.ResponseWriter, args map[string]any)
 		w.Header().Set("X-Total-Items", fmt.Sprintf("%d", total))
 	}
 
-	sqlStr, argsSQL := query.BuildSelect(query.BuildOpts{Set: set, Collection: collection, Where: pw, OrderBy: orderBy, Limit: limit, Offset: offset})
+	opts := query.BuildOpts{Set: set, Collection: collection, Where: pw, OrderBy: orderBy, Fields: fields, Limit: limit, Offset: offset, After: after, Snippets: snippet}
+	if after != nil && opts.Ranked() {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("cursor cannot be used with results ranked by relevance or distance: page with offset or set order_by"))
+		return
+	}
+	sqlStr, argsSQL := query.BuildSelect(opts)
 	rows, err := h.db.Query(sqlStr, argsSQL...)
 	if err != nil {
 		writeErr(w, err)
@@ -341,24 +549,113 @@ func queryCollectionMCP(h *Handlers, w http.ResponseWriter, args map[string]any)
 	}
 	defer rows.Close()
 	var results []map[string]any
+	keys := opts.KeyColumns()
 	for rows.Next() {
 		var id string
 		var dataStr string
-		var created, updated int64
-		if err := rows.Scan(&id, &dataStr, &created, &updated); err == nil {
-			var m map[string]any
-			_ = json.Unmarshal([]byte(dataStr), &m)
-			if includeMeta {
-				if m == nil {
-					m = map[string]any{}
-				}
-				m["_meta"] = map[string]any{"id": id, "created_at": created, "updated_at": updated}
+		var created, updated, version int64
+		var distance sql.NullFloat64
+		if err := rows.Scan(append([]any{&id, &dataStr, &created, &updated, &version, &distance}, keys...)...); err != nil {
+			writeErr(w, err)
+			return
+		}
+		var m map[string]any
+		_ = json.Unmarshal([]byte(dataStr), &m)
+		if includeMeta {
+			if m == nil {
+				m = map[string]any{}
+			}
+			meta := map[string]any{"id": id, "created_at": created, "updated_at": updated, "version": version}
+			if distance.Valid {
+				meta["distance"] = distance.Float64
 			}
-			results = append(results, m)
+			m["_meta"] = meta
 		}
+		results = append(results, m)
+	}
+	if err := rows.Err(); err != nil {
+		writeErr(w, err)
+		return
 	}
 	if results == nil {
 		results = []map[string]any{}
 	}
+	next, err := nextCursor(opts, len(results), keys)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
 	middleware.WriteJSON(w, http.StatusOK, true, results, nil)
 }
+
+func aggregateCollectionMCP(h *Handlers, w http.ResponseWriter, args map[string]any, ck *rules.Checker) {
+	set, _ := args["set"].(string)
+	collection, _ := args["collection"].(string)
+	whereStr, _ := args["where"].(string)
+	groupBy, _ := args["group_by"].(string)
+	aggs, _ := args["aggs"].(string)
+	if set == "" || collection == "" {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("set and collection are required"))
+		return
+	}
+	restrict, err := ck.Filter(rules.Read)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	out, err := h.aggregate(set, collection, whereStr, groupBy, aggs, restrict)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, true, out, nil)
+}
+
+func knnSearchMCP(h *Handlers, w http.ResponseWriter, args map[string]any, ck *rules.Checker) {
+	set, _ := args["set"].(string)
+	collection, _ := args["collection"].(string)
+	if set == "" || collection == "" {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("set and collection are required"))
+		return
+	}
+	// the arguments decode into the same shape as the REST body
+	var req knnReq
+	b, _ := json.Marshal(args)
+	if err := json.Unmarshal(b, &req); err != nil {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("invalid arguments: vector must be an array of numbers and k an integer"))
+		return
+	}
+	includeMeta := true
+	if v, ok := args["include_meta"].(bool); ok {
+		includeMeta = v
+	}
+	restrict, err := ck.Filter(rules.Read)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	out, err := h.knn(set, collection, req, restrict, includeMeta)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, true, out, nil)
+}
+
+func transactionMCP(h *Handlers, w http.ResponseWriter, args map[string]any, p *auth.Principal) {
+	var ops []txn.Operation
+	b, _ := json.Marshal(args["operations"])
+	if err := json.Unmarshal(b, &ops); err != nil {
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("operations must be an array of operation objects"))
+		return
+	}
+	results, err := txn.Execute(h.db, ops, p)
+	if err != nil {
+		writeTxErr(w, err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"results": results}, nil)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"microapi/internal/middleware"
+	"microapi/internal/patch"
+	"microapi/internal/query"
+)
+
+// patchFunc applies a decoded PATCH body to a stored document and returns the new document.
+type patchFunc func(doc map[string]any) (map[string]any, *middleware.HTTPError)
+
+// decodePatch reads a PATCH body according to its Content-Type:
+//   - application/merge-patch+json: RFC 7386 deep merge, null removes a key
+//   - application/json-patch+json: RFC 6902 operations (add/remove/replace/move/copy/test)
+//   - anything else: shallow merge of top-level keys, or update operators ($inc, $push, ...)
+//     when the top-level keys start with "$"; those are returned compiled instead of as a patchFunc
+//
+// id is the target document id, or empty when the patch applies to many documents.
+func decodePatch(r *http.Request, id string) (patchFunc, *query.Update, *middleware.HTTPError) {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct == "application/json-patch+json" {
+		var ops []patch.Operation
+		if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+			return nil, nil, &middleware.HTTPError{Code: http.StatusBadRequest, Message: "invalid JSON Patch body: expected an array of operations"}
+		}
+		return func(doc map[string]any) (map[string]any, *middleware.HTTPError) {
+			res, err := patch.ApplyJSONPatch(doc, ops)
+			if err != nil {
+				code := http.StatusUnprocessableEntity
+				if errors.Is(err, patch.ErrTestFailed) {
+					code = http.StatusConflict
+				}
+				return nil, &middleware.HTTPError{Code: code, Message: err.Error()}
+			}
+			m, ok := res.(map[string]any)
+			if !ok {
+				return nil, &middleware.HTTPError{Code: http.StatusUnprocessableEntity, Message: "patched document must be an object"}
+			}
+			for k := range m {
+				if strings.HasPrefix(k, "_") {
+					return nil, &middleware.HTTPError{Code: http.StatusBadRequest, Message: "fields starting with '_' are reserved"}
+				}
+			}
+			return m, nil
+		}, nil, nil
+	}
+
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, nil, &middleware.HTTPError{Code: http.StatusBadRequest, Message: "invalid JSON body"}
+	}
+	var sanitized map[string]any
+	var verr *middleware.HTTPError
+	if id == "" {
+		sanitized, verr = sanitizeForCreate(body)
+	} else {
+		sanitized, verr = sanitizeForPutPatch(body, id)
+	}
+	if verr != nil {
+		return nil, nil, verr
+	}
+	if ct == "application/merge-patch+json" {
+		return func(doc map[string]any) (map[string]any, *middleware.HTTPError) {
+			return patch.MergePatch(doc, sanitized).(map[string]any), nil
+		}, nil, nil
+	}
+	if query.IsUpdate(sanitized) {
+		upd, err := query.ParseUpdate(sanitized)
+		if err != nil {
+			return nil, nil, &middleware.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+		return nil, upd, nil
+	}
+	return func(doc map[string]any) (map[string]any, *middleware.HTTPError) {
+		for k, v := range sanitized { doc[k] = v }
+		return doc, nil
+	}, nil, nil
+}
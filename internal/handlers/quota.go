@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"microapi/internal/database"
+	"microapi/internal/middleware"
+	"microapi/internal/models"
+)
+
+// PutQuota limits the documents and bytes a set may store: {"max_documents": 1000, "max_bytes": 1048576}.
+// Either limit may be null or omitted.
+func (h *Handlers) PutQuota(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	if err := middleware.ValidateNames(set, ""); err != nil { writeErr(w, err); return }
+	var q database.Quota
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("invalid JSON body: expected {\"max_documents\": n, \"max_bytes\": n}")); return }
+	if err := database.SetQuota(h.db, set, q); err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error())); return }
+	h.GetQuota(w, r)
+}
+
+// GetQuota reports a set's quota next to its current usage. Without a quota both limits are null.
+func (h *Handlers) GetQuota(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	if err := middleware.ValidateNames(set, ""); err != nil { writeErr(w, err); return }
+	if err := database.EnsureSetTable(h.db, set); err != nil { writeErr(w, err); return }
+	q, err := database.GetQuota(h.db, set)
+	if errors.Is(err, database.ErrQuotaNotFound) { q, err = &database.Quota{}, nil }
+	if err != nil { writeErr(w, err); return }
+	u, err := database.GetUsage(h.db, set)
+	if err != nil { writeErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"quota": q, "usage": u}, nil)
+}
+
+func (h *Handlers) DeleteQuota(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	if err := middleware.ValidateNames(set, ""); err != nil { writeErr(w, err); return }
+	err := database.DeleteQuota(h.db, set)
+	if errors.Is(err, database.ErrQuotaNotFound) { middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr(err.Error())); return }
+	if err != nil { writeErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"deleted": true}, nil)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"microapi/internal/auth"
+	"microapi/internal/middleware"
+	"microapi/internal/models"
+	"microapi/internal/query"
+	"microapi/internal/rules"
+)
+
+// PutRules stores the document-level access rules of a collection (see rules.Rules).
+func (h *Handlers) PutRules(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	var rs rules.Rules
+	if err := json.NewDecoder(r.Body).Decode(&rs); err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("invalid JSON body: expected {\"read\": \"...\", \"create\": \"...\", \"update\": \"...\", \"delete\": \"...\"}")); return }
+	if err := rules.Put(h.db, set, collection, rs); err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error())); return }
+	middleware.WriteJSON(w, http.StatusOK, true, rs, nil)
+}
+
+func (h *Handlers) GetRules(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	rs, err := rules.Get(h.db, set, collection)
+	if err != nil { writeRulesErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, rs, nil)
+}
+
+func (h *Handlers) DeleteRules(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	if err := rules.Remove(h.db, set, collection); err != nil { writeRulesErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"deleted": true}, nil)
+}
+
+// TestRules evaluates a rule against a sample request without touching any document:
+//
+//	{"op": "update", "rules": {...}, "auth": {"claims": {"sub": "u1"}}, "resource": {...}, "incoming": {...}}
+//
+// rules defaults to the stored rules and auth to the caller's own identity.
+func (h *Handlers) TestRules(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	var body struct {
+		Op       string          `json:"op"`
+		Rules    *rules.Rules    `json:"rules"`
+		Auth     *rules.Identity `json:"auth"`
+		Resource map[string]any  `json:"resource"`
+		Incoming map[string]any  `json:"incoming"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("invalid JSON body: expected {\"op\": \"...\", \"resource\": {...}, \"incoming\": {...}}")); return }
+	rs := body.Rules
+	if rs == nil {
+		stored, err := rules.Get(h.db, set, collection)
+		if err != nil { writeRulesErr(w, err); return }
+		rs = stored
+	} else if err := rs.Validate(); err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error())); return }
+	id := rules.IdentityOf(auth.FromContext(r.Context()), set, collection)
+	if body.Auth != nil { id = *body.Auth }
+	expr := map[string]string{rules.Read: rs.Read, rules.Create: rs.Create, rules.Update: rs.Update, rules.Delete: rs.Delete}[body.Op]
+	allowed, err := rules.Test(h.db, body.Op, expr, id, body.Resource, body.Incoming)
+	if err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error())); return }
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"allowed": allowed, "op": body.Op, "rule": expr, "auth": id}, nil)
+}
+
+func writeRulesErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, rules.ErrNotFound) { middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("no rules for this collection")); return }
+	writeErr(w, err)
+}
+
+// ruleFilter returns " AND ..." and its args, limiting a statement on a set table to the
+// documents the collection's read or delete rule lets the caller of r see or remove.
+func ruleFilter(r *http.Request, op string) (string, []any, error) {
+	pw, err := rules.FromContext(r.Context()).Filter(op)
+	if err != nil || pw == nil { return "", nil, err }
+	var s string
+	var args []any
+	for _, c := range pw.Conds { s += " AND " + c.SQL; args = append(args, c.Args...) }
+	return s, args, nil
+}
+
+// whereWithRules narrows a parsed client filter by the collection's read rule.
+func whereWithRules(r *http.Request, pw *query.ParsedWhere) (*query.ParsedWhere, error) {
+	f, err := rules.FromContext(r.Context()).Filter(rules.Read)
+	if err != nil { return nil, err }
+	return pw.And(f), nil
+}
+
+// checkRule evaluates the collection's create, update or delete rule for the caller of r.
+func checkRule(q rules.Querier, r *http.Request, op string, resource, incoming map[string]any) error {
+	return rules.FromContext(r.Context()).Check(q, op, resource, incoming)
+}
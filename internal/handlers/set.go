@@ -5,6 +5,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 
+	"microapi/internal/auth"
 	"microapi/internal/database"
 	"microapi/internal/middleware"
 	"microapi/internal/models"
@@ -12,6 +13,8 @@ import (
 
 func (h *Handlers) ListSets(w http.ResponseWriter, r *http.Request) {
     // Get number of collections per set from metadata
+    // Only sets the caller can read are listed
+    p := auth.FromContext(r.Context())
     rows, err := h.db.Query(`SELECT set_name, COUNT(*) AS colls FROM metadata GROUP BY set_name ORDER BY set_name`)
     if err != nil {
         middleware.WriteJSON(w, http.StatusInternalServerError, false, nil, models.Ptr(err.Error()))
@@ -26,6 +29,7 @@ func (h *Handlers) ListSets(w http.ResponseWriter, r *http.Request) {
         var set string
         var colls int64
         if err := rows.Scan(&set, &colls); err != nil { continue }
+        if !p.Can(set, "", auth.Read) { continue }
 
         // Count documents in the physical set table; if table is missing, treat as 0
         var docs int64
@@ -86,12 +90,27 @@ func (h *Handlers) DeleteSet(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, err)
 		return
 	}
+	if err := database.DropSetFullText(h.db, set); err != nil {
+		writeErr(w, err)
+		return
+	}
+	if err := database.DropSetTableIndexes(h.db, set); err != nil {
+		writeErr(w, err)
+		return
+	}
 	_, err := h.db.Exec("DROP TABLE IF EXISTS " + tableName(set))
 	if err != nil {
 		writeErr(w, err)
 		return
 	}
 	_, _ = h.db.Exec(`DELETE FROM metadata WHERE set_name = ?`, set)
+	_, _ = h.db.Exec(`DELETE FROM changelog WHERE set_name = ?`, set)
+	_, _ = h.db.Exec(`DELETE FROM webhook_deliveries WHERE hook_id IN (SELECT id FROM webhooks WHERE set_name = ?)`, set)
+	_, _ = h.db.Exec(`DELETE FROM webhooks WHERE set_name = ?`, set)
+	_, _ = h.db.Exec(`DELETE FROM claim_rules WHERE set_name = ?`, set)
+	_, _ = h.db.Exec(`DELETE FROM rules WHERE set_name = ?`, set)
+	_, _ = h.db.Exec(`DELETE FROM quotas WHERE set_name = ?`, set)
+	_, _ = h.db.Exec(`DELETE FROM set_usage WHERE set_name = ?`, set)
 	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"deleted": set}, nil)
 }
 
@@ -104,6 +123,12 @@ func writeErr(w http.ResponseWriter, err error) {
 	if ok := asHTTPError(err, &he); ok {
 		code = he.Code
 		s = he.Message
+	} else if database.IsQuotaExceeded(err) {
+		code = http.StatusInsufficientStorage
+		s = "set quota exceeded"
+	} else if database.IsSearchSyntax(err) {
+		code = http.StatusBadRequest
+		s = "invalid search: " + s
 	}
 	middleware.WriteJSON(w, code, false, nil, models.Ptr(s))
 }
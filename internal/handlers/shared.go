@@ -56,13 +56,15 @@ func sanitizeForPutPatch(body map[string]any, id string) (map[string]any, *middl
 
 func suppressMeta(r *http.Request) bool { return r.URL.Query().Get("meta") == "0" }
 
-func writeDocResponse(w http.ResponseWriter, r *http.Request, status int, data map[string]any, id string, created, updated int64) {
+func writeDocResponse(w http.ResponseWriter, r *http.Request, status int, data map[string]any, id string, created, updated, version int64) {
+	w.Header().Set("ETag", etag(version))
 	if !suppressMeta(r) {
 		if data == nil { data = map[string]any{} }
 		data["_meta"] = map[string]any{
 			"id":         id,
 			"created_at": created,
 			"updated_at": updated,
+			"version":    version,
 		}
 	}
 	middleware.WriteJSON(w, status, true, data, nil)
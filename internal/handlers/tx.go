@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"microapi/internal/auth"
+	"microapi/internal/middleware"
+	"microapi/internal/models"
+	"microapi/internal/txn"
+)
+
+// Transaction runs {"operations": [...]} as one atomic batch (see txn.Operation).
+// On failure nothing is stored and the response carries the index of the failing operation.
+func (h *Handlers) Transaction(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Operations []txn.Operation `json:"operations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("invalid JSON body: expected {\"operations\": [...]}")); return }
+	if err := authorizeOps(r.Context(), body.Operations); err != nil { writeTxErr(w, err); return }
+	results, err := txn.Execute(h.db, body.Operations, auth.FromContext(r.Context()))
+	if err != nil { writeTxErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"results": results}, nil)
+}
+
+// authorizeOps requires write access to the collection of every operation.
+func authorizeOps(ctx context.Context, ops []txn.Operation) error {
+	p := auth.FromContext(ctx)
+	for i, op := range ops {
+		if !p.Can(op.Set, op.Collection, auth.Write) {
+			return &txn.Error{Index: i, Code: http.StatusForbidden, Message: "access denied"}
+		}
+	}
+	return nil
+}
+
+// writeTxErr reports a failed transaction, including the failing operation index when known.
+func writeTxErr(w http.ResponseWriter, err error) {
+	var te *txn.Error
+	if !errors.As(err, &te) { writeErr(w, err); return }
+	var data any
+	if te.Index >= 0 { data = map[string]any{"index": te.Index} }
+	middleware.WriteJSON(w, te.Code, false, data, models.Ptr(te.Message))
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"microapi/internal/middleware"
+	"microapi/internal/models"
+	"microapi/internal/webhooks"
+)
+
+type createHookReq struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// CreateHook registers a webhook for a collection. The response is the only place the
+// signing secret is returned.
+func (h *Handlers) CreateHook(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	var body createHookReq
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("invalid JSON body")); return }
+	hook, err := webhooks.Create(h.db, set, collection, body.URL, body.Secret, body.Events)
+	if err != nil { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr(err.Error())); return }
+	middleware.WriteJSON(w, http.StatusCreated, true, hook, nil)
+}
+
+func (h *Handlers) ListHooks(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	hooks, err := webhooks.List(h.db, set, collection)
+	if err != nil { writeErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, hooks, nil)
+}
+
+func (h *Handlers) GetHook(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	hook, err := webhooks.Get(h.db, set, collection, chi.URLParam(r, "hook"))
+	if err != nil { writeHookErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, hook, nil)
+}
+
+func (h *Handlers) DeleteHook(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	id := chi.URLParam(r, "hook")
+	if err := webhooks.Delete(h.db, set, collection, id); err != nil { writeHookErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"deleted": id}, nil)
+}
+
+// ListHookDeliveries returns the delivery log of a hook, newest first.
+// ?status=pending|delivered|dead filters it (dead is the dead-letter list); ?limit defaults to 50.
+func (h *Handlers) ListHookDeliveries(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	hook, err := webhooks.Get(h.db, set, collection, chi.URLParam(r, "hook"))
+	if err != nil { writeHookErr(w, err); return }
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", "pending", "delivered", "dead":
+	default:
+		middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("status must be pending, delivered or dead"))
+		return
+	}
+	limit := parseInt(r.URL.Query().Get("limit"), 50)
+	if limit <= 0 || limit > 1000 { middleware.WriteJSON(w, http.StatusBadRequest, false, nil, models.Ptr("limit must be between 1 and 1000")); return }
+	out, err := webhooks.Deliveries(h.db, hook.ID, status, limit)
+	if err != nil { writeErr(w, err); return }
+	middleware.WriteJSON(w, http.StatusOK, true, out, nil)
+}
+
+// RetryHookDelivery requeues a dead-lettered delivery.
+func (h *Handlers) RetryHookDelivery(w http.ResponseWriter, r *http.Request) {
+	set := chi.URLParam(r, "set")
+	collection := chi.URLParam(r, "collection")
+	if err := middleware.ValidateNames(set, collection); err != nil { writeErr(w, err); return }
+	hook, err := webhooks.Get(h.db, set, collection, chi.URLParam(r, "hook"))
+	if err != nil { writeHookErr(w, err); return }
+	id, err := strconv.ParseInt(chi.URLParam(r, "delivery"), 10, 64)
+	if err != nil { middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("not found")); return }
+	if err := webhooks.Retry(h.db, hook.ID, id); err != nil {
+		if errors.Is(err, webhooks.ErrNotFound) { middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("no dead delivery with that id")); return }
+		writeErr(w, err)
+		return
+	}
+	middleware.WriteJSON(w, http.StatusOK, true, map[string]any{"requeued": id}, nil)
+}
+
+func writeHookErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, webhooks.ErrNotFound) { middleware.WriteJSON(w, http.StatusNotFound, false, nil, models.Ptr("not found")); return }
+	writeErr(w, err)
+}
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"microapi/internal/models"
+)
+
+// Rate allows Limit requests per Period, in bursts of up to Limit.
+type Rate struct {
+	Limit  int
+	Period time.Duration
+}
+
+// ParseRate reads "<n>/<s|m|h>", e.g. "300/m". An empty string or a zero count is the
+// zero Rate, which disables limiting.
+func ParseRate(s string) (Rate, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Rate{}, nil
+	}
+	n, unit, ok := strings.Cut(s, "/")
+	limit, err := strconv.Atoi(n)
+	if !ok || err != nil || limit < 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q: expected <count>/<s|m|h>", s)
+	}
+	periods := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+	period, ok := periods[unit]
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid rate %q: period must be s, m or h", s)
+	}
+	if limit == 0 {
+		return Rate{}, nil
+	}
+	return Rate{Limit: limit, Period: period}, nil
+}
+
+// bucket holds the tokens left to a client and when they were last topped up.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a token bucket per client. Buckets refill continuously at Limit per
+// Period, so a client that spent its burst gets a request back every Period/Limit.
+type RateLimiter struct {
+	rate      Rate
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter returns nil for the zero Rate; a nil limiter lets everything through.
+func NewRateLimiter(rate Rate) *RateLimiter {
+	if rate.Limit <= 0 {
+		return nil
+	}
+	return &RateLimiter{rate: rate, buckets: map[string]*bucket{}, lastSweep: time.Now()}
+}
+
+// take spends a token of client and reports whether one was available, the tokens left,
+// and how long until the bucket is full again (or, when denied, until the next token).
+func (l *RateLimiter) take(client string, now time.Time) (bool, int, time.Duration) {
+	perToken := l.perToken()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.refill(client, now)
+	if b.tokens < 1 {
+		return false, 0, time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	b.tokens--
+	return true, int(b.tokens), time.Duration((float64(l.rate.Limit) - b.tokens) * float64(perToken))
+}
+
+// peek reports whether client has a token left without spending it, and when it has
+// none, how long until the next one.
+func (l *RateLimiter) peek(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.refill(client, now)
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(l.perToken()))
+	}
+	return true, 0
+}
+
+// refill tops up the bucket of client for the time since it was last used. l.mu must be
+// held.
+func (l *RateLimiter) refill(client string, now time.Time) *bucket {
+	l.sweep(now)
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(l.rate.Limit), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(l.rate.Limit), b.tokens+float64(now.Sub(b.last))/float64(l.perToken()))
+	b.last = now
+	return b
+}
+
+func (l *RateLimiter) perToken() time.Duration {
+	return l.rate.Period / time.Duration(l.rate.Limit)
+}
+
+// sweep drops buckets that have refilled completely, which are the same as new ones.
+// It runs at most once per Period.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.rate.Period {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= l.rate.Period {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// Limit throttles requests per client, as named by clientKey. Every response carries
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset (seconds until the bucket is
+// full); rejected requests get 429 with Retry-After.
+func (l *RateLimiter) Limit(clientKey func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+		policy := fmt.Sprintf("%d;w=%d", l.rate.Limit, int(l.rate.Period.Seconds()))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, remaining, wait := l.take(clientKey(r), time.Now())
+			secs := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+			h := w.Header()
+			h.Set("RateLimit-Policy", policy)
+			h.Set("RateLimit-Limit", strconv.Itoa(l.rate.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+			h.Set("RateLimit-Reset", secs)
+			if !ok {
+				h.Set("Retry-After", secs)
+				WriteJSON(w, http.StatusTooManyRequests, false, nil, models.Ptr("rate limit exceeded, retry in "+secs+"s"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// LimitFailures throttles clients whose requests keep getting status, such as 401 for bad
+// credentials. Only those responses spend tokens, so well-behaved clients are never
+// limited. A client that has spent its bucket gets 429 with Retry-After for every request
+// until a token is back, without reaching next.
+func (l *RateLimiter) LimitFailures(clientKey func(*http.Request) string, status int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := clientKey(r)
+			if ok, wait := l.peek(client, time.Now()); !ok {
+				secs := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+				w.Header().Set("Retry-After", secs)
+				WriteJSON(w, http.StatusTooManyRequests, false, nil, models.Ptr("too many failed requests, retry in "+secs+"s"))
+				return
+			}
+			sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sr, r)
+			if sr.status == status {
+				l.take(client, time.Now())
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimitFailures(t *testing.T) {
+	calls := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("X-API-Key") != "good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	clientKey := func(r *http.Request) string { return r.RemoteAddr }
+	h := NewRateLimiter(Rate{Limit: 2, Period: time.Minute}).LimitFailures(clientKey, http.StatusUnauthorized)(next)
+	do := func(addr, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/s/c", nil)
+		r.RemoteAddr = addr
+		r.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// successes spend nothing
+	for i := 0; i < 5; i++ {
+		if w := do("1.1.1.1", "good"); w.Code != http.StatusOK {
+			t.Fatalf("good key %d: %d", i, w.Code)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if w := do("1.1.1.1", "bad"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("bad key %d: %d, want 401", i, w.Code)
+		}
+	}
+	// out of tokens: refused before next, even with a good key
+	calls = 0
+	for _, key := range []string{"bad", "good"} {
+		w := do("1.1.1.1", key)
+		if w.Code != http.StatusTooManyRequests {
+			t.Errorf("%s key after failures: %d, want 429", key, w.Code)
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s key after failures: no Retry-After", key)
+		}
+	}
+	if calls != 0 {
+		t.Errorf("next ran %d times for a limited client", calls)
+	}
+	// other addresses are not affected
+	if w := do("2.2.2.2", "bad"); w.Code != http.StatusUnauthorized {
+		t.Errorf("other address: %d, want 401", w.Code)
+	}
+}
+
+func TestLimitFailuresRefills(t *testing.T) {
+	l := NewRateLimiter(Rate{Limit: 2, Period: time.Minute})
+	now := time.Now()
+	l.take("a", now)
+	l.take("a", now)
+	if ok, wait := l.peek("a", now); ok || wait <= 0 || wait > 30*time.Second {
+		t.Errorf("peek after two failures = %v, %v; want false and up to 30s", ok, wait)
+	}
+	if ok, _ := l.peek("a", now.Add(30*time.Second)); !ok {
+		t.Error("peek after 30s = false, want a token back")
+	}
+	// peeking spends nothing
+	if ok, _ := l.peek("a", now.Add(30*time.Second)); !ok {
+		t.Error("second peek = false")
+	}
+}
+
+func TestLimitFailuresDisabled(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusUnauthorized) })
+	h := NewRateLimiter(Rate{}).LimitFailures(func(*http.Request) string { return "a" }, http.StatusUnauthorized)(next)
+	for i := 0; i < 50; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("request %d: %d, want 401", i, w.Code)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrTestFailed is returned (wrapped) when a "test" operation does not match.
+var ErrTestFailed = errors.New("test failed")
+
+// Operation is a single RFC 6902 JSON Patch operation.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ApplyJSONPatch applies RFC 6902 operations in order to doc and returns the result.
+// Operations are applied to a copy; doc itself is left untouched on error.
+func ApplyJSONPatch(doc any, ops []Operation) (any, error) {
+	doc = deepCopy(doc)
+	for i, op := range ops {
+		var err error
+		doc, err = applyOp(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("patch operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+func applyOp(doc any, op Operation) (any, error) {
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("value is required")
+		}
+		var v any
+		if err := json.Unmarshal(op.Value, &v); err != nil {
+			return nil, fmt.Errorf("invalid value")
+		}
+		switch op.Op {
+		case "add":
+			return add(doc, op.Path, v)
+		case "replace":
+			if _, err := get(doc, op.Path); err != nil {
+				return nil, err
+			}
+			if op.Path == "" {
+				return v, nil
+			}
+			doc, err := remove(doc, op.Path)
+			if err != nil {
+				return nil, err
+			}
+			return add(doc, op.Path, v)
+		default:
+			cur, err := get(doc, op.Path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(cur, v) {
+				return nil, ErrTestFailed
+			}
+			return doc, nil
+		}
+	case "remove":
+		return remove(doc, op.Path)
+	case "move":
+		if op.Path == op.From || strings.HasPrefix(op.Path, op.From+"/") {
+			if op.Path == op.From {
+				return doc, nil
+			}
+			return nil, fmt.Errorf("cannot move a value into one of its children")
+		}
+		v, err := get(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = remove(doc, op.From); err != nil {
+			return nil, err
+		}
+		return add(doc, op.Path, v)
+	case "copy":
+		v, err := get(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.Path, deepCopy(v))
+	}
+	return nil, fmt.Errorf("unsupported op")
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped reference tokens.
+func parsePointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", ptr)
+	}
+	parts := strings.Split(ptr[1:], "/")
+	for i, p := range parts {
+		parts[i] = strings.ReplaceAll(strings.ReplaceAll(p, "~1", "/"), "~0", "~")
+	}
+	return parts, nil
+}
+
+// arrayIndex parses an array reference token; "-" (end of array) is only valid when allowEnd.
+func arrayIndex(tok string, n int, allowEnd bool) (int, error) {
+	if tok == "-" && allowEnd {
+		return n, nil
+	}
+	if tok == "" || (len(tok) > 1 && tok[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", tok)
+	}
+	i, err := strconv.Atoi(tok)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid array index %q", tok)
+	}
+	max := n - 1
+	if allowEnd {
+		max = n
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+func get(doc any, ptr string) (any, error) {
+	toks, err := parsePointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+	cur := doc
+	for _, tok := range toks {
+		switch c := cur.(type) {
+		case map[string]any:
+			v, ok := c[tok]
+			if !ok {
+				return nil, fmt.Errorf("path not found")
+			}
+			cur = v
+		case []any:
+			i, err := arrayIndex(tok, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			cur = c[i]
+		default:
+			return nil, fmt.Errorf("path not found")
+		}
+	}
+	return cur, nil
+}
+
+// update walks to the parent of ptr and lets fn replace the parent container.
+func update(doc any, toks []string, fn func(parent any, tok string) (any, error)) (any, error) {
+	if len(toks) == 1 {
+		return fn(doc, toks[0])
+	}
+	switch c := doc.(type) {
+	case map[string]any:
+		child, ok := c[toks[0]]
+		if !ok {
+			return nil, fmt.Errorf("path not found")
+		}
+		nc, err := update(child, toks[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		c[toks[0]] = nc
+		return c, nil
+	case []any:
+		i, err := arrayIndex(toks[0], len(c), false)
+		if err != nil {
+			return nil, err
+		}
+		nc, err := update(c[i], toks[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		c[i] = nc
+		return c, nil
+	}
+	return nil, fmt.Errorf("path not found")
+}
+
+func add(doc any, ptr string, v any) (any, error) {
+	toks, err := parsePointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return v, nil
+	}
+	return update(doc, toks, func(parent any, tok string) (any, error) {
+		switch c := parent.(type) {
+		case map[string]any:
+			c[tok] = v
+			return c, nil
+		case []any:
+			i, err := arrayIndex(tok, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = v
+			return c, nil
+		}
+		return nil, fmt.Errorf("path not found")
+	})
+}
+
+func remove(doc any, ptr string) (any, error) {
+	toks, err := parsePointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("cannot remove the whole document")
+	}
+	return update(doc, toks, func(parent any, tok string) (any, error) {
+		switch c := parent.(type) {
+		case map[string]any:
+			if _, ok := c[tok]; !ok {
+				return nil, fmt.Errorf("path not found")
+			}
+			delete(c, tok)
+			return c, nil
+		case []any:
+			i, err := arrayIndex(tok, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("path not found")
+	})
+}
+
+func deepCopy(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(t))
+		for k, x := range t {
+			m[k] = deepCopy(x)
+		}
+		return m
+	case []any:
+		s := make([]any, len(t))
+		for i, x := range t {
+			s[i] = deepCopy(x)
+		}
+		return s
+	}
+	return v
+}
@@ -0,0 +1,241 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("%s: %v", s, err)
+	}
+	return v
+}
+
+func decodeOps(t *testing.T, s string) []Operation {
+	t.Helper()
+	var ops []Operation
+	if err := json.Unmarshal([]byte(s), &ops); err != nil {
+		t.Fatalf("%s: %v", s, err)
+	}
+	return ops
+}
+
+// TestApplyJSONPatchRFC runs the examples of RFC 6902 appendix A.
+func TestApplyJSONPatchRFC(t *testing.T) {
+	tests := []struct {
+		name, doc, patch, want string
+	}{
+		{"A.1 add an object member", `{"foo": "bar"}`,
+			`[{"op": "add", "path": "/baz", "value": "qux"}]`,
+			`{"baz": "qux", "foo": "bar"}`},
+		{"A.2 add an array element", `{"foo": ["bar", "baz"]}`,
+			`[{"op": "add", "path": "/foo/1", "value": "qux"}]`,
+			`{"foo": ["bar", "qux", "baz"]}`},
+		{"A.3 remove an object member", `{"baz": "qux", "foo": "bar"}`,
+			`[{"op": "remove", "path": "/baz"}]`,
+			`{"foo": "bar"}`},
+		{"A.4 remove an array element", `{"foo": ["bar", "qux", "baz"]}`,
+			`[{"op": "remove", "path": "/foo/1"}]`,
+			`{"foo": ["bar", "baz"]}`},
+		{"A.5 replace a value", `{"baz": "qux", "foo": "bar"}`,
+			`[{"op": "replace", "path": "/baz", "value": "boo"}]`,
+			`{"baz": "boo", "foo": "bar"}`},
+		{"A.6 move a value", `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			`[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			`{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`},
+		{"A.7 move an array element", `{"foo": ["all", "grass", "cows", "eat"]}`,
+			`[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`,
+			`{"foo": ["all", "cows", "eat", "grass"]}`},
+		{"A.8 test a value", `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			`[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`,
+			`{"baz": "qux", "foo": ["a", 2, "c"]}`},
+		{"A.10 add a nested member object", `{"foo": "bar"}`,
+			`[{"op": "add", "path": "/child", "value": {"grandchild": {}}}]`,
+			`{"foo": "bar", "child": {"grandchild": {}}}`},
+		{"A.11 ignore unrecognized elements", `{"foo": "bar"}`,
+			`[{"op": "add", "path": "/baz", "value": "qux", "xyz": 123}]`,
+			`{"foo": "bar", "baz": "qux"}`},
+		{"A.14 ~ escape ordering", `{"/": 9, "~1": 10}`,
+			`[{"op": "test", "path": "/~01", "value": 10}]`,
+			`{"/": 9, "~1": 10}`},
+		{"A.16 add an array value", `{"foo": ["bar"]}`,
+			`[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`,
+			`{"foo": ["bar", ["abc", "def"]]}`},
+	}
+	for _, tt := range tests {
+		got, err := ApplyJSONPatch(decode(t, tt.doc), decodeOps(t, tt.patch))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+// TestApplyJSONPatchRFCErrors runs the examples of RFC 6902 appendix A that must fail.
+func TestApplyJSONPatchRFCErrors(t *testing.T) {
+	tests := []struct {
+		name, doc, patch string
+		testFailed       bool
+	}{
+		{"A.9 test a value (error)", `{"baz": "qux"}`,
+			`[{"op": "test", "path": "/baz", "value": "bar"}]`, true},
+		{"A.12 add to a nonexistent target", `{"foo": "bar"}`,
+			`[{"op": "add", "path": "/baz/bat", "value": "qux"}]`, false},
+		{"A.15 comparing strings and numbers", `{"/": 9, "~1": 10}`,
+			`[{"op": "test", "path": "/~01", "value": "10"}]`, true},
+	}
+	for _, tt := range tests {
+		_, err := ApplyJSONPatch(decode(t, tt.doc), decodeOps(t, tt.patch))
+		if err == nil {
+			t.Errorf("%s: want an error", tt.name)
+			continue
+		}
+		if errors.Is(err, ErrTestFailed) != tt.testFailed {
+			t.Errorf("%s: errors.Is(%v, ErrTestFailed) = %v", tt.name, err, !tt.testFailed)
+		}
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	tests := []struct {
+		name, doc, patch, want string
+	}{
+		{"add replaces an existing member", `{"a": 1}`,
+			`[{"op": "add", "path": "/a", "value": 2}]`, `{"a": 2}`},
+		{"add null", `{}`,
+			`[{"op": "add", "path": "/a", "value": null}]`, `{"a": null}`},
+		{"add at the end with -", `{"a": [1, 2]}`,
+			`[{"op": "add", "path": "/a/-", "value": 3}]`, `{"a": [1, 2, 3]}`},
+		{"add at the length", `{"a": [1, 2]}`,
+			`[{"op": "add", "path": "/a/2", "value": 3}]`, `{"a": [1, 2, 3]}`},
+		{"add at the front", `{"a": [1, 2]}`,
+			`[{"op": "add", "path": "/a/0", "value": 0}]`, `{"a": [0, 1, 2]}`},
+		{"add to the root replaces the document", `{"a": 1}`,
+			`[{"op": "add", "path": "", "value": {"b": 2}}]`, `{"b": 2}`},
+		{"replace the root", `{"a": 1}`,
+			`[{"op": "replace", "path": "", "value": [1]}]`, `[1]`},
+		{"replace an array element", `{"a": [1, 2, 3]}`,
+			`[{"op": "replace", "path": "/a/1", "value": 9}]`, `{"a": [1, 9, 3]}`},
+		{"~1 in a key", `{"a/b": 1}`,
+			`[{"op": "replace", "path": "/a~1b", "value": 2}]`, `{"a/b": 2}`},
+		{"~0 in a key", `{"m~n": 1}`,
+			`[{"op": "remove", "path": "/m~0n"}]`, `{}`},
+		{"empty key", `{"": 1}`,
+			`[{"op": "replace", "path": "/", "value": 2}]`, `{"": 2}`},
+		{"nested arrays", `{"a": [[1, 2], [3]]}`,
+			`[{"op": "remove", "path": "/a/0/1"}, {"op": "add", "path": "/a/1/-", "value": 4}]`,
+			`{"a": [[1], [3, 4]]}`},
+		{"move to the same path", `{"a": 1}`,
+			`[{"op": "move", "from": "/a", "path": "/a"}]`, `{"a": 1}`},
+		{"move to a sibling with a common prefix", `{"a": 1}`,
+			`[{"op": "move", "from": "/a", "path": "/ab"}]`, `{"ab": 1}`},
+		{"move to the end of an array", `{"a": [1, 2, 3]}`,
+			`[{"op": "move", "from": "/a/0", "path": "/a/-"}]`, `{"a": [2, 3, 1]}`},
+		{"copy into an array", `{"a": {"x": 1}, "b": []}`,
+			`[{"op": "copy", "from": "/a", "path": "/b/-"}]`,
+			`{"a": {"x": 1}, "b": [{"x": 1}]}`},
+		{"copy is deep", `{"a": {"x": 1}}`,
+			`[{"op": "copy", "from": "/a", "path": "/b"}, {"op": "replace", "path": "/b/x", "value": 2}]`,
+			`{"a": {"x": 1}, "b": {"x": 2}}`},
+		{"copy into a child of the source", `{"a": {"x": 1}}`,
+			`[{"op": "copy", "from": "/a", "path": "/a/y"}]`,
+			`{"a": {"x": 1, "y": {"x": 1}}}`},
+		{"test a whole object", `{"a": {"x": [1, {"y": null}]}}`,
+			`[{"op": "test", "path": "/a", "value": {"x": [1, {"y": null}]}}]`,
+			`{"a": {"x": [1, {"y": null}]}}`},
+		{"test numbers by value", `{"a": 1}`,
+			`[{"op": "test", "path": "/a", "value": 1.0}]`, `{"a": 1}`},
+		{"operations apply in order", `{}`,
+			`[{"op": "add", "path": "/a", "value": []}, {"op": "add", "path": "/a/-", "value": 1}, {"op": "test", "path": "/a/0", "value": 1}]`,
+			`{"a": [1]}`},
+	}
+	for _, tt := range tests {
+		got, err := ApplyJSONPatch(decode(t, tt.doc), decodeOps(t, tt.patch))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestApplyJSONPatchErrors(t *testing.T) {
+	tests := []struct {
+		name, doc, patch string
+	}{
+		{"unknown op", `{}`, `[{"op": "merge", "path": "/a", "value": 1}]`},
+		{"add without a value", `{}`, `[{"op": "add", "path": "/a"}]`},
+		{"pointer without a leading /", `{"a": 1}`, `[{"op": "remove", "path": "a"}]`},
+		{"remove the root", `{"a": 1}`, `[{"op": "remove", "path": ""}]`},
+		{"remove a missing member", `{"a": 1}`, `[{"op": "remove", "path": "/b"}]`},
+		{"replace a missing member", `{"a": 1}`, `[{"op": "replace", "path": "/b", "value": 2}]`},
+		{"test a missing member", `{"a": 1}`, `[{"op": "test", "path": "/b", "value": 1}]`},
+		{"add past the end", `{"a": [1]}`, `[{"op": "add", "path": "/a/2", "value": 2}]`},
+		{"remove -", `{"a": [1]}`, `[{"op": "remove", "path": "/a/-"}]`},
+		{"replace -", `{"a": [1]}`, `[{"op": "replace", "path": "/a/-", "value": 2}]`},
+		{"index with a leading zero", `{"a": [1, 2]}`, `[{"op": "remove", "path": "/a/01"}]`},
+		{"negative index", `{"a": [1, 2]}`, `[{"op": "remove", "path": "/a/-1"}]`},
+		{"index out of range", `{"a": [1, 2]}`, `[{"op": "remove", "path": "/a/2"}]`},
+		{"non-numeric index", `{"a": [1, 2]}`, `[{"op": "remove", "path": "/a/x"}]`},
+		{"index into a scalar", `{"a": 1}`, `[{"op": "add", "path": "/a/b", "value": 2}]`},
+		{"move into a child", `{"a": {"b": 1}}`, `[{"op": "move", "from": "/a", "path": "/a/b/c"}]`},
+		{"move from a missing member", `{"a": 1}`, `[{"op": "move", "from": "/b", "path": "/c"}]`},
+		{"copy from a missing member", `{"a": 1}`, `[{"op": "copy", "from": "/b", "path": "/c"}]`},
+		{"a later operation fails", `{"a": 1}`, `[{"op": "add", "path": "/b", "value": 2}, {"op": "remove", "path": "/c"}]`},
+	}
+	for _, tt := range tests {
+		if got, err := ApplyJSONPatch(decode(t, tt.doc), decodeOps(t, tt.patch)); err == nil {
+			t.Errorf("%s: got %v, want an error", tt.name, got)
+		}
+	}
+}
+
+func TestApplyJSONPatchLeavesDocument(t *testing.T) {
+	doc := decode(t, `{"a": {"b": [1, 2]}, "c": 1}`)
+	ops := decodeOps(t, `[{"op": "remove", "path": "/a/b/0"}, {"op": "add", "path": "/a/d", "value": 3}, {"op": "remove", "path": "/c"}]`)
+	if _, err := ApplyJSONPatch(doc, ops); err != nil {
+		t.Fatal(err)
+	}
+	failing := append(ops, Operation{Op: "remove", Path: "/missing"})
+	if _, err := ApplyJSONPatch(doc, failing); err == nil {
+		t.Fatal("want an error")
+	}
+	if want := decode(t, `{"a": {"b": [1, 2]}, "c": 1}`); !reflect.DeepEqual(doc, want) {
+		t.Errorf("doc = %v, want %v", doc, want)
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		ptr  string
+		want []string
+	}{
+		{"", nil},
+		{"/", []string{""}},
+		{"/foo/0", []string{"foo", "0"}},
+		{"/a~1b/m~0n", []string{"a/b", "m~n"}},
+		// ~01 is ~1, not /
+		{"/~01", []string{"~1"}},
+		{"/~10", []string{"/0"}},
+	}
+	for _, tt := range tests {
+		got, err := parsePointer(tt.ptr)
+		if err != nil {
+			t.Errorf("parsePointer(%q): %v", tt.ptr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePointer(%q) = %q, want %q", tt.ptr, got, tt.want)
+		}
+	}
+}
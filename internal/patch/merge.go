@@ -0,0 +1,23 @@
+package patch
+
+// MergePatch applies an RFC 7386 JSON Merge Patch to target and returns the result.
+// Objects are merged recursively, null deletes a key and any other value replaces it.
+// target is modified in place when it is an object.
+func MergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = MergePatch(t[k], v)
+	}
+	return t
+}
@@ -0,0 +1,160 @@
+package query
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Aggregate is one aggregate function of an aggregation request.
+type Aggregate struct {
+	// Func is one of count, sum, avg, min, max, count_distinct
+	Func string
+	// Path is the JSONPath the function applies to; empty only for a bare count
+	Path string
+}
+
+var aggregateFuncs = map[string]string{
+	"count":          "COUNT",
+	"sum":            "SUM",
+	"avg":            "AVG",
+	"min":            "MIN",
+	"max":            "MAX",
+	"count_distinct": "COUNT",
+}
+
+// Name returns the key the aggregate is reported under, e.g. "sum_price" or "count".
+func (a Aggregate) Name() string {
+	if a.Path == "" {
+		return a.Func
+	}
+	return a.Func + "_" + strings.ReplaceAll(strings.TrimPrefix(a.Path, "$."), ".", "_")
+}
+
+func (a Aggregate) expr() string {
+	if a.Path == "" {
+		return "COUNT(*)"
+	}
+	e := fmt.Sprintf("json_extract(data, '%s')", a.Path)
+	if a.Func == "count_distinct" {
+		return "COUNT(DISTINCT " + e + ")"
+	}
+	return aggregateFuncs[a.Func] + "(" + e + ")"
+}
+
+// ParseAggregates parses a comma-separated aggregate spec such as "count,sum:price,avg:user.age".
+// An empty spec defaults to a bare count.
+func ParseAggregates(spec string) ([]Aggregate, error) {
+	if strings.TrimSpace(spec) == "" {
+		return []Aggregate{{Func: "count"}}, nil
+	}
+	var aggs []Aggregate
+	seen := map[string]struct{}{}
+	for _, raw := range strings.Split(spec, ",") {
+		term := strings.TrimSpace(raw)
+		fn, path, hasPath := strings.Cut(term, ":")
+		if _, ok := aggregateFuncs[fn]; !ok {
+			return nil, fmt.Errorf("unsupported aggregate: %q", term)
+		}
+		a := Aggregate{Func: fn}
+		if hasPath {
+			p, ok := fieldPath(strings.TrimSpace(path))
+			if !ok {
+				return nil, fmt.Errorf("malformed aggregate: invalid field path %q", path)
+			}
+			a.Path = p
+		} else if fn != "count" {
+			return nil, fmt.Errorf("aggregate %s requires a path (e.g. %s:price)", fn, fn)
+		}
+		if _, dup := seen[a.Name()]; dup {
+			return nil, fmt.Errorf("duplicate aggregate: %q", term)
+		}
+		seen[a.Name()] = struct{}{}
+		aggs = append(aggs, a)
+	}
+	return aggs, nil
+}
+
+// ParseGroupBy parses a comma-separated list of JSON paths to group by.
+func ParseGroupBy(spec string) ([]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	var paths []string
+	for _, raw := range strings.Split(spec, ",") {
+		p, ok := fieldPath(strings.TrimSpace(raw))
+		if !ok {
+			return nil, fmt.Errorf("malformed group_by: invalid field path %q", raw)
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+type AggregateOpts struct {
+	Set        string
+	Collection string
+	Where      *ParsedWhere
+	GroupBy    []string
+	Aggregates []Aggregate
+}
+
+// BuildAggregate builds a single GROUP BY query. Result columns are the group_by
+// values (in order) followed by the aggregates (in order).
+func BuildAggregate(opts AggregateOpts) (string, []any) {
+	cols := make([]string, 0, len(opts.GroupBy)+len(opts.Aggregates))
+	groups := make([]string, 0, len(opts.GroupBy))
+	for _, p := range opts.GroupBy {
+		e := fmt.Sprintf("json_extract(data, '%s')", p)
+		cols = append(cols, e)
+		groups = append(groups, e)
+	}
+	for _, a := range opts.Aggregates {
+		cols = append(cols, a.expr())
+	}
+	base := fmt.Sprintf("SELECT %s FROM data_%s WHERE collection = ?", strings.Join(cols, ", "), opts.Set)
+	args := []any{opts.Collection}
+	if opts.Where != nil {
+		for _, c := range opts.Where.Conds {
+			base += " AND " + c.SQL
+			args = append(args, c.Args...)
+		}
+	}
+	if len(groups) > 0 {
+		g := strings.Join(groups, ", ")
+		base += " GROUP BY " + g + " ORDER BY " + g
+	}
+	return base, args
+}
+
+// Scan reads the rows of a BuildAggregate query into one map per group:
+// {"group": {"status": "draft"}, "count": 3, "sum_price": 42}
+func (opts AggregateOpts) Scan(rows *sql.Rows) ([]map[string]any, error) {
+	out := []map[string]any{}
+	for rows.Next() {
+		vals := make([]any, len(opts.GroupBy)+len(opts.Aggregates))
+		ptrs := make([]any, len(vals))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := map[string]any{}
+		if len(opts.GroupBy) > 0 {
+			g := map[string]any{}
+			for i, p := range opts.GroupBy {
+				g[strings.TrimPrefix(p, "$.")] = vals[i]
+			}
+			row["group"] = g
+		}
+		for i, a := range opts.Aggregates {
+			row[a.Name()] = vals[len(opts.GroupBy)+i]
+		}
+		out = append(out, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
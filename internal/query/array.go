@@ -0,0 +1,165 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+var arrayOps = map[string]struct{}{
+	"$elemMatch": {},
+	"$all":       {},
+	"$any":       {},
+	"$size":      {},
+}
+
+// splitWildcard splits a path like $.items[*].sku at its first [*] into the path of the
+// array ($.items) and the rest, relative to each element ($.sku, or $ for the element).
+func splitWildcard(path string) (string, string, bool) {
+	i := strings.Index(path, "[*]")
+	if i < 0 {
+		return "", "", false
+	}
+	return path[:i], "$" + path[i+len("[*]"):], true
+}
+
+// pathSQL returns the SQL text of path, a JSONPath relative to the array element in scope
+// (see inElements) or to the document.
+func (pw *ParsedWhere) pathSQL(path string) string {
+	if pw.elem == "" {
+		return "'" + path + "'"
+	}
+	if path == "$" {
+		return pw.elem
+	}
+	return fmt.Sprintf("%s || '%s'", pw.elem, path[1:])
+}
+
+// expr returns the SQL value at path (see pathSQL). Outside arrays it is the expression
+// JSON-path indexes are built on.
+func (pw *ParsedWhere) expr(path string) string {
+	return fmt.Sprintf("json_extract(data, %s)", pw.pathSQL(path))
+}
+
+// inElements compiles conditions that must hold together for one element of the array at
+// path. compile runs with that element in scope, so the paths it reads are relative to the
+// element; json_each gives the full path of each element, which keeps every value read
+// from the document itself. Values that are not arrays never match.
+func (pw *ParsedWhere) inElements(path string, compile func() ([]Condition, error)) (Condition, error) {
+	src := pw.pathSQL(path)
+	pw.elems++
+	alias := fmt.Sprintf("e%d", pw.elems)
+	outer := pw.elem
+	pw.elem = alias + ".fullkey"
+	// a $near in an element does not sort the results
+	pw.depth++
+	conds, err := compile()
+	pw.elem = outer
+	pw.depth--
+	if err != nil {
+		return Condition{}, err
+	}
+	c := joinConds(conds, " AND ", "1=1")
+	return Condition{
+		SQL:  fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(data, %[1]s) AS %[2]s WHERE json_type(data, %[1]s) = 'array' AND %[3]s)", src, alias, c.SQL),
+		Args: c.Args,
+	}, nil
+}
+
+// parseArray compiles an array operator on path:
+//
+//	{"items": {"$elemMatch": {"sku": {"$eq": "A1"}, "qty": {"$gt": 5}}}}   one element matches every condition
+//	{"scores": {"$elemMatch": {"$gte": 80, "$lt": 90}}}                    same, on the elements themselves
+//	{"tags": {"$all": ["urgent", "billing"]}}                              every value is an element
+//	{"tags": {"$any": ["urgent", "billing"]}}                              some value is an element
+//	{"tags": {"$size": 2}}, {"tags": {"$size": {"$gte": 2}}}               the array has that many elements
+func (pw *ParsedWhere) parseArray(op, path string, val any) (Condition, error) {
+	switch op {
+	case "$elemMatch":
+		m, ok := val.(map[string]any)
+		if !ok || len(m) == 0 {
+			return Condition{}, fmt.Errorf("operator $elemMatch expects a where object or an operator object")
+		}
+		if !isOperatorObject(m) {
+			return pw.inElements(path, func() ([]Condition, error) { return pw.parseObject(m) })
+		}
+		return pw.inElements(path, func() ([]Condition, error) { return pw.parseOps("$", m) })
+	case "$all", "$any":
+		vals, ok := toInterfaceSlice(val)
+		if !ok {
+			return Condition{}, fmt.Errorf("operator %s expects an array of values", op)
+		}
+		for _, v := range vals {
+			switch v.(type) {
+			case string, float64, bool:
+			default:
+				return Condition{}, fmt.Errorf("operator %s expects an array of strings, numbers or booleans", op)
+			}
+		}
+		// By convention: $all [] and $any [] match no rows
+		if len(vals) == 0 {
+			return Condition{SQL: "1=0"}, nil
+		}
+		elem := func(cmp string, args []any) (Condition, error) {
+			return pw.inElements(path, func() ([]Condition, error) {
+				return []Condition{{SQL: fmt.Sprintf("%s %s", pw.expr("$"), cmp), Args: args}}, nil
+			})
+		}
+		if op == "$any" {
+			return elem("IN ("+placeholders(len(vals))+")", vals)
+		}
+		conds := make([]Condition, 0, len(vals))
+		for _, v := range vals {
+			c, err := elem("= ?", []any{v})
+			if err != nil {
+				return Condition{}, err
+			}
+			conds = append(conds, c)
+		}
+		return joinConds(conds, " AND ", "1=1"), nil
+	case "$size":
+		src := pw.pathSQL(path)
+		length := fmt.Sprintf("json_array_length(data, %s)", src)
+		isArray := fmt.Sprintf("json_type(data, %s) = 'array'", src)
+		if n, ok := val.(float64); ok {
+			if n < 0 || n != math.Trunc(n) {
+				return Condition{}, fmt.Errorf("operator $size expects a non-negative integer or an operator object")
+			}
+			return Condition{SQL: fmt.Sprintf("(%s AND %s = ?)", isArray, length), Args: []any{int64(n)}}, nil
+		}
+		m, ok := val.(map[string]any)
+		if !ok || len(m) == 0 || !isOperatorObject(m) {
+			return Condition{}, fmt.Errorf("operator $size expects a non-negative integer or an operator object")
+		}
+		conds := []Condition{{SQL: isArray}}
+		for _, op := range sortedKeys(m) {
+			if !ValidOperator(op) || isArrayOrGeo(op) {
+				return Condition{}, fmt.Errorf("operator $size does not support %s", op)
+			}
+			s, args, err := ToSQL(op, length, m[op])
+			if err != nil {
+				return Condition{}, err
+			}
+			conds = append(conds, Condition{SQL: s, Args: args})
+		}
+		return joinConds(conds, " AND ", "1=1"), nil
+	}
+	return Condition{}, fmt.Errorf("unsupported operator: %s", op)
+}
+
+// isOperatorObject reports whether every key of m is an operator ($gt, ...) rather than a
+// field or a logical operator.
+func isOperatorObject(m map[string]any) bool {
+	for k := range m {
+		if _, logical := logicalOps[k]; logical || !strings.HasPrefix(k, "$") || strings.HasPrefix(k, "$.") || k == "$search" {
+			return false
+		}
+	}
+	return true
+}
+
+func isArrayOrGeo(op string) bool {
+	_, array := arrayOps[op]
+	_, geo := geoOps[op]
+	return array || geo
+}
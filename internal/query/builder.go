@@ -9,32 +9,80 @@ type BuildOpts struct {
 	Set        string
 	Collection string
 	Where      *ParsedWhere
-	OrderBy    string
-	Limit      int
-	Offset     int
+	// OrderBy is the parsed order_by spec (see ParseOrderBy)
+	OrderBy []OrderField
+	Limit   int
+	Offset  int
+	// Fields projects the returned data onto these JSON paths (see ParseFields)
+	Fields []string
+	// After resumes a keyset-paginated listing right after the given cursor (Offset is ignored)
+	After *Cursor
+	// Snippets adds a highlighted snippet to the _search of documents found by $search
+	Snippets bool
 }
 
+// BuildSelect builds the query listing documents. Result columns are id, data, created_at,
+// updated_at, version and distance, the meters to the point of a top-level $near (or NULL),
+// followed on pages a cursor can resume by the sort values of the row (see KeyColumns).
 func BuildSelect(opts BuildOpts) (string, []any) {
 	table := fmt.Sprintf("data_%s", opts.Set)
-	base := fmt.Sprintf("SELECT id, data, created_at, updated_at FROM %s WHERE collection = ?", table)
-	args := []any{opts.Collection}
+	data := DataExpr(opts.Fields)
+	var args []any
+	distance := "NULL"
+	if opts.Where != nil && opts.Where.Near != nil {
+		distance = opts.Where.Near.SQL
+		args = append(args, opts.Where.Near.Args...)
+	}
+	var join string
+	if opts.searching() {
+		// top-level $search conditions report their relevance in _search
+		var joinArgs []any
+		join, joinArgs = searchJoin(opts)
+		args = append(args, joinArgs...)
+		data = searchData(opts, data)
+	}
+	var keys string
+	for _, t := range opts.cursorTerms() {
+		keys += ", " + t.expr()
+	}
+	base := fmt.Sprintf("SELECT id, %s, created_at, updated_at, version, %s AS distance%s FROM %s%s WHERE collection = ?", data, distance, keys, table, join)
+	args = append(args, opts.Collection)
 	if opts.Where != nil {
 		for _, c := range opts.Where.Conds {
 			base += " AND " + c.SQL
 			args = append(args, c.Args...)
 		}
 	}
-	if opts.OrderBy != "" {
-		if opts.OrderBy == "created_at" || opts.OrderBy == "updated_at" {
-			base += " ORDER BY " + opts.OrderBy
-		} else {
-			// treat as JSON path
-			base += " ORDER BY json_extract(data, '" + strings.ReplaceAll(opts.OrderBy, "'", "''") + "')"
+	// id breaks ties while paginating so rows sharing a key are neither skipped nor repeated
+	terms := opts.OrderBy
+	if opts.After != nil || opts.Limit > 0 {
+		terms = orderTerms(opts.OrderBy)
+	}
+	if opts.After != nil {
+		s, a := keysetPredicate(terms, opts.After.Keys)
+		base += " AND " + s
+		args = append(args, a...)
+	}
+	if opts.NearSorted() {
+		// nearest first; like rankings, distances cannot be resumed by a cursor
+		base += " ORDER BY distance, id"
+	} else if opts.SearchRanked() {
+		// best matches first; cursors cannot resume a ranking, so pages use offsets
+		base += " ORDER BY fts.score, id"
+	} else if len(terms) > 0 {
+		parts := make([]string, 0, len(terms))
+		for _, t := range terms {
+			if t.Desc {
+				parts = append(parts, t.expr()+" DESC")
+			} else {
+				parts = append(parts, t.expr())
+			}
 		}
+		base += " ORDER BY " + strings.Join(parts, ", ")
 	}
 	if opts.Limit > 0 {
 		base += fmt.Sprintf(" LIMIT %d", opts.Limit)
-		if opts.Offset > -1 {
+		if opts.Offset > -1 && opts.After == nil {
 			base += fmt.Sprintf(" OFFSET %d", opts.Offset)
 		}
 	}
@@ -0,0 +1,119 @@
+package query
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Cursor marks the last row of a page for keyset pagination. It is handed to
+// clients as an opaque token and turned back into a WHERE predicate by BuildSelect.
+type Cursor struct {
+	// OrderBy is the canonical order_by spec the cursor was produced for (see OrderSpec)
+	OrderBy string `json:"o,omitempty"`
+	// Keys holds the sort values of the last row, one per order term with id last
+	Keys []any `json:"k"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// EncodeCursor serializes a cursor into an opaque URL-safe token.
+func EncodeCursor(c Cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor parses a token produced by EncodeCursor and checks that it was
+// issued for the same order_by fields.
+func DecodeCursor(token string, orderBy []OrderField) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	// numbers are decoded as written, so integers beyond 2^53 survive the round trip
+	var c Cursor
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&c); err != nil || len(c.Keys) != len(orderTerms(orderBy)) {
+		return nil, errInvalidCursor
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errInvalidCursor
+	}
+	for i, k := range c.Keys {
+		v, err := cursorKey(k)
+		if err != nil {
+			return nil, err
+		}
+		c.Keys[i] = v
+	}
+	if c.OrderBy != OrderSpec(orderBy) {
+		return nil, fmt.Errorf("cursor was issued for order_by %q", c.OrderBy)
+	}
+	return &c, nil
+}
+
+// cursorKey turns a decoded sort value back into what BuildSelect read from SQLite: an
+// int64 for integers, a float64 for other numbers, a string or nil.
+func cursorKey(k any) (any, error) {
+	switch t := k.(type) {
+	case json.Number:
+		if n, err := t.Int64(); err == nil {
+			return n, nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		return f, nil
+	case string, nil:
+		return k, nil
+	}
+	return nil, errInvalidCursor
+}
+
+// cursorTerms returns the sort terms whose values BuildSelect adds to each row, after
+// the distance column, when the listing can be resumed by a cursor: it is paged and not
+// ranked by relevance or distance.
+func (opts BuildOpts) cursorTerms() []OrderField {
+	if opts.Limit <= 0 || opts.Ranked() {
+		return nil
+	}
+	return orderTerms(opts.OrderBy)
+}
+
+// KeyColumns returns Scan destinations for the sort values BuildSelect adds to each row,
+// none when it adds none. After each Scan they hold the keys of that row.
+func (opts BuildOpts) KeyColumns() []any {
+	dest := make([]any, len(opts.cursorTerms()))
+	for i := range dest {
+		dest[i] = new(any)
+	}
+	return dest
+}
+
+// NextCursor returns the cursor for the page following a page of n rows whose last row was
+// scanned into keys (see KeyColumns), or nil when the page is not full or the listing
+// cannot be resumed. The keys come from the page query itself, so writes made after it
+// cannot move the cursor.
+func (opts BuildOpts) NextCursor(n int, keys []any) (*Cursor, error) {
+	terms := opts.cursorTerms()
+	if len(terms) == 0 || n < opts.Limit {
+		return nil, nil
+	}
+	if len(keys) != len(terms) {
+		return nil, fmt.Errorf("cursor needs %d sort keys, got %d", len(terms), len(keys))
+	}
+	c := Cursor{OrderBy: OrderSpec(opts.OrderBy), Keys: make([]any, len(keys))}
+	for i, k := range keys {
+		p, ok := k.(*any)
+		if !ok {
+			return nil, fmt.Errorf("cursor sort key %d was not scanned from KeyColumns", i)
+		}
+		c.Keys[i] = *p
+	}
+	return &c, nil
+}
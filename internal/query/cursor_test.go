@@ -0,0 +1,229 @@
+package query
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	orderBy, err := ParseOrderBy("n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []any{
+		int64(9007199254740993), // 2^53 + 1
+		int64(-9007199254740993),
+		int64(9223372036854775807),
+		int64(0),
+		1.5,
+		-0.1,
+		1e300,
+		"b",
+		"",
+		nil,
+	}
+	for _, k := range tests {
+		c := Cursor{OrderBy: OrderSpec(orderBy), Keys: []any{k, "id1"}}
+		got, err := DecodeCursor(EncodeCursor(c), orderBy)
+		if err != nil {
+			t.Errorf("%v: %v", k, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Keys, c.Keys) {
+			t.Errorf("%v (%T): got %v (%T)", k, k, got.Keys[0], got.Keys[0])
+		}
+	}
+}
+
+func TestDecodeCursorErrors(t *testing.T) {
+	orderBy, err := ParseOrderBy("n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []string{
+		"not base64!",
+		token(`{"o": "n", "k": [1]}`),
+		token(`{"o": "n", "k": [1, "id1", 2]}`),
+		token(`{"o": "n", "k": [true, "id1"]}`),
+		token(`{"o": "n", "k": [{"a": 1}, "id1"]}`),
+		token(`{"o": "n", "k": [[1], "id1"]}`),
+		token(`{"o": "n", "k": [1, "id1"]} {}`),
+		token(`{"o": "-n", "k": [1, "id1"]}`),
+	}
+	for _, tok := range tests {
+		if c, err := DecodeCursor(tok, orderBy); err == nil {
+			t.Errorf("%s: got %+v, want an error", tok, c)
+		}
+	}
+}
+
+// A cursor on integers beyond 2^53 resumes right after the last row, which float64 keys
+// would round onto a neighbour.
+func TestCursorLargeIntegers(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`CREATE TABLE docs (id TEXT PRIMARY KEY, data TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	for id, n := range map[string]string{"a": "9007199254740992", "b": "9007199254740993", "c": "9007199254740994"} {
+		if _, err := db.Exec(`INSERT INTO docs (id, data) VALUES (?, ?)`, id, `{"n": `+n+`}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orderBy, err := ParseOrderBy("n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := BuildOpts{OrderBy: orderBy, Limit: 1}
+	keys := opts.KeyColumns()
+	if err := db.QueryRow(`SELECT json_extract(data, '$.n'), id FROM docs WHERE id = 'b'`).Scan(keys...); err != nil {
+		t.Fatal(err)
+	}
+	c, err := opts.NextCursor(1, keys)
+	if err != nil || c == nil {
+		t.Fatalf("NextCursor = %v, %v", c, err)
+	}
+	after, err := DecodeCursor(EncodeCursor(*c), orderBy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pred, args := keysetPredicate(orderTerms(orderBy), after.Keys)
+	rows, err := db.Query(`SELECT id FROM docs WHERE `+pred+` ORDER BY json_extract(data, '$.n'), id`, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"c"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("after b: got %v, want %v", ids, want)
+	}
+}
+
+func openSetDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(`CREATE TABLE data_s (id TEXT PRIMARY KEY, collection TEXT, data TEXT, created_at INTEGER, updated_at INTEGER, version INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// page runs BuildSelect and returns the ids of the rows and the cursor for the next page.
+func page(t *testing.T, db *sql.DB, opts BuildOpts) ([]string, *Cursor) {
+	t.Helper()
+	sqlStr, args := BuildSelect(opts)
+	rows, err := db.Query(sqlStr, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", sqlStr, err)
+	}
+	defer rows.Close()
+	var ids []string
+	keys := opts.KeyColumns()
+	for rows.Next() {
+		var id, data string
+		var created, updated, version int64
+		var distance sql.NullFloat64
+		if err := rows.Scan(append([]any{&id, &data, &created, &updated, &version, &distance}, keys...)...); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	next, err := opts.NextCursor(len(ids), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids, next
+}
+
+// The cursor holds the keys the page was sorted by, so moving its last row ahead or deleting
+// it before the next page is fetched neither repeats rows nor ends the listing.
+func TestCursorFromPage(t *testing.T) {
+	orderBy, err := ParseOrderBy("-updated_at")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, write := range []string{
+		`UPDATE data_s SET updated_at = 100 WHERE id = 'd'`,
+		`DELETE FROM data_s WHERE id = 'd'`,
+	} {
+		db := openSetDB(t)
+		for i, id := range []string{"a", "b", "c", "d", "e", "f"} {
+			if _, err := db.Exec(`INSERT INTO data_s VALUES (?, 'c', '{}', 1, ?, 1)`, id, 60-i*10); err != nil {
+				t.Fatal(err)
+			}
+		}
+		opts := BuildOpts{Set: "s", Collection: "c", OrderBy: orderBy, Limit: 4, Offset: -1}
+		first, next := page(t, db, opts)
+		if want := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(first, want) || next == nil {
+			t.Fatalf("first page = %v, %v; want %v and a cursor", first, next, want)
+		}
+		if _, err := db.Exec(write); err != nil {
+			t.Fatal(err)
+		}
+		opts.After = next
+		second, last := page(t, db, opts)
+		if want := []string{"e", "f"}; !reflect.DeepEqual(second, want) || last != nil {
+			t.Errorf("after %s: second page = %v, %v; want %v and no cursor", write, second, last, want)
+		}
+	}
+}
+
+func TestNextCursor(t *testing.T) {
+	orderBy, err := ParseOrderBy("n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(v any) *any { return &v }
+	tests := []struct {
+		name string
+		opts BuildOpts
+		n    int
+		keys []any
+		want *Cursor
+	}{
+		{"full page", BuildOpts{OrderBy: orderBy, Limit: 2}, 2, []any{key(int64(3)), key("b")},
+			&Cursor{OrderBy: "$.n", Keys: []any{int64(3), "b"}}},
+		{"short page", BuildOpts{OrderBy: orderBy, Limit: 2}, 1, []any{key(int64(3)), key("b")}, nil},
+		{"not paged", BuildOpts{OrderBy: orderBy}, 2, nil, nil},
+		{"near sorted", BuildOpts{Where: &ParsedWhere{Near: &Condition{SQL: "0"}}, Limit: 2}, 2, nil, nil},
+	}
+	for _, tt := range tests {
+		got, err := tt.opts.NextCursor(tt.n, tt.keys)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+	// a full page without its keys cannot be resumed, which is an error rather than the end
+	if _, err := (BuildOpts{OrderBy: orderBy, Limit: 2}).NextCursor(2, nil); err == nil {
+		t.Error("full page without keys: want an error")
+	}
+}
@@ -0,0 +1,100 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var fieldSegmentRe = regexp.MustCompile(`^[a-zA-Z0-9_\-]+$`)
+
+// ParseFields parses a comma-separated projection spec such as "title,user.name".
+// Paths may use dot notation or JSONPath and are returned as JSONPaths. A path
+// nested under another requested path is redundant and dropped.
+func ParseFields(spec string) ([]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	var paths []string
+	for _, raw := range strings.Split(spec, ",") {
+		term := strings.TrimSpace(raw)
+		if term == "" {
+			return nil, fmt.Errorf("malformed fields: empty field in %q", spec)
+		}
+		p, ok := fieldPath(term)
+		if !ok {
+			return nil, fmt.Errorf("malformed fields: invalid field path %q", term)
+		}
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	out := make([]string, 0, len(paths))
+next:
+	for _, p := range paths {
+		for _, kept := range out {
+			if p == kept || strings.HasPrefix(p, kept+".") {
+				continue next
+			}
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// fieldPath validates a dot path or JSONPath made of plain object keys and returns it as a JSONPath.
+func fieldPath(term string) (string, bool) {
+	p := strings.TrimPrefix(term, "$.")
+	for _, seg := range strings.Split(p, ".") {
+		if !fieldSegmentRe.MatchString(seg) {
+			return "", false
+		}
+	}
+	return "$." + p, true
+}
+
+// DataExpr returns the SQL expression selecting a document's data projected onto
+// fields, rebuilding nested objects with json_object. No fields selects the full document.
+func DataExpr(fields []string) string {
+	if len(fields) == 0 {
+		return "data"
+	}
+	root := &projNode{}
+	for _, f := range fields {
+		n := root
+		for _, seg := range strings.Split(strings.TrimPrefix(f, "$."), ".") {
+			n = n.child(seg)
+		}
+		n.path = f
+	}
+	return root.sql()
+}
+
+// projNode is a level of the projected object; leaves carry the source path.
+type projNode struct {
+	key      string
+	path     string
+	children []*projNode
+}
+
+func (n *projNode) child(key string) *projNode {
+	for _, c := range n.children {
+		if c.key == key {
+			return c
+		}
+	}
+	c := &projNode{key: key}
+	n.children = append(n.children, c)
+	return c
+}
+
+func (n *projNode) sql() string {
+	if n.path != "" {
+		return fmt.Sprintf("json_extract(data, '%s')", n.path)
+	}
+	parts := make([]string, 0, len(n.children)*2)
+	for _, c := range n.children {
+		parts = append(parts, "'"+c.key+"'", c.sql())
+	}
+	return "json_object(" + strings.Join(parts, ", ") + ")"
+}
@@ -0,0 +1,302 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// earthRadius is the mean radius of the Earth in meters.
+const earthRadius = 6371008.8
+
+// Point is a location in degrees.
+type Point struct {
+	Lat, Lng float64
+}
+
+// ParsePoint reads a point written as {"lat": 52.37, "lng": 4.89} ("lon" works too), as a
+// GeoJSON Point {"type": "Point", "coordinates": [4.89, 52.37]} or as a bare GeoJSON
+// position [4.89, 52.37]. Note GeoJSON puts the longitude first.
+func ParsePoint(v any) (Point, bool) {
+	var p Point
+	switch t := v.(type) {
+	case map[string]any:
+		if c, ok := t["coordinates"]; ok {
+			if t["type"] != "Point" {
+				return p, false
+			}
+			return ParsePoint(c)
+		}
+		lat, ok := t["lat"].(float64)
+		if !ok {
+			return p, false
+		}
+		lng, ok := t["lng"].(float64)
+		if !ok {
+			if lng, ok = t["lon"].(float64); !ok {
+				return p, false
+			}
+		}
+		p = Point{Lat: lat, Lng: lng}
+	case []any:
+		// a third number is an altitude, which is ignored
+		if len(t) != 2 && len(t) != 3 {
+			return p, false
+		}
+		lng, ok1 := t[0].(float64)
+		lat, ok2 := t[1].(float64)
+		if !ok1 || !ok2 {
+			return p, false
+		}
+		p = Point{Lat: lat, Lng: lng}
+	default:
+		return p, false
+	}
+	if math.Abs(p.Lat) > 90 || math.Abs(p.Lng) > 180 {
+		return p, false
+	}
+	return p, true
+}
+
+// pointKeys rejects keys of m, a point written as an object in op, other than those of its
+// form (see ParsePoint) and extra, so a misspelled option fails rather than being ignored.
+// Points in documents may carry other fields and are not checked.
+func pointKeys(op string, m map[string]any, extra ...string) error {
+	known := []string{"lat", "lng", "lon"}
+	if _, ok := m["coordinates"]; ok {
+		known = []string{"type", "coordinates"}
+	}
+	for _, k := range sortedKeys(m) {
+		if !slices.Contains(known, k) && !slices.Contains(extra, k) {
+			return fmt.Errorf("operator %s: unknown key %q", op, k)
+		}
+	}
+	_, lng := m["lng"]
+	_, lon := m["lon"]
+	if lng && lon {
+		return fmt.Errorf("operator %s: use lng or lon, not both", op)
+	}
+	return nil
+}
+
+// ParsePointJSON is ParsePoint for JSON text, as stored in documents.
+func ParsePointJSON(s string) (Point, bool) {
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return Point{}, false
+	}
+	return ParsePoint(v)
+}
+
+// Distance returns the great-circle distance between two points in meters.
+func Distance(a, b Point) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat, dLng := lat2-lat1, (b.Lng-a.Lng)*math.Pi/180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Box is an area between two latitudes and two longitudes. West > East means the box
+// crosses the antimeridian.
+type Box struct {
+	South, West, North, East float64
+}
+
+func (b Box) Contains(p Point) bool {
+	if p.Lat < b.South || p.Lat > b.North {
+		return false
+	}
+	if b.West <= b.East {
+		return p.Lng >= b.West && p.Lng <= b.East
+	}
+	return p.Lng >= b.West || p.Lng <= b.East
+}
+
+// Polygon is a ring of vertices, not repeating the first. Edges are straight lines in
+// latitude and longitude, which is accurate enough for areas up to a city or region.
+type Polygon []Point
+
+// ParsePolygon reads an array of at least three points (see ParsePoint) or a GeoJSON
+// Polygon without holes. Keys the polygon or its points do not use are rejected.
+func ParsePolygon(v any) (Polygon, error) {
+	if m, ok := v.(map[string]any); ok {
+		rings, ok := m["coordinates"].([]any)
+		if m["type"] != "Polygon" || !ok || len(rings) == 0 {
+			return nil, fmt.Errorf("operator $withinPolygon expects an array of points or a GeoJSON Polygon")
+		}
+		for _, k := range sortedKeys(m) {
+			if k != "type" && k != "coordinates" {
+				return nil, fmt.Errorf("operator $withinPolygon: unknown key %q", k)
+			}
+		}
+		if len(rings) > 1 {
+			return nil, fmt.Errorf("operator $withinPolygon does not support polygons with holes")
+		}
+		v = rings[0]
+	}
+	arr, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("operator $withinPolygon expects an array of points or a GeoJSON Polygon")
+	}
+	pg := make(Polygon, 0, len(arr))
+	for i, it := range arr {
+		if m, ok := it.(map[string]any); ok {
+			if err := pointKeys("$withinPolygon", m); err != nil {
+				return nil, err
+			}
+		}
+		p, ok := ParsePoint(it)
+		if !ok {
+			return nil, fmt.Errorf("operator $withinPolygon: vertex %d is not a point", i)
+		}
+		pg = append(pg, p)
+	}
+	if len(pg) > 1 && pg[0] == pg[len(pg)-1] {
+		pg = pg[:len(pg)-1]
+	}
+	if len(pg) < 3 {
+		return nil, fmt.Errorf("operator $withinPolygon expects at least three vertices")
+	}
+	return pg, nil
+}
+
+// Contains reports whether p lies inside the polygon (even-odd rule).
+func (pg Polygon) Contains(p Point) bool {
+	in := false
+	for i, j := 0, len(pg)-1; i < len(pg); j, i = i, i+1 {
+		a, b := pg[i], pg[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) && p.Lng < (b.Lng-a.Lng)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lng {
+			in = !in
+		}
+	}
+	return in
+}
+
+func (pg Polygon) bounds() Box {
+	b := Box{South: 90, West: 180, North: -90, East: -180}
+	for _, p := range pg {
+		b.South, b.North = math.Min(b.South, p.Lat), math.Max(b.North, p.Lat)
+		b.West, b.East = math.Min(b.West, p.Lng), math.Max(b.East, p.Lng)
+	}
+	return b
+}
+
+// geoToSQL compiles $near, $withinBox and $withinPolygon on the point expr evaluates to.
+// The SQL functions are registered with the driver in database/geo.go. It also returns the
+// bounding box of the matching points, for an R*Tree index to narrow the candidates, when
+// there is one not crossing the antimeridian or a pole.
+func geoToSQL(op, expr string, val any) (string, []any, *Box, error) {
+	switch op {
+	case "$near":
+		m, _ := val.(map[string]any)
+		if err := pointKeys(op, m, "radius"); err != nil {
+			return "", nil, nil, err
+		}
+		c, ok := ParsePoint(m)
+		if !ok {
+			return "", nil, nil, fmt.Errorf("operator $near expects {\"lat\": .., \"lng\": .., \"radius\": meters}")
+		}
+		r, has := m["radius"]
+		if !has {
+			return fmt.Sprintf("geo_distance(%s, ?, ?) IS NOT NULL", expr), []any{c.Lat, c.Lng}, nil, nil
+		}
+		radius, ok := r.(float64)
+		if !ok || radius < 0 {
+			return "", nil, nil, fmt.Errorf("operator $near expects radius to be a distance in meters")
+		}
+		return fmt.Sprintf("geo_distance(%s, ?, ?) <= ?", expr), []any{c.Lat, c.Lng, radius}, circleBounds(c, radius), nil
+	case "$withinBox":
+		arr, _ := val.([]any)
+		if len(arr) != 2 {
+			return "", nil, nil, fmt.Errorf("operator $withinBox expects [southwest, northeast] corners")
+		}
+		for _, corner := range arr {
+			if m, ok := corner.(map[string]any); ok {
+				if err := pointKeys(op, m); err != nil {
+					return "", nil, nil, err
+				}
+			}
+		}
+		sw, ok1 := ParsePoint(arr[0])
+		ne, ok2 := ParsePoint(arr[1])
+		if !ok1 || !ok2 {
+			return "", nil, nil, fmt.Errorf("operator $withinBox expects [southwest, northeast] corners")
+		}
+		if sw.Lat > ne.Lat {
+			return "", nil, nil, fmt.Errorf("operator $withinBox: the southwest corner is north of the northeast one")
+		}
+		b := Box{South: sw.Lat, West: sw.Lng, North: ne.Lat, East: ne.Lng}
+		s := fmt.Sprintf("geo_within_box(%s, ?, ?, ?, ?)", expr)
+		args := []any{b.South, b.West, b.North, b.East}
+		if b.West > b.East {
+			return s, args, nil, nil
+		}
+		return s, args, &b, nil
+	case "$withinPolygon":
+		pg, err := ParsePolygon(val)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		ring := make([][2]float64, len(pg))
+		for i, p := range pg {
+			ring[i] = [2]float64{p.Lng, p.Lat}
+		}
+		b, _ := json.Marshal(ring)
+		box := pg.bounds()
+		return fmt.Sprintf("geo_within_polygon(%s, ?)", expr), []any{string(b)}, &box, nil
+	}
+	return "", nil, nil, fmt.Errorf("unsupported operator: %s", op)
+}
+
+// circleBounds returns the box around a circle, or nil when it reaches a pole or the
+// antimeridian.
+func circleBounds(c Point, radius float64) *Box {
+	d := radius / earthRadius
+	dLat := d * 180 / math.Pi
+	if c.Lat+dLat >= 90 || c.Lat-dLat <= -90 || d >= math.Pi/2 {
+		return nil
+	}
+	dLng := math.Asin(math.Min(1, math.Sin(d)/math.Cos(c.Lat*math.Pi/180))) * 180 / math.Pi
+	if c.Lng+dLng > 180 || c.Lng-dLng < -180 {
+		return nil
+	}
+	return &Box{South: c.Lat - dLat, West: c.Lng - dLng, North: c.Lat + dLat, East: c.Lng + dLng}
+}
+
+// parseGeo compiles a geo operator on path. With an R*Tree index on path the candidates are
+// narrowed to its bounding box first; a top-level $near also sorts the results by distance.
+func (pw *ParsedWhere) parseGeo(op, path, expr string, val any) (Condition, error) {
+	s, args, box, err := geoToSQL(op, expr, val)
+	if err != nil {
+		return Condition{}, err
+	}
+	if op == "$near" && pw.depth == 0 {
+		if pw.Near != nil {
+			return Condition{}, fmt.Errorf("a where clause may sort by only one top-level $near")
+		}
+		pw.Near = &Condition{SQL: fmt.Sprintf("geo_distance(%s, ?, ?)", expr), Args: args[:2]}
+	}
+	if box == nil || pw.indexes == nil || pw.elem != "" {
+		return Condition{SQL: s, Args: args}, nil
+	}
+	table, err := pw.indexes.GeoTable(path)
+	if err != nil {
+		return Condition{}, err
+	}
+	if table == "" {
+		return Condition{SQL: s, Args: args}, nil
+	}
+	// points are stored as empty boxes; the R*Tree rounds them outward, so this only
+	// narrows the candidates and the exact test still applies
+	return Condition{
+		SQL:  fmt.Sprintf("(rowid IN (SELECT id FROM %s WHERE min_lat <= ? AND max_lat >= ? AND min_lng <= ? AND max_lng >= ?) AND %s)", table, s),
+		Args: append([]any{box.North, box.South, box.East, box.West}, args...),
+	}, nil
+}
+
+// NearSorted reports whether BuildSelect sorts the results by the distance of a top-level
+// $near: no order_by overrides the order.
+func (opts BuildOpts) NearSorted() bool {
+	return opts.Where != nil && opts.Where.Near != nil && len(opts.OrderBy) == 0
+}
@@ -0,0 +1,85 @@
+package query
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("%s: %v", s, err)
+	}
+	return v
+}
+
+func TestGeoToSQL(t *testing.T) {
+	tests := []struct {
+		op, val string
+		sql     string
+		args    []any
+	}{
+		{"$near", `{"lat": 52.37, "lng": 4.89, "radius": 100}`,
+			"geo_distance(p, ?, ?) <= ?", []any{52.37, 4.89, 100.0}},
+		{"$near", `{"lat": 52.37, "lon": 4.89}`,
+			"geo_distance(p, ?, ?) IS NOT NULL", []any{52.37, 4.89}},
+		{"$near", `{"type": "Point", "coordinates": [4.89, 52.37], "radius": 100}`,
+			"geo_distance(p, ?, ?) <= ?", []any{52.37, 4.89, 100.0}},
+		{"$withinBox", `[{"lat": 52, "lng": 4}, [5, 53]]`,
+			"geo_within_box(p, ?, ?, ?, ?)", []any{52.0, 4.0, 53.0, 5.0}},
+		{"$withinPolygon", `[[4, 52], {"lat": 52, "lng": 5}, {"type": "Point", "coordinates": [5, 53]}]`,
+			"geo_within_polygon(p, ?)", []any{"[[4,52],[5,52],[5,53]]"}},
+		{"$withinPolygon", `{"type": "Polygon", "coordinates": [[[4, 52], [5, 52], [5, 53], [4, 52]]]}`,
+			"geo_within_polygon(p, ?)", []any{"[[4,52],[5,52],[5,53]]"}},
+	}
+	for _, tt := range tests {
+		sql, args, _, err := geoToSQL(tt.op, "p", decodeJSON(t, tt.val))
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.op, tt.val, err)
+			continue
+		}
+		if sql != tt.sql || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s %s: got %s %v, want %s %v", tt.op, tt.val, sql, args, tt.sql, tt.args)
+		}
+	}
+}
+
+func TestGeoToSQLErrors(t *testing.T) {
+	tests := []struct {
+		op, val, err string
+	}{
+		{"$near", `{"lat": 52.37, "lng": 4.89, "max": 100000}`, `unknown key "max"`},
+		{"$near", `{"lat": 52.37, "lng": 4.89, "radius": 100, "Radius": 5}`, `unknown key "Radius"`},
+		{"$near", `{"type": "Point", "coordinates": [4.89, 52.37], "lat": 1}`, `unknown key "lat"`},
+		{"$near", `{"lat": 52.37, "lng": 4.89, "lon": 4.89}`, "not both"},
+		{"$near", `{"lat": 52.37, "radius": 100}`, "expects"},
+		{"$near", `{"lat": 52.37, "lng": 4.89, "radius": -1}`, "radius"},
+		{"$near", `[4.89, 52.37]`, "expects"},
+		{"$withinBox", `[{"lat": 52, "lng": 4, "alt": 3}, [5, 53]]`, `unknown key "alt"`},
+		{"$withinBox", `[[4, 52]]`, "corners"},
+		{"$withinBox", `[[4, 53], [5, 52]]`, "north"},
+		{"$withinPolygon", `{"type": "Polygon", "coordinates": [[[4, 52], [5, 52], [5, 53]]], "crs": "x"}`, `unknown key "crs"`},
+		{"$withinPolygon", `[[4, 52], {"lat": 52, "lng": 5, "name": "b"}, [5, 53]]`, `unknown key "name"`},
+		{"$withinPolygon", `{"type": "Polygon", "coordinates": [[[4, 52], [5, 52], [5, 53]], [[4, 52], [5, 52], [5, 53]]]}`, "holes"},
+		{"$withinPolygon", `[[4, 52], [5, 52], [4, 52]]`, "three"},
+		{"$withinPolygon", `[[4, 52], [5, 52], "x"]`, "vertex 2"},
+	}
+	for _, tt := range tests {
+		_, _, _, err := geoToSQL(tt.op, "p", decodeJSON(t, tt.val))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s %s: got %v, want an error containing %q", tt.op, tt.val, err, tt.err)
+		}
+	}
+}
+
+// Points stored in documents may carry other fields.
+func TestParsePointExtraKeys(t *testing.T) {
+	for _, s := range []string{`{"lat": 52, "lng": 4, "name": "x"}`, `{"type": "Point", "coordinates": [4, 52], "properties": {}}`} {
+		if p, ok := ParsePointJSON(s); !ok || p != (Point{Lat: 52, Lng: 4}) {
+			t.Errorf("ParsePointJSON(%s) = %v, %v", s, p, ok)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// knnMetrics maps each metric to the SQL function computing it between two encoded
+// vectors (see database/vector.go). Lower is always nearer.
+var knnMetrics = map[string]string{
+	"cosine": "vec_distance_cosine",
+	"dot":    "vec_distance_dot",
+	"l2":     "vec_distance_l2",
+}
+
+// ParseMetric validates a kNN metric; "" means cosine.
+func ParseMetric(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return "cosine", nil
+	}
+	if _, ok := knnMetrics[s]; !ok {
+		return "", fmt.Errorf("metric must be one of cosine, dot, l2")
+	}
+	return s, nil
+}
+
+type KNNOpts struct {
+	Set        string
+	Collection string
+	// Table holds the encoded vectors of the collection, keyed by document id
+	Table string
+	// Vector is the encoded query vector
+	Vector []byte
+	Metric string
+	K      int
+	// Where filters the candidates before they are ranked
+	Where  *ParsedWhere
+	Fields []string
+}
+
+// BuildKNN builds an exact nearest-neighbour query: every document matching the where is
+// scored against the query vector and the K nearest are returned, nearest first, with the
+// distance in _knn. Documents without a vector in Table are skipped. Result columns are
+// id, data, created_at, updated_at and version.
+func BuildKNN(opts KNNOpts) (string, []any) {
+	table := fmt.Sprintf("data_%s", opts.Set)
+	inner := fmt.Sprintf("SELECT id, data, created_at, updated_at, version, (SELECT %s(v.vec, ?) FROM %s v WHERE v.id = %s.id) AS distance FROM %s WHERE collection = ?",
+		knnMetrics[opts.Metric], opts.Table, table, table)
+	args := []any{opts.Vector, opts.Collection}
+	if opts.Where != nil {
+		for _, c := range opts.Where.Conds {
+			inner += " AND " + c.SQL
+			args = append(args, c.Args...)
+		}
+	}
+	data := "json_set(" + DataExpr(opts.Fields) + ", '$._knn', json_object('distance', distance))"
+	// SQLite keeps only the K best rows while sorting, so memory stays bounded by K
+	q := fmt.Sprintf("SELECT id, %s, created_at, updated_at, version FROM (%s) WHERE distance IS NOT NULL ORDER BY distance, id LIMIT %d", data, inner, opts.K)
+	return q, args
+}
@@ -25,6 +25,30 @@ var supportedOps = map[string]struct{}{
 	"$between":     {},
 	"$isNull":      {},
 	"$notNull":     {},
+	"$regex":       {},
+	// type operators (see types.go)
+	"$type":   {},
+	"$exists": {},
+	// geo operators (see geo.go)
+	"$near":          {},
+	"$withinBox":     {},
+	"$withinPolygon": {},
+	// array operators (see array.go)
+	"$elemMatch": {},
+	"$all":       {},
+	"$any":       {},
+	"$size":      {},
+}
+
+var typeOps = map[string]struct{}{
+	"$type":   {},
+	"$exists": {},
+}
+
+var geoOps = map[string]struct{}{
+	"$near":          {},
+	"$withinBox":     {},
+	"$withinPolygon": {},
 }
 
 func ValidOperator(op string) bool {
@@ -34,6 +58,8 @@ func ValidOperator(op string) bool {
 
 // ToSQL translates an operator, an expression (e.g. json_extract(...)), and a value into a SQL fragment and its args.
 // The returned SQL should be safe to concatenate in WHERE with AND.
+// Array and type operators are not expressions on a single value and are compiled by the
+// parser (see array.go and types.go).
 func ToSQL(op string, expr string, val any) (string, []any, error) {
 	switch op {
 	// Comparations
@@ -58,6 +84,13 @@ func ToSQL(op string, expr string, val any) (string, []any, error) {
 		return fmt.Sprintf("LOWER(CAST(%s AS TEXT)) LIKE ('%s' || LOWER(?))", expr, "%"), []any{val}, nil
 	case "$icontains":
 		return fmt.Sprintf("LOWER(CAST(%s AS TEXT)) LIKE ('%s' || LOWER(?) || '%s')", expr, "%", "%"), []any{val}, nil
+	case "$regex":
+		// REGEXP calls the regexp function registered in database.Open; it only matches strings
+		pattern, err := regexpPattern(val)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("%s REGEXP ?", expr), []any{pattern}, nil
 
 	// Set operators
 	case "$in", "$nin":
@@ -90,6 +123,10 @@ func ToSQL(op string, expr string, val any) (string, []any, error) {
 		return fmt.Sprintf("%s IS NULL", expr), nil, nil
 	case "$notNull":
 		return fmt.Sprintf("%s IS NOT NULL", expr), nil, nil
+
+	case "$near", "$withinBox", "$withinPolygon":
+		s, args, _, err := geoToSQL(op, expr, val)
+		return s, args, err
 	}
 	return "", nil, fmt.Errorf("unsupported operator: %s", op)
 }
@@ -0,0 +1,154 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// OrderField is one term of an order_by spec.
+type OrderField struct {
+	// Path is a system column (id, created_at, updated_at) or a normalized JSON path (e.g. $.user.age)
+	Path string
+	Desc bool
+}
+
+var systemColumns = map[string]struct{}{
+	"id":         {},
+	"created_at": {},
+	"updated_at": {},
+}
+
+var orderSegmentRe = regexp.MustCompile(`^[a-zA-Z0-9_\-]+(\[[0-9]+\])*$`)
+
+// ParseOrderBy parses a comma-separated order_by spec such as "-priority,created_at".
+// A leading "-" sorts the term descending. Terms may be system columns, dot paths or JSONPaths.
+func ParseOrderBy(spec string) ([]OrderField, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	var fields []OrderField
+	seen := map[string]struct{}{}
+	for _, raw := range strings.Split(spec, ",") {
+		term := strings.TrimSpace(raw)
+		f := OrderField{}
+		if strings.HasPrefix(term, "-") {
+			f.Desc = true
+			term = strings.TrimSpace(term[1:])
+		}
+		if term == "" {
+			return nil, fmt.Errorf("malformed order_by: empty field in %q", spec)
+		}
+		if _, ok := systemColumns[term]; ok {
+			f.Path = term
+		} else {
+			p, err := orderPath(term)
+			if err != nil {
+				return nil, err
+			}
+			f.Path = p
+		}
+		if _, dup := seen[f.Path]; dup {
+			return nil, fmt.Errorf("malformed order_by: duplicate field %q", term)
+		}
+		seen[f.Path] = struct{}{}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// orderPath validates a dot path or JSONPath and returns it as a JSONPath.
+func orderPath(term string) (string, error) {
+	p := strings.TrimPrefix(term, "$.")
+	for _, seg := range strings.Split(p, ".") {
+		if !orderSegmentRe.MatchString(seg) {
+			return "", fmt.Errorf("malformed order_by: invalid field path %q", term)
+		}
+	}
+	return "$." + p, nil
+}
+
+// OrderSpec renders order fields back into their canonical order_by form.
+func OrderSpec(fields []OrderField) string {
+	parts := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f.Desc {
+			parts = append(parts, "-"+f.Path)
+		} else {
+			parts = append(parts, f.Path)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f OrderField) expr() string {
+	if _, ok := systemColumns[f.Path]; ok {
+		return f.Path
+	}
+	return "json_extract(data, '" + f.Path + "')"
+}
+
+// orderTerms returns the fields used for sorting with id appended as a tie-breaker.
+// The tie-breaker follows the direction of the last field.
+func orderTerms(fields []OrderField) []OrderField {
+	terms := make([]OrderField, 0, len(fields)+1)
+	desc := false
+	for _, f := range fields {
+		terms = append(terms, f)
+		desc = f.Desc
+		if f.Path == "id" {
+			// id is unique, nothing after it can break ties
+			return terms
+		}
+	}
+	return append(terms, OrderField{Path: "id", Desc: desc})
+}
+
+// keysetPredicate builds the condition selecting rows sorted strictly after keys
+// (one value per term, id last) for the given terms.
+func keysetPredicate(terms []OrderField, keys []any) (string, []any) {
+	ascending := true
+	for i, t := range terms {
+		if t.Desc || keys[i] == nil {
+			ascending = false
+		}
+	}
+	if ascending {
+		// (a, b, id) > (?, ?, ?) lets SQLite use a matching index. Descending specs can't use it
+		// because NULLs sort last there and never compare greater or lower than a key.
+		exprs := make([]string, len(terms))
+		for i, t := range terms {
+			exprs[i] = t.expr()
+		}
+		if len(terms) == 1 {
+			return exprs[0] + " > ?", keys
+		}
+		return fmt.Sprintf("(%s) > (%s)", strings.Join(exprs, ", "), placeholders(len(terms))), keys
+	}
+	// Expand lexicographically: t1 after k1 OR (t1 = k1 AND t2 after k2) OR ...
+	// NULLs sort first ascending and last descending, mirroring SQLite.
+	var ors []string
+	var args []any
+	for i, t := range terms {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, terms[j].expr()+" IS ?")
+			args = append(args, keys[j])
+		}
+		e := t.expr()
+		switch {
+		case keys[i] == nil && !t.Desc:
+			ands = append(ands, e+" IS NOT NULL")
+		case keys[i] == nil && t.Desc:
+			ands = append(ands, "1=0")
+		case !t.Desc:
+			ands = append(ands, e+" > ?")
+			args = append(args, keys[i])
+		default:
+			ands = append(ands, "("+e+" < ? OR "+e+" IS NULL)")
+			args = append(args, keys[i])
+		}
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
@@ -2,7 +2,9 @@ package query
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -15,36 +17,253 @@ type ParsedWhere struct {
 	Conds []Condition
 	// Paths contains the normalized JSON paths (e.g. $.user.email) referenced in the where clause
 	Paths []string
+	// FullText is the index $search conditions run against, and Search holds the FTS5
+	// queries of the top-level ones, which rank the results (see ParseWhereWith)
+	FullText *FullText
+	Search   []string
+	// Near is the distance in meters to the point of a top-level $near, which sorts the
+	// results and is reported as _meta.distance (see BuildSelect)
+	Near *Condition
+
+	indexes Indexes
+	depth   int
+	// elem is the SQL path of the array element conditions apply to, and elems counts the
+	// elements expanded so far, to name them (see inElements)
+	elem  string
+	elems int
+}
+
+// Logical operators accepted as keys of a where object. They nest arbitrarily:
+//
+//	{"$or": [{"status": {"$eq": "draft"}}, {"owner": {"$eq": "me"}}]}
+//	{"$not": {"archived": {"$eq": true}}}
+var logicalOps = map[string]struct{}{
+	"$and": {},
+	"$or":  {},
+	"$not": {},
 }
 
-// ParseWhere expects a JSON object like {"field.path": {"$op": value}}
+const malformedWhere = "malformed where clause: expected a JSON object where keys are field paths and values are operator objects"
+
+// ParseWhere expects a JSON object like {"field.path": {"$op": value}}.
+// The logical keys $and/$or (arrays of where objects) and $not (a where object)
+// may appear at any level and compile to parenthesised SQL. $search is rejected; see
+// ParseWhereWith.
 func ParseWhere(whereRaw string) (*ParsedWhere, error) {
+	return ParseWhereWith(whereRaw, nil)
+}
+
+// ParseWhereWith is ParseWhere with the collection's indexes. It enables $search, which
+// matches documents against the full-text index, either across all indexed fields or in
+// one of them:
+//
+//	{"$search": "quick fox"}
+//	{"title": {"$search": "\"brown fox\" OR hound*"}}
+//
+// $search conditions at the top level also rank the results (see BuildSelect). Geo
+// operators work without indexes but use an R*Tree index on their path when there is one.
+func ParseWhereWith(whereRaw string, idx Indexes) (*ParsedWhere, error) {
 	if strings.TrimSpace(whereRaw) == "" {
 		return &ParsedWhere{Conds: []Condition{}, Paths: []string{}}, nil
 	}
-	var obj map[string]map[string]interface{}
+	var obj map[string]any
 	if err := json.Unmarshal([]byte(whereRaw), &obj); err != nil {
-		return nil, fmt.Errorf("malformed where clause: expected a JSON object where keys are field paths and values are operator objects")
-	}
-	pw := &ParsedWhere{Conds: []Condition{}, Paths: []string{}}
-	for path, ops := range obj {
-		jsonPath := toJSONPath(path)
-		expr := fmt.Sprintf("json_extract(data, '%s')", jsonPath)
-		for op, v := range ops {
-			if !ValidOperator(op) {
-				return nil, fmt.Errorf("unsupported operator: %s", op)
+		return nil, errors.New(malformedWhere)
+	}
+	pw := &ParsedWhere{Conds: []Condition{}, Paths: []string{}, indexes: idx}
+	conds, err := pw.parseObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	pw.Conds = conds
+	return pw, nil
+}
+
+// parseObject compiles every key of a where object into conditions that are meant to be ANDed.
+func (pw *ParsedWhere) parseObject(obj map[string]any) ([]Condition, error) {
+	var conds []Condition
+	for _, key := range sortedKeys(obj) {
+		if key == "$search" {
+			c, err := pw.parseSearch("", obj[key])
+			if err != nil {
+				return nil, err
 			}
-			s, args, err := ToSQL(op, expr, v)
+			conds = append(conds, c)
+			continue
+		}
+		if _, ok := logicalOps[key]; ok {
+			c, err := pw.parseLogical(key, obj[key])
 			if err != nil {
 				return nil, err
 			}
-			pw.Conds = append(pw.Conds, Condition{SQL: s, Args: args})
+			conds = append(conds, c)
+			continue
+		}
+		ops, ok := obj[key].(map[string]any)
+		if !ok {
+			return nil, errors.New(malformedWhere)
+		}
+		jsonPath := toJSONPath(key)
+		c, err := pw.parseOps(jsonPath, ops)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, c...)
+		// paths inside array elements are not paths of the document
+		if pw.elem == "" {
+			pw.addPath(jsonPath)
 		}
-		pw.Paths = append(pw.Paths, jsonPath)
 	}
-	return pw, nil
+	return conds, nil
+}
+
+// parseOps compiles an operator object applied to path into conditions that are meant to be ANDed.
+func (pw *ParsedWhere) parseOps(path string, ops map[string]any) ([]Condition, error) {
+	conds := make([]Condition, 0, len(ops))
+	for _, op := range sortedKeys(ops) {
+		c, err := pw.parseOp(op, path, ops[op])
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, c)
+	}
+	return conds, nil
+}
+
+// parseOp compiles one operator applied to path. On a path like $.items[*].sku it holds
+// when it holds for the sku of some element of items.
+func (pw *ParsedWhere) parseOp(op, path string, val any) (Condition, error) {
+	if op == "$search" {
+		return pw.parseSearch(path, val)
+	}
+	if !ValidOperator(op) {
+		return Condition{}, fmt.Errorf("unsupported operator: %s", op)
+	}
+	if array, rest, ok := splitWildcard(path); ok {
+		return pw.inElements(array, func() ([]Condition, error) {
+			c, err := pw.parseOp(op, rest, val)
+			return []Condition{c}, err
+		})
+	}
+	if _, ok := arrayOps[op]; ok {
+		return pw.parseArray(op, path, val)
+	}
+	if _, ok := typeOps[op]; ok {
+		return pw.parseType(op, path, val)
+	}
+	expr := pw.expr(path)
+	if _, ok := geoOps[op]; ok {
+		return pw.parseGeo(op, path, expr, val)
+	}
+	s, args, err := ToSQL(op, expr, val)
+	if err != nil {
+		return Condition{}, err
+	}
+	return Condition{SQL: s, Args: args}, nil
+}
+
+// sortedKeys returns the keys of m in order; a stable order keeps the generated SQL deterministic.
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseLogical compiles a $and/$or/$not value into a single parenthesised condition.
+func (pw *ParsedWhere) parseLogical(op string, val any) (Condition, error) {
+	pw.depth++
+	defer func() { pw.depth-- }()
+	if op == "$not" {
+		inner, ok := val.(map[string]any)
+		if !ok {
+			return Condition{}, fmt.Errorf("operator $not expects a where object")
+		}
+		conds, err := pw.parseObject(inner)
+		if err != nil {
+			return Condition{}, err
+		}
+		c := joinConds(conds, " AND ", "1=1")
+		// a condition on a missing field is NULL, and NOT NULL is NULL too; count it as
+		// false so $not matches the documents without the field
+		return Condition{SQL: "NOT COALESCE(" + c.SQL + ", 0)", Args: c.Args}, nil
+	}
+
+	items, ok := val.([]any)
+	if !ok {
+		return Condition{}, fmt.Errorf("operator %s expects an array of where objects", op)
+	}
+	branches := make([]Condition, 0, len(items))
+	for _, it := range items {
+		inner, ok := it.(map[string]any)
+		if !ok {
+			return Condition{}, fmt.Errorf("operator %s expects an array of where objects", op)
+		}
+		conds, err := pw.parseObject(inner)
+		if err != nil {
+			return Condition{}, err
+		}
+		branches = append(branches, joinConds(conds, " AND ", "1=1"))
+	}
+	// By convention: $and [] => all rows; $or [] => no rows
+	if op == "$or" {
+		return joinConds(branches, " OR ", "1=0"), nil
+	}
+	return joinConds(branches, " AND ", "1=1"), nil
+}
+
+// joinConds wraps conds joined by sep in parentheses, or returns empty when there are none.
+func joinConds(conds []Condition, sep, empty string) Condition {
+	if len(conds) == 0 {
+		return Condition{SQL: "(" + empty + ")"}
+	}
+	parts := make([]string, 0, len(conds))
+	var args []any
+	for _, c := range conds {
+		parts = append(parts, c.SQL)
+		args = append(args, c.Args...)
+	}
+	return Condition{SQL: "(" + strings.Join(parts, sep) + ")", Args: args}
+}
+
+// And returns a where matching both pw and other, e.g. a client filter narrowed by access
+// rules. Either may be nil.
+func (pw *ParsedWhere) And(other *ParsedWhere) *ParsedWhere {
+	if other == nil {
+		return pw
+	}
+	if pw == nil {
+		return other
+	}
+	out := &ParsedWhere{Conds: append(append([]Condition{}, pw.Conds...), other.Conds...), Paths: append([]string{}, pw.Paths...)}
+	for _, p := range other.Paths {
+		out.addPath(p)
+	}
+	out.FullText = pw.FullText
+	if out.FullText == nil {
+		out.FullText = other.FullText
+	}
+	out.Search = append(append([]string{}, pw.Search...), other.Search...)
+	out.Near = pw.Near
+	if out.Near == nil {
+		out.Near = other.Near
+	}
+	return out
+}
+
+func (pw *ParsedWhere) addPath(p string) {
+	for _, existing := range pw.Paths {
+		if existing == p {
+			return
+		}
+	}
+	pw.Paths = append(pw.Paths, p)
 }
 
+// toJSONPath normalizes a where key. Array elements are addressed as in JSONPath, by index
+// (items[0].sku) or, for conditions any element may satisfy, by wildcard (items[*].sku).
 func toJSONPath(dot string) string {
     // If already a JSONPath (e.g. $.user.age), return it as-is after escaping single quotes
     if strings.HasPrefix(dot, "$.") {
@@ -0,0 +1,288 @@
+package query
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+
+	"modernc.org/sqlite"
+)
+
+// whereDocs are the documents the where tests run against, by id.
+var whereDocs = map[string]string{
+	"a": `{"archived": true, "status": "draft", "n": 1, "tags": ["urgent", "billing"], "title": "Quarterly Report",
+		"items": [{"sku": "A1", "qty": 2, "parts": [{"id": "p1"}]}, {"sku": "B2", "qty": 9}]}`,
+	"b": `{"archived": false, "status": "published", "n": 2, "tags": ["billing"], "title": "report\ndraft",
+		"items": [{"sku": "A1", "qty": 9, "parts": [{"id": "p2"}, {"id": "p1"}]}]}`,
+	"c": `{"status": "draft", "tags": "urgent", "items": []}`,
+	"d": `{"archived": null, "n": 4, "tags": [], "title": 7}`,
+}
+
+// The server gets regexp from the database package, which imports this one; the tests
+// register it over CompileRegexp without the cache.
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		pattern, _ := args[0].(string)
+		s, ok := args[1].(string)
+		if !ok {
+			return false, nil
+		}
+		re, err := CompileRegexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(s), nil
+	})
+}
+
+func openWhereDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(`CREATE TABLE docs (id TEXT PRIMARY KEY, data TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	for id, data := range whereDocs {
+		if _, err := db.Exec(`INSERT INTO docs (id, data) VALUES (?, ?)`, id, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// matching returns the ids of the documents where selects, in order.
+func matching(t *testing.T, db *sql.DB, where string) []string {
+	t.Helper()
+	pw, err := ParseWhere(where)
+	if err != nil {
+		t.Fatalf("ParseWhere(%s): %v", where, err)
+	}
+	q := "SELECT id FROM docs WHERE 1=1"
+	var args []any
+	for _, c := range pw.Conds {
+		q += " AND " + c.SQL
+		args = append(args, c.Args...)
+	}
+	rows, err := db.Query(q+" ORDER BY id", args...)
+	if err != nil {
+		t.Fatalf("%s: %v", q, err)
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestWhereLogical(t *testing.T) {
+	db := openWhereDB(t)
+	tests := []struct {
+		where string
+		want  string
+	}{
+		{`{"archived": {"$eq": true}}`, "a"},
+		// documents without the field, or with null, are not archived
+		{`{"$not": {"archived": {"$eq": true}}}`, "b,c,d"},
+		{`{"$not": {"n": {"$gt": 1}}}`, "a,c"},
+		{`{"$not": {"$not": {"archived": {"$eq": true}}}}`, "a"},
+		{`{"$not": {"status": {"$eq": "draft"}, "n": {"$eq": 1}}}`, "b,c,d"},
+		{`{"$not": {"$or": [{"archived": {"$eq": true}}, {"n": {"$eq": 2}}]}}`, "c,d"},
+		{`{"$or": [{"status": {"$eq": "draft"}}, {"n": {"$eq": 4}}]}`, "a,c,d"},
+		{`{"$or": []}`, ""},
+		{`{"$and": []}`, "a,b,c,d"},
+		{`{"$and": [{"status": {"$eq": "draft"}}, {"$not": {"archived": {"$eq": true}}}]}`, "c"},
+	}
+	for _, tt := range tests {
+		got := strings.Join(matching(t, db, tt.where), ",")
+		if got != tt.want {
+			t.Errorf("%s: got [%s], want [%s]", tt.where, got, tt.want)
+		}
+	}
+}
+
+func TestWhereArrays(t *testing.T) {
+	db := openWhereDB(t)
+	tests := []struct {
+		where string
+		want  string
+	}{
+		// $elemMatch needs one element to match every condition, [*] paths any elements
+		{`{"items": {"$elemMatch": {"sku": {"$eq": "A1"}, "qty": {"$gt": 5}}}}`, "b"},
+		{`{"items[*].sku": {"$eq": "A1"}, "items[*].qty": {"$gt": 5}}`, "a,b"},
+		{`{"tags": {"$elemMatch": {"$eq": "urgent"}}}`, "a"},
+		{`{"tags": {"$elemMatch": {"$ne": "urgent"}}}`, "a,b"},
+		{`{"items": {"$elemMatch": {"parts": {"$elemMatch": {"id": {"$eq": "p2"}}}}}}`, "b"},
+		{`{"items": {"$elemMatch": {"sku": {"$eq": "B2"}, "parts": {"$elemMatch": {"id": {"$eq": "p1"}}}}}}`, ""},
+		{`{"items": {"$elemMatch": {"$or": [{"sku": {"$eq": "B2"}}, {"qty": {"$eq": 9}}]}}}`, "a,b"},
+		{`{"items[*].parts[*].id": {"$eq": "p1"}}`, "a,b"},
+		// a string is not an array of one
+		{`{"tags[*]": {"$eq": "urgent"}}`, "a"},
+		{`{"$not": {"tags[*]": {"$eq": "urgent"}}}`, "b,c,d"},
+		{`{"$not": {"items[*].qty": {"$gt": 5}}}`, "c,d"},
+		{`{"$not": {"items": {"$elemMatch": {"sku": {"$eq": "A1"}}}}}`, "c,d"},
+		{`{"tags": {"$all": ["urgent", "billing"]}}`, "a"},
+		{`{"tags": {"$all": ["billing"]}}`, "a,b"},
+		{`{"tags": {"$all": []}}`, ""},
+		{`{"tags": {"$any": ["urgent", "nope"]}}`, "a"},
+		{`{"items[*].parts[*].id": {"$any": ["p2"]}}`, ""},
+		{`{"tags": {"$any": []}}`, ""},
+		{`{"tags": {"$size": 2}}`, "a"},
+		{`{"tags": {"$size": 0}}`, "d"},
+		{`{"tags": {"$size": {"$gte": 1}}}`, "a,b"},
+		{`{"items": {"$size": 0}}`, "c"},
+		{`{"items[*].parts": {"$size": 2}}`, "b"},
+		// strings, numbers and missing fields have no size
+		{`{"status": {"$size": 5}}`, ""},
+		{`{"n": {"$size": {"$gte": 0}}}`, ""},
+		{`{"$not": {"tags": {"$size": 1}}}`, "a,c,d"},
+	}
+	for _, tt := range tests {
+		got := strings.Join(matching(t, db, tt.where), ",")
+		if got != tt.want {
+			t.Errorf("%s: got [%s], want [%s]", tt.where, got, tt.want)
+		}
+	}
+}
+
+func TestWhereRegexp(t *testing.T) {
+	db := openWhereDB(t)
+	tests := []struct {
+		where string
+		want  string
+	}{
+		{`{"title": {"$regex": "^Quarterly"}}`, "a"},
+		{`{"title": {"$regex": "report"}}`, "b"},
+		{`{"title": {"$regex": {"pattern": "report"}}}`, "b"},
+		{`{"title": {"$regex": {"pattern": "REPORT", "flags": "i"}}}`, "a,b"},
+		{`{"title": {"$regex": "(?i)^q.*t$"}}`, "a"},
+		{`{"title": {"$regex": {"pattern": "^draft", "flags": "m"}}}`, "b"},
+		{`{"title": {"$regex": "^draft"}}`, ""},
+		{`{"title": {"$regex": {"pattern": "report.draft", "flags": "s"}}}`, "b"},
+		// only strings match: not the number 7, nor a missing field
+		{`{"title": {"$regex": "7"}}`, ""},
+		{`{"title": {"$regex": ""}}`, "a,b"},
+		{`{"$not": {"title": {"$regex": "report"}}}`, "a,c,d"},
+		{`{"tags[*]": {"$regex": "^urg"}}`, "a"},
+	}
+	for _, tt := range tests {
+		got := strings.Join(matching(t, db, tt.where), ",")
+		if got != tt.want {
+			t.Errorf("%s: got [%s], want [%s]", tt.where, got, tt.want)
+		}
+	}
+}
+
+func TestCompileRegexp(t *testing.T) {
+	for _, pattern := range []string{"^a+b$", "(?i)x|y", strings.Repeat("a", MaxRegexpLength), "(a{10}){10}"} {
+		if _, err := CompileRegexp(pattern); err != nil {
+			t.Errorf("CompileRegexp(%.20q): %v", pattern, err)
+		}
+	}
+	tests := []struct {
+		pattern, err string
+	}{
+		{strings.Repeat("a", MaxRegexpLength+1), "longer than"},
+		// short, but the repetition compiles to more than maxRegexpInsts
+		{"(abcdefgh){700}", "too complex"},
+		{"(a{100}){100}", "invalid repeat count"},
+		{"(", "missing closing )"},
+		{"a{1001}", "invalid repeat count"},
+		{"[z-a]", "invalid character class range"},
+		{`\p{Nope}`, "invalid character class range"},
+	}
+	for _, tt := range tests {
+		_, err := CompileRegexp(tt.pattern)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("CompileRegexp(%.20q) = %v, want an error containing %q", tt.pattern, err, tt.err)
+		}
+	}
+}
+
+func TestWhereTypes(t *testing.T) {
+	db := openWhereDB(t)
+	tests := []struct {
+		where string
+		want  string
+	}{
+		// an explicit null exists; only a missing field does not
+		{`{"archived": {"$exists": true}}`, "a,b,d"},
+		{`{"archived": {"$exists": false}}`, "c"},
+		{`{"archived": {"$isNull": true}}`, "c,d"},
+		{`{"$not": {"archived": {"$exists": false}}}`, "a,b,d"},
+		{`{"archived": {"$type": "null"}}`, "d"},
+		{`{"archived": {"$type": "boolean"}}`, "a,b"},
+		{`{"tags": {"$type": "array"}}`, "a,b,d"},
+		{`{"tags": {"$type": ["string", "null"]}}`, "c"},
+		{`{"title": {"$type": "number"}}`, "d"},
+		{`{"title": {"$type": "integer"}}`, "d"},
+		{`{"items[*]": {"$type": "object"}}`, "a,b"},
+		// a missing field has no type, also under $not
+		{`{"$not": {"n": {"$type": "number"}}}`, "c"},
+		{`{"$not": {"title": {"$type": "string"}}}`, "c,d"},
+	}
+	for _, tt := range tests {
+		got := strings.Join(matching(t, db, tt.where), ",")
+		if got != tt.want {
+			t.Errorf("%s: got [%s], want [%s]", tt.where, got, tt.want)
+		}
+	}
+}
+
+func TestWhereErrors(t *testing.T) {
+	tests := []string{
+		`[1]`,
+		`{"status": "draft"}`,
+		`{"status": {"$nope": 1}}`,
+		`{"$not": [{"n": {"$eq": 1}}]}`,
+		`{"$or": {"n": {"$eq": 1}}}`,
+		`{"$or": [1]}`,
+		`{"n": {"$between": [1]}}`,
+		`{"items": {"$elemMatch": {}}}`,
+		`{"items": {"$elemMatch": 1}}`,
+		`{"tags": {"$all": "urgent"}}`,
+		`{"tags": {"$any": [{"a": 1}]}}`,
+		`{"tags": {"$size": -1}}`,
+		`{"tags": {"$size": 1.5}}`,
+		`{"tags": {"$size": {"$all": [1]}}}`,
+		`{"title": {"$regex": "("}}`,
+		`{"title": {"$regex": 1}}`,
+		`{"title": {"$regex": {"pattern": "a", "flags": "x"}}}`,
+		`{"title": {"$regex": {"pattern": "a", "flags": 1}}}`,
+		`{"title": {"$regex": {"pattern": "a", "options": "i"}}}`,
+		`{"title": {"$regex": {"flags": "i"}}}`,
+		`{"title": {"$regex": "(abcdefgh){700}"}}`,
+		`{"archived": {"$exists": 1}}`,
+		`{"archived": {"$type": "date"}}`,
+		`{"archived": {"$type": []}}`,
+		`{"archived": {"$type": [1]}}`,
+	}
+	for _, where := range tests {
+		if _, err := ParseWhere(where); err == nil {
+			t.Errorf("%s: want an error", where)
+		}
+	}
+}
+
+func TestWherePaths(t *testing.T) {
+	pw, err := ParseWhere(`{"$or": [{"user.age": {"$gt": 1}}, {"$.country": {"$eq": "x"}}], "user.age": {"$lt": 9}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"$.user.age", "$.country"}; !reflect.DeepEqual(pw.Paths, want) {
+		t.Errorf("Paths = %v, want %v", pw.Paths, want)
+	}
+}
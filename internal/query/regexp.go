@@ -0,0 +1,81 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+const (
+	// MaxRegexpLength bounds the length in bytes of a $regex pattern.
+	MaxRegexpLength = 512
+	// maxRegexpInsts bounds the size of the compiled program, which counted repetitions
+	// like (a{100}){100} multiply far beyond the length of the pattern.
+	maxRegexpInsts = 5000
+)
+
+// regexpFlags are the flags a $regex may set: case-insensitive, multi-line (^ and $ match
+// at line breaks), s (. matches \n) and ungreedy.
+const regexpFlags = "imsU"
+
+// CompileRegexp compiles a $regex pattern, with its flags already applied, rejecting
+// patterns too long or too complex to run on every document of a collection. Go regexps
+// run in linear time, so once compiled no input can make them backtrack.
+func CompileRegexp(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > MaxRegexpLength {
+		return nil, fmt.Errorf("operator $regex: pattern longer than %d bytes", MaxRegexpLength)
+	}
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("operator $regex: %v", err)
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return nil, fmt.Errorf("operator $regex: %v", err)
+	}
+	if len(prog.Inst) > maxRegexpInsts {
+		return nil, fmt.Errorf("operator $regex: pattern too complex")
+	}
+	return regexp.Compile(pattern)
+}
+
+// regexpPattern reads a $regex value, a pattern or {"pattern": "...", "flags": "i"}, and
+// returns the pattern with its flags.
+func regexpPattern(val any) (string, error) {
+	var pattern, flags string
+	switch t := val.(type) {
+	case string:
+		pattern = t
+	case map[string]any:
+		p, ok := t["pattern"].(string)
+		if !ok {
+			return "", fmt.Errorf("operator $regex expects a pattern or {\"pattern\": .., \"flags\": ..}")
+		}
+		pattern = p
+		if f, has := t["flags"]; has {
+			if flags, ok = f.(string); !ok {
+				return "", fmt.Errorf("operator $regex expects flags to be a string")
+			}
+		}
+		for k := range t {
+			if k != "pattern" && k != "flags" {
+				return "", fmt.Errorf("operator $regex: unknown key %q", k)
+			}
+		}
+	default:
+		return "", fmt.Errorf("operator $regex expects a pattern or {\"pattern\": .., \"flags\": ..}")
+	}
+	for _, f := range flags {
+		if !strings.ContainsRune(regexpFlags, f) {
+			return "", fmt.Errorf("operator $regex: unknown flag %q (use %s)", f, regexpFlags)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	if _, err := CompileRegexp(pattern); err != nil {
+		return "", err
+	}
+	return pattern, nil
+}
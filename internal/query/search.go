@@ -0,0 +1,179 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FullText describes the FTS5 table indexing a collection (see database.FullTextIndex).
+type FullText struct {
+	Table string
+	// Columns maps each indexed JSONPath to its column in Table
+	Columns map[string]string
+}
+
+// Indexes tells the where parser which indexes the collection being queried has. Its
+// methods are only called when a where clause can use the index.
+type Indexes interface {
+	// FullText returns the full-text index, or nil when there is none
+	FullText() (*FullText, error)
+	// GeoTable returns the R*Tree table indexing the points at path, or "" when there is none
+	GeoTable(path string) (string, error)
+}
+
+var errSearchUnsupported = errors.New("$search is not supported here")
+
+// Search returns a where matching documents whose indexed fields match q, ranked like a
+// top-level $search. It backs the q query parameter.
+func Search(q string, idx Indexes) (*ParsedWhere, error) {
+	b, _ := json.Marshal(map[string]any{"$search": q})
+	return ParseWhereWith(string(b), idx)
+}
+
+// fullText loads the collection's index on first use.
+func (pw *ParsedWhere) fullText() (*FullText, error) {
+	if pw.FullText != nil {
+		return pw.FullText, nil
+	}
+	if pw.indexes == nil {
+		return nil, errSearchUnsupported
+	}
+	ft, err := pw.indexes.FullText()
+	if err != nil {
+		return nil, err
+	}
+	if ft == nil {
+		return nil, errors.New("the collection has no full-text index (see _fulltext)")
+	}
+	pw.FullText = ft
+	return ft, nil
+}
+
+// parseSearch compiles a $search on path ("" for every indexed field) into a condition on
+// the rowid of the set table, which is its rid column.
+func (pw *ParsedWhere) parseSearch(path string, val any) (Condition, error) {
+	s, ok := val.(string)
+	if !ok {
+		return Condition{}, fmt.Errorf("operator $search expects a string")
+	}
+	if pw.elem != "" || strings.Contains(path, "[*]") {
+		return Condition{}, fmt.Errorf("operator $search cannot be applied to array elements")
+	}
+	ft, err := pw.fullText()
+	if err != nil {
+		return Condition{}, err
+	}
+	match, err := matchExpr(s)
+	if err != nil {
+		return Condition{}, err
+	}
+	if path != "" {
+		col, ok := ft.Columns[path]
+		if !ok {
+			return Condition{}, fmt.Errorf("field %s is not in the full-text index", strings.TrimPrefix(path, "$."))
+		}
+		match = col + " : (" + match + ")"
+	}
+	if pw.depth == 0 {
+		pw.Search = append(pw.Search, match)
+	}
+	return Condition{SQL: fmt.Sprintf("rowid IN (SELECT rowid FROM %[1]s WHERE %[1]s MATCH ?)", ft.Table), Args: []any{match}}, nil
+}
+
+// matchExpr turns a search string into an FTS5 query. Words must all appear; "double
+// quotes" match a phrase, a trailing * matches a prefix, OR between terms matches either
+// and AND between terms is the same as a space. Everything else is quoted, so user input
+// never breaks the FTS5 syntax; an unclosed quote or a dangling OR or AND is an error
+// rather than a search for the words "OR" or "AND".
+func matchExpr(s string) (string, error) {
+	var parts []string
+	var op string
+	for rest := strings.TrimSpace(s); rest != ""; rest = strings.TrimLeft(rest, " \t\r\n") {
+		var term string
+		var prefix bool
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return "", fmt.Errorf("operator $search: unterminated phrase (missing closing \")")
+			}
+			term, rest = rest[1:end+1], rest[end+2:]
+			prefix = strings.HasPrefix(rest, "*")
+			rest = strings.TrimLeft(rest, "*")
+		} else {
+			end := strings.IndexAny(rest, " \t\r\n")
+			if end < 0 {
+				end = len(rest)
+			}
+			term, rest = rest[:end], rest[end:]
+			if term == "OR" || term == "AND" {
+				if len(parts) == 0 || op != "" {
+					return "", fmt.Errorf("operator $search: %s needs a term on each side", term)
+				}
+				op = term
+				continue
+			}
+			prefix = strings.HasSuffix(term, "*")
+			term = strings.TrimRight(term, "*")
+		}
+		if strings.TrimSpace(term) == "" {
+			continue
+		}
+		q := `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		if prefix {
+			q += "*"
+		}
+		// FTS5 ANDs terms next to each other
+		if op == "OR" {
+			parts = append(parts, op)
+		}
+		op = ""
+		parts = append(parts, q)
+	}
+	if op != "" {
+		return "", fmt.Errorf("operator $search: %s needs a term on each side", op)
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("operator $search expects a non-empty search string")
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// SearchRanked reports whether BuildSelect ranks the results by relevance: the where has
+// top-level $search conditions and neither order_by nor a $near overrides the order.
+func (opts BuildOpts) SearchRanked() bool {
+	return opts.searching() && len(opts.OrderBy) == 0 && !opts.NearSorted()
+}
+
+// Ranked reports whether BuildSelect orders the results by relevance or distance, which
+// cursors cannot resume.
+func (opts BuildOpts) Ranked() bool {
+	return opts.SearchRanked() || opts.NearSorted()
+}
+
+func (opts BuildOpts) searching() bool {
+	return opts.Where != nil && opts.Where.FullText != nil && len(opts.Where.Search) > 0
+}
+
+// searchJoin joins the BM25 score (lower is better) and, with opts.Snippets, a highlighted
+// snippet of the top-level $search conditions as fts.score and fts.snippet.
+func searchJoin(opts BuildOpts) (string, []any) {
+	ft := opts.Where.FullText
+	snippet := "NULL"
+	if opts.Snippets {
+		snippet = fmt.Sprintf("snippet(%s, -1, '<mark>', '</mark>', '…', 16)", ft.Table)
+	}
+	match := "(" + strings.Join(opts.Where.Search, ") AND (") + ")"
+	return fmt.Sprintf(" JOIN (SELECT rowid AS rid, bm25(%[1]s) AS score, %[2]s AS snippet FROM %[1]s WHERE %[1]s MATCH ?) AS fts ON fts.rid = data_%[3]s.rowid",
+		ft.Table, snippet, opts.Set), []any{match}
+}
+
+// searchData adds _search with the relevance score (higher is better) and the snippet to
+// the selected document.
+func searchData(opts BuildOpts, data string) string {
+	if opts.Snippets {
+		return "json_set(" + data + ", '$._search', json_object('score', -fts.score, 'snippet', fts.snippet))"
+	}
+	return "json_set(" + data + ", '$._search', json_object('score', -fts.score))"
+}
@@ -0,0 +1,55 @@
+package query
+
+import "testing"
+
+func TestMatchExpr(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`quick fox`, `"quick" "fox"`},
+		{`  quick   fox  `, `"quick" "fox"`},
+		{`quick AND fox`, `"quick" "fox"`},
+		{`quick OR fox`, `"quick" OR "fox"`},
+		{`"brown fox" OR hound*`, `"brown fox" OR "hound"*`},
+		{`"brown fox"* jumps`, `"brown fox"* "jumps"`},
+		{`NOT quick`, `"NOT" "quick"`},
+		{`NEAR(quick fox)`, `"NEAR(quick" "fox)"`},
+		{`col:quick`, `"col:quick"`},
+		{`qu"ick`, `"qu""ick"`},
+		{`or and`, `"or" "and"`},
+		{`"" quick`, `"quick"`},
+		{`quick **`, `"quick"`},
+	}
+	for _, tt := range tests {
+		got, err := matchExpr(tt.in)
+		if err != nil {
+			t.Errorf("matchExpr(%s): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("matchExpr(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMatchExprErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`   `,
+		`*`,
+		`""`,
+		`"unterminated`,
+		`quick "brown fox`,
+		`quick AND`,
+		`quick OR`,
+		`OR quick`,
+		`AND quick`,
+		`quick OR OR fox`,
+		`quick AND OR fox`,
+	}
+	for _, in := range tests {
+		if got, err := matchExpr(in); err == nil {
+			t.Errorf("matchExpr(%s) = %s, want an error", in, got)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// jsonTypes maps each $type name to the values json_type gives for it.
+var jsonTypes = map[string][]string{
+	"null":    {"null"},
+	"boolean": {"true", "false"},
+	"number":  {"integer", "real"},
+	"integer": {"integer"},
+	"string":  {"text"},
+	"array":   {"array"},
+	"object":  {"object"},
+}
+
+// parseType compiles $exists and $type on path. Unlike $isNull and $notNull they tell a
+// field set to null from a missing one:
+//
+//	{"deleted_at": {"$exists": false}}       the field is missing
+//	{"price": {"$type": "number"}}           the field holds a number
+//	{"tags": {"$type": ["string", "array"]}} the field holds one of these
+func (pw *ParsedWhere) parseType(op, path string, val any) (Condition, error) {
+	typ := fmt.Sprintf("json_type(data, %s)", pw.pathSQL(path))
+	if op == "$exists" {
+		b, ok := val.(bool)
+		if !ok {
+			return Condition{}, fmt.Errorf("operator $exists expects true or false")
+		}
+		if b {
+			return Condition{SQL: typ + " IS NOT NULL"}, nil
+		}
+		return Condition{SQL: typ + " IS NULL"}, nil
+	}
+	var names []any
+	switch t := val.(type) {
+	case string:
+		names = []any{t}
+	case []any:
+		names = t
+	}
+	if len(names) == 0 {
+		return Condition{}, fmt.Errorf("operator $type expects a type or an array of types (%s)", typeNames())
+	}
+	var args []any
+	for _, n := range names {
+		s, _ := n.(string)
+		types, ok := jsonTypes[s]
+		if !ok {
+			return Condition{}, fmt.Errorf("operator $type: unknown type %v (use %s)", n, typeNames())
+		}
+		for _, t := range types {
+			args = append(args, t)
+		}
+	}
+	// a missing field is of no type, so the condition is false rather than NULL under $not
+	return Condition{SQL: fmt.Sprintf("COALESCE(%s IN (%s), 0)", typ, placeholders(len(args))), Args: args}, nil
+}
+
+func typeNames() string {
+	names := make([]string, 0, len(jsonTypes))
+	for n := range jsonTypes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,249 @@
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Update operators accepted in a PATCH body. They compile to one SQL expression over the
+// stored data so the whole change is applied by a single UPDATE statement.
+var updateOps = map[string]struct{}{
+	"$set":      {},
+	"$unset":    {},
+	"$inc":      {},
+	"$mul":      {},
+	"$min":      {},
+	"$max":      {},
+	"$push":     {},
+	"$addToSet": {},
+	"$pull":     {},
+}
+
+// canonicalElem renders a json_each row as minified JSON text so array elements can be
+// compared with values encoded in Go.
+const canonicalElem = "(CASE e.type WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' WHEN 'null' THEN 'null' WHEN 'text' THEN json_quote(e.value) ELSE json(e.value) END)"
+
+// Update is a compiled set of update operators.
+type Update struct {
+	// Expr is the new value of the data column, Args its bind parameters in order.
+	Expr string
+	Args []any
+	// Guards are conditions the stored document must meet for the operators to apply,
+	// e.g. $inc targets a number. Each one carries the error reported when it does not hold.
+	Guards []Guard
+}
+
+// Guard is a precondition on the stored document's field types.
+type Guard struct {
+	SQL     string
+	Message string
+}
+
+// GuardSQL returns all guards ANDed, or "1=1" when there are none.
+func (u *Update) GuardSQL() string {
+	if len(u.Guards) == 0 {
+		return "1=1"
+	}
+	parts := make([]string, 0, len(u.Guards))
+	for _, g := range u.Guards {
+		parts = append(parts, g.SQL)
+	}
+	return "(" + strings.Join(parts, " AND ") + ")"
+}
+
+// IsUpdate reports whether a PATCH body uses update operators, i.e. any top-level key starts with "$".
+func IsUpdate(body map[string]any) bool {
+	for k := range body {
+		if strings.HasPrefix(k, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseUpdate compiles a body like {"$inc": {"stock": -1}, "$push": {"tags": "new"}}.
+// Every top-level key must be an operator whose value maps field paths to arguments.
+// A path may be targeted by a single operator only.
+func ParseUpdate(body map[string]any) (*Update, error) {
+	type target struct {
+		op, path string
+		arg      any
+	}
+	var targets []target
+	for op, v := range body {
+		if _, ok := updateOps[op]; !ok {
+			if !strings.HasPrefix(op, "$") {
+				return nil, fmt.Errorf("cannot mix update operators with plain fields: %s", op)
+			}
+			return nil, fmt.Errorf("unsupported update operator: %s", op)
+		}
+		fields, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("operator %s expects an object of field paths", op)
+		}
+		for key, arg := range fields {
+			p, ok := fieldPath(key)
+			if !ok {
+				return nil, fmt.Errorf("operator %s: invalid field path %q", op, key)
+			}
+			if strings.HasPrefix(strings.TrimPrefix(p, "$."), "_") {
+				return nil, fmt.Errorf("operator %s: fields starting with '_' are reserved", op)
+			}
+			targets = append(targets, target{op: op, path: p, arg: arg})
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("update has no fields to modify")
+	}
+	// stable order keeps the generated SQL deterministic
+	sort.Slice(targets, func(i, j int) bool { return targets[i].path < targets[j].path })
+	for i := 1; i < len(targets); i++ {
+		a, b := targets[i-1].path, targets[i].path
+		if a == b || strings.HasPrefix(b, a+".") {
+			return nil, fmt.Errorf("conflicting update paths: %s and %s", strings.TrimPrefix(a, "$."), strings.TrimPrefix(b, "$."))
+		}
+	}
+
+	u := &Update{Expr: "data"}
+	for _, t := range targets {
+		if err := u.add(t.op, t.path, t.arg); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// add wraps the current expression with the SQL implementing op on path. Value expressions
+// read the stored data, which is safe because paths never overlap.
+func (u *Update) add(op, path string, arg any) error {
+	field := strings.TrimPrefix(path, "$.")
+	cur := fmt.Sprintf("json_extract(data, '%s')", path)
+	typ := fmt.Sprintf("json_type(data, '%s')", path)
+	switch op {
+	case "$set":
+		u.wrap("json_set", path, "json(?)", jsonText(arg))
+	case "$unset":
+		u.Expr = fmt.Sprintf("json_remove(%s, '%s')", u.Expr, path)
+	case "$inc", "$mul":
+		n, ok := arg.(float64)
+		if !ok {
+			return fmt.Errorf("operator %s expects a number for %s", op, field)
+		}
+		if op == "$inc" {
+			u.wrap("json_set", path, "COALESCE("+cur+", 0) + ?", number(n))
+		} else {
+			u.wrap("json_set", path, "COALESCE("+cur+", 0) * ?", number(n))
+		}
+		u.guard(typ+" IS NULL OR "+typ+" IN ('integer', 'real')", fmt.Sprintf("operator %s requires a numeric field at %s", op, field))
+	case "$min", "$max":
+		var v any
+		switch a := arg.(type) {
+		case float64:
+			v = number(a)
+		case string:
+			v = a
+		default:
+			return fmt.Errorf("operator %s expects a number or string for %s", op, field)
+		}
+		fn := strings.TrimPrefix(op, "$")
+		u.wrap("json_set", path, fmt.Sprintf("COALESCE(%s(%s, ?), ?)", fn, cur), v, v)
+	case "$push":
+		vals, err := eachValues(op, field, arg, "$each")
+		if err != nil {
+			return err
+		}
+		expr := "COALESCE(" + cur + ", '[]')"
+		args := make([]any, 0, len(vals))
+		for _, v := range vals {
+			expr = "json_insert(" + expr + ", '$[#]', json(?))"
+			args = append(args, jsonText(v))
+		}
+		u.wrap("json_set", path, "json("+expr+")", args...)
+		u.guard(typ+" IS NULL OR "+typ+" = 'array'", fmt.Sprintf("operator %s requires an array field at %s", op, field))
+	case "$addToSet":
+		vals, err := eachValues(op, field, arg, "$each")
+		if err != nil {
+			return err
+		}
+		base := "COALESCE(" + cur + ", '[]')"
+		expr := fmt.Sprintf("json((SELECT json_group_array(json(c)) FROM ("+
+			"SELECT %[2]s AS c FROM json_each(%[1]s) e "+
+			"UNION ALL SELECT value FROM json_each(?) WHERE value NOT IN (SELECT %[2]s FROM json_each(%[1]s) e))))", base, canonicalElem)
+		u.wrap("json_set", path, expr, canonicalSet(vals))
+		u.guard(typ+" IS NULL OR "+typ+" = 'array'", fmt.Sprintf("operator %s requires an array field at %s", op, field))
+	case "$pull":
+		vals, err := eachValues(op, field, arg, "$in")
+		if err != nil {
+			return err
+		}
+		// json_replace leaves a missing field missing
+		expr := fmt.Sprintf("json((SELECT json_group_array(json(%[2]s)) FROM json_each(%[1]s) e WHERE %[2]s NOT IN (SELECT value FROM json_each(?))))", cur, canonicalElem)
+		u.wrap("json_replace", path, expr, canonicalSet(vals))
+		u.guard(typ+" IS NULL OR "+typ+" = 'array'", fmt.Sprintf("operator %s requires an array field at %s", op, field))
+	}
+	return nil
+}
+
+func (u *Update) wrap(fn, path, valueExpr string, args ...any) {
+	u.Expr = fmt.Sprintf("%s(%s, '%s', %s)", fn, u.Expr, path, valueExpr)
+	u.Args = append(u.Args, args...)
+}
+
+func (u *Update) guard(sql, msg string) {
+	u.Guards = append(u.Guards, Guard{SQL: "(" + sql + ")", Message: msg})
+}
+
+// eachValues returns the values an array operator applies: the argument itself, or the
+// elements of {"<key>": [...]} ($each for $push/$addToSet, $in for $pull).
+func eachValues(op, field string, arg any, key string) ([]any, error) {
+	m, ok := arg.(map[string]any)
+	if !ok || len(m) != 1 {
+		return []any{arg}, nil
+	}
+	v, ok := m[key]
+	if !ok {
+		return []any{arg}, nil
+	}
+	vals, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("operator %s: %s for %s expects an array", op, key, field)
+	}
+	return vals, nil
+}
+
+// canonicalSet encodes values as a JSON array of their distinct minified JSON texts, the
+// form canonicalElem produces for stored elements.
+func canonicalSet(vals []any) string {
+	seen := map[string]bool{}
+	out := make([]string, 0, len(vals))
+	for _, v := range vals {
+		s := jsonText(v)
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	b, _ := json.Marshal(out)
+	return string(b)
+}
+
+// jsonText encodes v as minified JSON without HTML escaping, matching SQLite's json_quote.
+func jsonText(v any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// number binds whole numbers as integers so SQLite does not store 3 as 3.0.
+func number(n float64) any {
+	if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+		return int64(n)
+	}
+	return n
+}
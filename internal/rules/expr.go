@@ -0,0 +1,574 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Rule expressions are a small boolean language compiled to SQLite conditions:
+//
+//	auth.admin || resource.owner == auth.claims.sub
+//	incoming.status in ['draft', 'review'] && size(incoming.title) <= 120
+//
+// Variables: auth (the caller, see Identity), resource (the stored document, null on
+// create), incoming (the document being written, null on read and delete) and now (unix
+// seconds). Fields are read with dots or brackets; missing fields are null.
+// Operators, loosest first: ||, &&, == != < <= > >= in, + -, * / %, unary ! and -.
+// == and != are null-safe. Where a boolean is expected null counts as false, so
+// !resource.deleted holds for documents without deleted, and !, && and || always give
+// true or false. "x in y" tests membership in a list literal or a JSON array.
+// size(x) is the length of an array, object or string.
+
+type node interface{}
+
+type (
+	litNode  struct{ v any } // string, float64, bool or nil
+	listNode struct{ items []node }
+	pathNode struct {
+		root string
+		keys []any // string or int
+	}
+	unaryNode struct {
+		op string
+		x  node
+	}
+	binaryNode struct {
+		op   string
+		l, r node
+	}
+	callNode struct {
+		fn   string
+		args []node
+	}
+)
+
+var roots = map[string]bool{"auth": true, "resource": true, "incoming": true, "now": true}
+
+// parse turns an expression into its syntax tree.
+func parse(src string) (node, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at %d", t, t.pos)
+	}
+	return n, nil
+}
+
+// uses reports whether the expression reads the given root variable.
+func uses(n node, root string) bool {
+	switch t := n.(type) {
+	case pathNode:
+		return t.root == root
+	case listNode:
+		for _, it := range t.items {
+			if uses(it, root) {
+				return true
+			}
+		}
+	case unaryNode:
+		return uses(t.x, root)
+	case binaryNode:
+		return uses(t.l, root) || uses(t.r, root)
+	case callNode:
+		for _, a := range t.args {
+			if uses(a, root) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ---- lexer
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokNum
+	tokStr
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{tokNum, src[i:j], i})
+			i = j
+		case c == '\'' || c == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(src) && rune(src[j]) != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				sb.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			toks = append(toks, token{tokStr, sb.String(), i})
+			i = j + 1
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, src[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, cand := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ".", ","} {
+				if strings.HasPrefix(src[i:], cand) {
+					op = cand
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+			toks = append(toks, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(toks, token{tokEOF, "", len(src)}), nil
+}
+
+// ---- parser (recursive descent, one function per precedence level)
+
+type parser struct {
+	toks []token
+	i    int
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token { t := p.toks[p.i]; p.i++; return t }
+
+func (p *parser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	for _, op := range ops {
+		if (t.kind == tokOp || t.kind == tokIdent) && t.text == op {
+			p.i++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		t := p.peek()
+		return fmt.Errorf("expected %q at %d, found %s", op, t.pos, t)
+	}
+	return nil
+}
+
+func (p *parser) binary(next func() (node, error), ops ...string) (node, error) {
+	l, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return l, nil
+		}
+		r, err := next()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNode{op: op, l: l, r: r}
+	}
+}
+
+func (p *parser) or() (node, error)  { return p.binary(p.and, "||") }
+func (p *parser) and() (node, error) { return p.binary(p.cmp, "&&") }
+func (p *parser) add() (node, error) { return p.binary(p.mul, "+", "-") }
+func (p *parser) mul() (node, error) { return p.binary(p.unary, "*", "/", "%") }
+
+// cmp does not chain: a == b == c is a syntax error rather than a surprise.
+func (p *parser) cmp() (node, error) {
+	l, err := p.add()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<=", ">=", "<", ">", "in")
+	if !ok {
+		return l, nil
+	}
+	r, err := p.add()
+	if err != nil {
+		return nil, err
+	}
+	return binaryNode{op: op, l: l, r: r}, nil
+}
+
+func (p *parser) unary() (node, error) {
+	if op, ok := p.accept("!", "-"); ok {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{op: op, x: x}, nil
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (node, error) {
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("."); ok {
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, fmt.Errorf("expected a field name at %d", t.pos)
+			}
+			if n, err = member(n, t.text, t.pos); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if _, ok := p.accept("["); ok {
+			t := p.next()
+			var key any
+			switch t.kind {
+			case tokStr:
+				key = t.text
+			case tokNum:
+				idx, err := strconv.Atoi(t.text)
+				if err != nil || idx < 0 {
+					return nil, fmt.Errorf("invalid index %q at %d", t.text, t.pos)
+				}
+				key = idx
+			default:
+				return nil, fmt.Errorf("expected a string or index at %d", t.pos)
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			if n, err = member(n, key, t.pos); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return n, nil
+	}
+}
+
+func member(n node, key any, pos int) (node, error) {
+	pn, ok := n.(pathNode)
+	if !ok || pn.root == "now" {
+		return nil, fmt.Errorf("field access on a value that is not a document at %d", pos)
+	}
+	pn.keys = append(append([]any{}, pn.keys...), key)
+	return pn, nil
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNum:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return litNode{f}, nil
+	case tokStr:
+		return litNode{t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return litNode{true}, nil
+		case "false":
+			return litNode{false}, nil
+		case "null":
+			return litNode{nil}, nil
+		}
+		if _, ok := p.accept("("); ok {
+			return p.call(t)
+		}
+		if !roots[t.text] {
+			return nil, fmt.Errorf("unknown variable %q at %d: expected auth, resource, incoming or now", t.text, t.pos)
+		}
+		return pathNode{root: t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			n, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			var items []node
+			if _, ok := p.accept("]"); ok {
+				return listNode{}, nil
+			}
+			for {
+				it, err := p.or()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, it)
+				if _, ok := p.accept("]"); ok {
+					return listNode{items: items}, nil
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at %d", t, t.pos)
+}
+
+func (p *parser) call(name token) (node, error) {
+	if name.text != "size" {
+		return nil, fmt.Errorf("unknown function %q at %d", name.text, name.pos)
+	}
+	arg, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return callNode{fn: name.text, args: []node{arg}}, nil
+}
+
+// ---- compiler
+
+// sqlExpr is a compiled sub-expression.
+type sqlExpr struct {
+	sql  string
+	args []any
+}
+
+// compiler turns a syntax tree into SQL. auth and now are bound as parameters; resource
+// and incoming read the "data" and "incoming" columns of the statement the SQL ends up in.
+type compiler struct {
+	auth map[string]any
+	now  int64
+}
+
+func (c *compiler) compile(n node) (sqlExpr, error) {
+	switch t := n.(type) {
+	case litNode:
+		return literal(t.v), nil
+	case listNode:
+		return sqlExpr{}, fmt.Errorf("lists are only allowed on the right of in")
+	case pathNode:
+		switch t.root {
+		case "now":
+			return sqlExpr{sql: "?", args: []any{c.now}}, nil
+		case "auth":
+			return literal(c.authValue(t.keys)), nil
+		}
+		col := column(t.root)
+		if len(t.keys) == 0 {
+			return sqlExpr{sql: col}, nil
+		}
+		return sqlExpr{sql: fmt.Sprintf("json_extract(%s, '%s')", col, jsonPath(t.keys))}, nil
+	case unaryNode:
+		x, err := c.compile(t.x)
+		if err != nil {
+			return sqlExpr{}, err
+		}
+		if t.op == "!" {
+			return sqlExpr{sql: "(NOT " + boolean(x) + ")", args: x.args}, nil
+		}
+		return sqlExpr{sql: "(-" + x.sql + ")", args: x.args}, nil
+	case binaryNode:
+		if t.op == "in" {
+			return c.in(t)
+		}
+		l, err := c.compile(t.l)
+		if err != nil {
+			return sqlExpr{}, err
+		}
+		r, err := c.compile(t.r)
+		if err != nil {
+			return sqlExpr{}, err
+		}
+		if t.op == "||" || t.op == "&&" {
+			op := map[string]string{"||": "OR", "&&": "AND"}[t.op]
+			return sqlExpr{sql: "(" + boolean(l) + " " + op + " " + boolean(r) + ")", args: append(l.args, r.args...)}, nil
+		}
+		op := map[string]string{"==": "IS", "!=": "IS NOT"}[t.op]
+		if op == "" {
+			op = t.op
+		}
+		return sqlExpr{sql: "(" + l.sql + " " + op + " " + r.sql + ")", args: append(l.args, r.args...)}, nil
+	case callNode:
+		return c.size(t.args[0])
+	}
+	return sqlExpr{}, fmt.Errorf("unsupported expression")
+}
+
+// boolean reads x as a condition, null being false.
+func boolean(x sqlExpr) string {
+	return "COALESCE(" + x.sql + ", 0)"
+}
+
+// in compiles membership: a list literal becomes IN (...), a field or auth value is read
+// as a JSON array with json_each.
+func (c *compiler) in(t binaryNode) (sqlExpr, error) {
+	l, err := c.compile(t.l)
+	if err != nil {
+		return sqlExpr{}, err
+	}
+	switch r := t.r.(type) {
+	case listNode:
+		if len(r.items) == 0 {
+			return sqlExpr{sql: "0"}, nil
+		}
+		parts := make([]string, 0, len(r.items))
+		args := l.args
+		for _, it := range r.items {
+			e, err := c.compile(it)
+			if err != nil {
+				return sqlExpr{}, err
+			}
+			parts = append(parts, e.sql)
+			args = append(args, e.args...)
+		}
+		return sqlExpr{sql: "(" + l.sql + " IN (" + strings.Join(parts, ", ") + "))", args: args}, nil
+	case pathNode:
+		switch r.root {
+		case "auth":
+			b, _ := json.Marshal(c.authValue(r.keys))
+			return sqlExpr{sql: "(" + l.sql + " IN (SELECT value FROM json_each(?)))", args: append(l.args, string(b))}, nil
+		case "resource", "incoming":
+			return sqlExpr{sql: fmt.Sprintf("(%s IN (SELECT value FROM json_each(%s, '%s')))", l.sql, column(r.root), jsonPath(r.keys)), args: l.args}, nil
+		}
+	}
+	return sqlExpr{}, fmt.Errorf("the right of in must be a list, a document field or an auth value")
+}
+
+// size compiles size(x) for fields, or computes it up front for auth values.
+func (c *compiler) size(arg node) (sqlExpr, error) {
+	pn, ok := arg.(pathNode)
+	if !ok || pn.root == "now" {
+		return sqlExpr{}, fmt.Errorf("size expects a document field or an auth value")
+	}
+	if pn.root == "auth" {
+		switch v := c.authValue(pn.keys).(type) {
+		case string:
+			return literal(float64(len([]rune(v)))), nil
+		case []any:
+			return literal(float64(len(v))), nil
+		case map[string]any:
+			return literal(float64(len(v))), nil
+		}
+		return literal(nil), nil
+	}
+	col, p := column(pn.root), jsonPath(pn.keys)
+	return sqlExpr{sql: fmt.Sprintf(`(CASE json_type(%[1]s, '%[2]s') WHEN 'array' THEN json_array_length(%[1]s, '%[2]s')
+		WHEN 'object' THEN (SELECT COUNT(*) FROM json_each(%[1]s, '%[2]s'))
+		WHEN 'text' THEN length(json_extract(%[1]s, '%[2]s')) END)`, col, p)}, nil
+}
+
+// authValue walks the identity; anything missing is null.
+func (c *compiler) authValue(keys []any) any {
+	var cur any = c.auth
+	for _, k := range keys {
+		switch kk := k.(type) {
+		case string:
+			m, ok := cur.(map[string]any)
+			if !ok {
+				return nil
+			}
+			cur = m[kk]
+		case int:
+			a, ok := cur.([]any)
+			if !ok || kk >= len(a) {
+				return nil
+			}
+			cur = a[kk]
+		}
+	}
+	return cur
+}
+
+// literal binds a constant. Booleans become 1/0 like JSON booleans read by json_extract;
+// lists and objects are bound as JSON text.
+func literal(v any) sqlExpr {
+	switch t := v.(type) {
+	case nil:
+		return sqlExpr{sql: "NULL"}
+	case bool:
+		if t {
+			return sqlExpr{sql: "1"}
+		}
+		return sqlExpr{sql: "0"}
+	case float64:
+		if t == math.Trunc(t) && math.Abs(t) < 1<<53 {
+			return sqlExpr{sql: "?", args: []any{int64(t)}}
+		}
+		return sqlExpr{sql: "?", args: []any{t}}
+	case string:
+		return sqlExpr{sql: "?", args: []any{t}}
+	}
+	b, _ := json.Marshal(v)
+	return sqlExpr{sql: "?", args: []any{string(b)}}
+}
+
+func column(root string) string {
+	if root == "resource" {
+		return "data"
+	}
+	return "incoming"
+}
+
+// jsonPath renders keys as a quoted SQLite JSON path literal, e.g. $.user."first name"[0].
+func jsonPath(keys []any) string {
+	var sb strings.Builder
+	sb.WriteString("$")
+	for _, k := range keys {
+		switch kk := k.(type) {
+		case int:
+			fmt.Fprintf(&sb, "[%d]", kk)
+		case string:
+			sb.WriteString(`."` + strings.ReplaceAll(kk, `"`, `\"`) + `"`)
+		}
+	}
+	return strings.ReplaceAll(sb.String(), "'", "''")
+}
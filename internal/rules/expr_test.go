@@ -0,0 +1,272 @@
+package rules
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// evalCase is an expression evaluated for op against the documents and identity.
+type evalCase struct {
+	op, expr           string
+	id                 Identity
+	resource, incoming map[string]any
+	want               bool
+}
+
+func runEval(t *testing.T, db *sql.DB, tests []evalCase) {
+	t.Helper()
+	for _, tt := range tests {
+		op := tt.op
+		if op == "" {
+			op = Read
+		}
+		got, err := Test(db, op, tt.expr, tt.id, tt.resource, tt.incoming)
+		if err != nil {
+			t.Errorf("%s %q: %v", op, tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s %q on %v / %v: got %v, want %v", op, tt.expr, tt.resource, tt.incoming, got, tt.want)
+		}
+	}
+}
+
+func TestNullIsFalse(t *testing.T) {
+	db := openDB(t)
+	none := map[string]any{"t": "b"}
+	runEval(t, db, []evalCase{
+		{expr: "!resource.deleted", resource: none, want: true},
+		{expr: "!resource.deleted", resource: map[string]any{"deleted": nil}, want: true},
+		{expr: "!resource.deleted", resource: map[string]any{"deleted": true}, want: false},
+		{expr: "!resource.deleted", resource: map[string]any{"deleted": false}, want: true},
+		{expr: "resource.public", resource: none, want: false},
+		{expr: "!!resource.public", resource: none, want: false},
+		{expr: "!(resource.public || resource.shared)", resource: none, want: true},
+		{expr: "!(resource.public && true)", resource: none, want: true},
+		{expr: "(resource.public || false) == false", resource: none, want: true},
+		{expr: "!(resource.n > 3)", resource: none, want: true},
+		{expr: "resource.public || resource.t == 'b'", resource: none, want: true},
+		{expr: "!resource.deleted", want: true},
+	})
+}
+
+func TestParse(t *testing.T) {
+	path := func(root string, keys ...any) pathNode { return pathNode{root: root, keys: keys} }
+	tests := []struct {
+		src  string
+		want node
+	}{
+		{"true", litNode{true}},
+		{"null", litNode{nil}},
+		{"1.5", litNode{1.5}},
+		{`'it\'s'`, litNode{"it's"}},
+		{`"a\"b"`, litNode{`a"b`}},
+		{"now", path("now")},
+		{`resource.a["b c"][0]`, path("resource", "a", "b c", 0)},
+		{"auth.claims.sub", path("auth", "claims", "sub")},
+		{"resource.a || resource.b && resource.c",
+			binaryNode{op: "||", l: path("resource", "a"), r: binaryNode{op: "&&", l: path("resource", "b"), r: path("resource", "c")}}},
+		{"-1 + 2 * 3",
+			binaryNode{op: "+", l: unaryNode{op: "-", x: litNode{1.0}}, r: binaryNode{op: "*", l: litNode{2.0}, r: litNode{3.0}}}},
+		{"!resource.a == false",
+			binaryNode{op: "==", l: unaryNode{op: "!", x: path("resource", "a")}, r: litNode{false}}},
+		{"(resource.a || resource.b) && true",
+			binaryNode{op: "&&", l: binaryNode{op: "||", l: path("resource", "a"), r: path("resource", "b")}, r: litNode{true}}},
+		{"resource.s in ['a', 'b']",
+			binaryNode{op: "in", l: path("resource", "s"), r: listNode{items: []node{litNode{"a"}, litNode{"b"}}}}},
+		{"resource.s in []", binaryNode{op: "in", l: path("resource", "s"), r: listNode{}}},
+		{"size(incoming.title) <= 120",
+			binaryNode{op: "<=", l: callNode{fn: "size", args: []node{path("incoming", "title")}}, r: litNode{120.0}}},
+	}
+	for _, tt := range tests {
+		got, err := parse(tt.src)
+		if err != nil {
+			t.Errorf("parse(%q): %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parse(%q) = %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"owner",
+		"resource.",
+		"resource.a ==",
+		"resource.a == 1 == 2",
+		"'unterminated",
+		"resource.a # 1",
+		"(resource.a",
+		"[1, 2",
+		"resource[-1]",
+		"resource[1.5]",
+		"resource[true]",
+		"now.x",
+		"1.2.3",
+		"len(resource.a)",
+		"size(resource.a, resource.b)",
+		"resource.a resource.b",
+	}
+	for _, src := range tests {
+		if n, err := parse(src); err == nil {
+			t.Errorf("parse(%q) = %#v, want an error", src, n)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	c := &compiler{
+		auth: map[string]any{"admin": true, "claims": map[string]any{"sub": "u1", "n": 3.0, "groups": []any{"a", "b"}}},
+		now:  1700000000,
+	}
+	tests := []struct {
+		src  string
+		sql  string
+		args []any
+	}{
+		{"resource.owner == auth.claims.sub", `(json_extract(data, '$."owner"') IS ?)`, []any{"u1"}},
+		{"incoming.n != auth.claims.n", `(json_extract(incoming, '$."n"') IS NOT ?)`, []any{int64(3)}},
+		{"auth.admin", "1", nil},
+		{"auth.claims.missing == null", "(NULL IS NULL)", nil},
+		{"resource.at < now", `(json_extract(data, '$."at"') < ?)`, []any{int64(1700000000)}},
+		{"resource.x > 1.5", `(json_extract(data, '$."x"') > ?)`, []any{1.5}},
+		{"!resource.deleted", `(NOT COALESCE(json_extract(data, '$."deleted"'), 0))`, nil},
+		{"resource.a || auth.admin", `(COALESCE(json_extract(data, '$."a"'), 0) OR COALESCE(1, 0))`, nil},
+		{"resource", "data", nil},
+		{"auth.claims.groups == resource.g", `(? IS json_extract(data, '$."g"'))`, []any{`["a","b"]`}},
+		{"resource.s in []", "0", nil},
+		{"resource.s in ['a', auth.claims.sub]", `(json_extract(data, '$."s"') IN (?, ?))`, []any{"a", "u1"}},
+		{"resource.s in auth.claims.groups", `(json_extract(data, '$."s"') IN (SELECT value FROM json_each(?)))`, []any{`["a","b"]`}},
+		{"auth.claims.sub in resource.members", `(? IN (SELECT value FROM json_each(data, '$."members"')))`, []any{"u1"}},
+		{"size(auth.claims.groups) == 2", "(? IS ?)", []any{int64(2), int64(2)}},
+	}
+	for _, tt := range tests {
+		n, err := parse(tt.src)
+		if err != nil {
+			t.Errorf("parse(%q): %v", tt.src, err)
+			continue
+		}
+		got, err := c.compile(n)
+		if err != nil {
+			t.Errorf("compile(%q): %v", tt.src, err)
+			continue
+		}
+		if got.sql != tt.sql || !reflect.DeepEqual(got.args, tt.args) {
+			t.Errorf("compile(%q) = %s %v, want %s %v", tt.src, got.sql, got.args, tt.sql, tt.args)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	c := &compiler{auth: map[string]any{}}
+	tests := []string{
+		"[1] == resource.a",
+		"resource.a in 'abc'",
+		"resource.a in now",
+		"size(1)",
+		"size(now)",
+		"size('abc')",
+	}
+	for _, src := range tests {
+		n, err := parse(src)
+		if err != nil {
+			t.Errorf("parse(%q): %v", src, err)
+			continue
+		}
+		if got, err := c.compile(n); err == nil {
+			t.Errorf("compile(%q) = %s, want an error", src, got.sql)
+		}
+	}
+}
+
+func TestIn(t *testing.T) {
+	db := openDB(t)
+	doc := map[string]any{"s": "b", "n": 2, "tags": []any{"x", "y", 3}, "members": []any{"u1", "u2"}, "allowed": []any{"draft"}}
+	id := Identity{Claims: map[string]any{"sub": "u1", "groups": []any{"a", "b"}, "orgs": "b"}}
+	runEval(t, db, []evalCase{
+		{expr: "resource.s in ['a', 'b']", resource: doc, want: true},
+		{expr: "resource.s in ['c']", resource: doc, want: false},
+		{expr: "resource.s in []", resource: doc, want: false},
+		{expr: "resource.n in [1, 2]", resource: doc, want: true},
+		{expr: "'x' in resource.tags", resource: doc, want: true},
+		{expr: "3 in resource.tags", resource: doc, want: true},
+		{expr: "'z' in resource.tags", resource: doc, want: false},
+		{expr: "'x' in resource.missing", resource: doc, want: false},
+		{expr: "!('x' in resource.missing)", resource: doc, want: true},
+		{expr: "resource.s in auth.claims.groups", id: id, resource: doc, want: true},
+		{expr: "resource.s in auth.claims.missing", id: id, resource: doc, want: false},
+		{expr: "auth.claims.sub in resource.members", id: id, resource: doc, want: true},
+		{expr: "auth.claims.sub in resource.tags", id: id, resource: doc, want: false},
+		{op: Update, expr: "incoming.status in resource.allowed", resource: doc, incoming: map[string]any{"status": "draft"}, want: true},
+		{op: Update, expr: "incoming.status in resource.allowed", resource: doc, incoming: map[string]any{"status": "live"}, want: false},
+	})
+}
+
+func TestSize(t *testing.T) {
+	db := openDB(t)
+	doc := map[string]any{"tags": []any{"a", "b", "c"}, "obj": map[string]any{"k": 1, "l": 2}, "title": "héllo", "n": 5, "empty": []any{}}
+	id := Identity{Claims: map[string]any{"groups": []any{"a", "b"}, "name": "Zoë", "m": map[string]any{"a": 1}, "n": 1}}
+	runEval(t, db, []evalCase{
+		{expr: "size(resource.tags) == 3", resource: doc, want: true},
+		{expr: "size(resource.obj) == 2", resource: doc, want: true},
+		{expr: "size(resource.title) == 5", resource: doc, want: true},
+		{expr: "size(resource.empty) == 0", resource: doc, want: true},
+		{expr: "size(resource.n) == null", resource: doc, want: true},
+		{expr: "size(resource.missing) == null", resource: doc, want: true},
+		{expr: "size(resource.tags) > 2 && size(resource.title) <= 5", resource: doc, want: true},
+		{expr: "size(auth.claims.groups) == 2", id: id, want: true},
+		{expr: "size(auth.claims.name) == 3", id: id, want: true},
+		{expr: "size(auth.claims.m) == 1", id: id, want: true},
+		{expr: "size(auth.claims.n) == null", id: id, want: true},
+		{expr: "size(auth.claims.missing) == null", id: id, want: true},
+		{op: Create, expr: "size(incoming.title) <= 3", incoming: doc, want: false},
+	})
+}
+
+func TestJSONPath(t *testing.T) {
+	tests := []struct {
+		keys []any
+		want string
+	}{
+		{nil, "$"},
+		{[]any{"a", "b"}, `$."a"."b"`},
+		{[]any{"tags", 0}, `$."tags"[0]`},
+		{[]any{"x.y"}, `$."x.y"`},
+		{[]any{`b"c`}, `$."b\"c"`},
+		{[]any{"it's"}, `$."it''s"`},
+		{[]any{"a", 1, "b c"}, `$."a"[1]."b c"`},
+	}
+	for _, tt := range tests {
+		if got := jsonPath(tt.keys); got != tt.want {
+			t.Errorf("jsonPath(%v) = %s, want %s", tt.keys, got, tt.want)
+		}
+	}
+
+	// the escaped paths must read the intended fields
+	db := openDB(t)
+	doc := map[string]any{"x.y": 1, "it's": 2, "a b": 3, "x": map[string]any{"y": 4}, "l": []any{map[string]any{"k": 5}}}
+	runEval(t, db, []evalCase{
+		{expr: `resource["x.y"] == 1`, resource: doc, want: true},
+		{expr: `resource.x.y == 4`, resource: doc, want: true},
+		{expr: `resource["it's"] == 2`, resource: doc, want: true},
+		{expr: `resource['a b'] == 3`, resource: doc, want: true},
+		{expr: `resource.l[0].k == 5`, resource: doc, want: true},
+		{expr: `resource.l[1].k == null`, resource: doc, want: true},
+	})
+}
@@ -0,0 +1,311 @@
+// Package rules implements document-level access rules: per collection expressions that
+// decide, document by document, who may read, create, update and delete.
+package rules
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"microapi/internal/auth"
+	"microapi/internal/middleware"
+	"microapi/internal/models"
+	"microapi/internal/query"
+)
+
+// Operations a rule can be written for.
+const (
+	Read   = "read"
+	Create = "create"
+	Update = "update"
+	Delete = "delete"
+)
+
+// ErrNotFound is returned when a collection has no rules.
+var ErrNotFound = errors.New("not found")
+
+// Rules holds one expression per operation (see expr.go for the language):
+//
+//	{"read": "resource.public || resource.owner == auth.claims.sub",
+//	 "create": "incoming.owner == auth.claims.sub",
+//	 "update": "resource.owner == auth.claims.sub && incoming.owner == resource.owner",
+//	 "delete": "auth.admin"}
+//
+// A collection without rules is open to everyone with access to it. Once rules are set,
+// an operation without an expression is denied.
+type Rules struct {
+	Read   string `json:"read,omitempty"`
+	Create string `json:"create,omitempty"`
+	Update string `json:"update,omitempty"`
+	Delete string `json:"delete,omitempty"`
+}
+
+func (r *Rules) expr(op string) string {
+	switch op {
+	case Read:
+		return r.Read
+	case Create:
+		return r.Create
+	case Update:
+		return r.Update
+	case Delete:
+		return r.Delete
+	}
+	return ""
+}
+
+// Validate parses every expression and checks it only uses the documents its operation
+// has: read and delete rules cannot see incoming, create rules cannot see resource.
+func (r *Rules) Validate() error {
+	for _, op := range []string{Read, Create, Update, Delete} {
+		if _, err := parseFor(op, r.expr(op)); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	return nil
+}
+
+func parseFor(op, src string) (node, error) {
+	if src == "" {
+		return nil, nil
+	}
+	n, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if (op == Read || op == Delete) && uses(n, "incoming") {
+		return nil, fmt.Errorf("incoming is not available in %s rules", op)
+	}
+	if op == Create && uses(n, "resource") {
+		return nil, fmt.Errorf("resource is not available in create rules")
+	}
+	// compile once with an empty identity to catch misplaced lists and size() arguments
+	if _, err := (&compiler{auth: map[string]any{}}).compile(n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Put validates and stores the rules of a collection.
+func Put(db *sql.DB, set, collection string, rules Rules) error {
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	b, _ := json.Marshal(rules)
+	_, err := db.Exec(`INSERT INTO rules (set_name, collection_name, rules, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(set_name, collection_name) DO UPDATE SET rules = excluded.rules, updated_at = excluded.updated_at`,
+		set, collection, string(b), time.Now().Unix())
+	return err
+}
+
+// Get returns the rules of a collection, or ErrNotFound.
+func Get(db *sql.DB, set, collection string) (*Rules, error) {
+	var raw string
+	err := db.QueryRow(`SELECT rules FROM rules WHERE set_name = ? AND collection_name = ?`, set, collection).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules Rules
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// Remove deletes the rules of a collection, opening it up again.
+func Remove(db *sql.DB, set, collection string) error {
+	res, err := db.Exec(`DELETE FROM rules WHERE set_name = ? AND collection_name = ?`, set, collection)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Identity is what rules see as auth.
+type Identity struct {
+	Admin  bool           `json:"admin"`            // admin access to the collection
+	Key    string         `json:"key,omitempty"`    // API key id
+	Claims map[string]any `json:"claims,omitempty"` // JWT claims
+	Cert   string         `json:"cert,omitempty"`   // client certificate identity
+}
+
+// IdentityOf describes p to the rules of a collection.
+func IdentityOf(p *auth.Principal, set, collection string) Identity {
+	if p == nil {
+		return Identity{}
+	}
+	return Identity{Admin: p.Can(set, collection, auth.Admin), Key: p.KeyID, Claims: p.Claims, Cert: p.Cert}
+}
+
+func (id Identity) value() map[string]any {
+	// round-trip through JSON so numbers and nested claims look like document values
+	b, _ := json.Marshal(id)
+	var m map[string]any
+	_ = json.Unmarshal(b, &m)
+	return m
+}
+
+// Querier runs the single-row statements used to evaluate write rules; *sql.DB and *sql.Tx
+// both qualify.
+type Querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Checker evaluates the rules of one collection for one caller. A nil Checker, or one for
+// a collection without rules, allows everything.
+type Checker struct {
+	rules *Rules
+	c     *compiler
+}
+
+// NewChecker loads the rules of a collection for the caller id.
+func NewChecker(db *sql.DB, set, collection string, id Identity) (*Checker, error) {
+	rules, err := Get(db, set, collection)
+	if err == ErrNotFound {
+		return &Checker{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Checker{rules: rules, c: &compiler{auth: id.value(), now: time.Now().Unix()}}, nil
+}
+
+// Active reports whether the collection has rules at all.
+func (ck *Checker) Active() bool { return ck != nil && ck.rules != nil }
+
+// compile returns the SQL condition for op; a missing expression compiles to false.
+func (ck *Checker) compile(op string) (sqlExpr, error) {
+	n, err := parseFor(op, ck.rules.expr(op))
+	if err != nil {
+		return sqlExpr{}, err
+	}
+	if n == nil {
+		return sqlExpr{sql: "0"}, nil
+	}
+	return ck.c.compile(n)
+}
+
+// Filter returns the read or delete rule as conditions on the data column of a set table,
+// or nil when there are no rules.
+func (ck *Checker) Filter(op string) (*query.ParsedWhere, error) {
+	if !ck.Active() {
+		return nil, nil
+	}
+	if op != Read && op != Delete {
+		return nil, fmt.Errorf("only read and delete rules can filter")
+	}
+	e, err := ck.compile(op)
+	if err != nil {
+		return nil, err
+	}
+	return &query.ParsedWhere{Conds: []query.Condition{{SQL: e.sql, Args: e.args}}}, nil
+}
+
+// Allow evaluates op against the stored document (nil on create) and the document about
+// to be written (nil on delete).
+func (ck *Checker) Allow(q Querier, op string, resource, incoming map[string]any) (bool, error) {
+	if !ck.Active() {
+		return true, nil
+	}
+	e, err := ck.compile(op)
+	if err != nil {
+		return false, err
+	}
+	return eval(q, e, resource, incoming)
+}
+
+// Check is Allow reporting a denial as a 403 *middleware.HTTPError.
+func (ck *Checker) Check(q Querier, op string, resource, incoming map[string]any) error {
+	ok, err := ck.Allow(q, op, resource, incoming)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return Denied(op)
+	}
+	return nil
+}
+
+// Denied is the error reported when a rule rejects op.
+func Denied(op string) *middleware.HTTPError {
+	return &middleware.HTTPError{Code: http.StatusForbidden, Message: op + " denied by the collection's rules"}
+}
+
+// Test evaluates a single expression for op without storing anything, for dry runs.
+func Test(q Querier, op, expr string, id Identity, resource, incoming map[string]any) (bool, error) {
+	rules := &Rules{}
+	switch op {
+	case Read:
+		rules.Read = expr
+	case Create:
+		rules.Create = expr
+	case Update:
+		rules.Update = expr
+	case Delete:
+		rules.Delete = expr
+	default:
+		return false, fmt.Errorf("op must be one of read, create, update, delete")
+	}
+	ck := &Checker{rules: rules, c: &compiler{auth: id.value(), now: time.Now().Unix()}}
+	return ck.Allow(q, op, resource, incoming)
+}
+
+// eval runs a compiled condition against the two documents, bound as the data and
+// incoming columns the SQL reads.
+func eval(q Querier, e sqlExpr, resource, incoming map[string]any) (bool, error) {
+	var ok bool
+	args := append([]any{jsonOrNil(resource), jsonOrNil(incoming)}, e.args...)
+	err := q.QueryRow("SELECT EXISTS(SELECT 1 FROM (SELECT ? AS data, ? AS incoming) WHERE "+e.sql+")", args...).Scan(&ok)
+	return ok, err
+}
+
+func jsonOrNil(doc map[string]any) any {
+	if doc == nil {
+		return nil
+	}
+	b, _ := json.Marshal(doc)
+	return string(b)
+}
+
+type ctxKey struct{}
+
+// FromContext returns the checker stored by Load, or nil.
+func FromContext(ctx context.Context) *Checker {
+	ck, _ := ctx.Value(ctxKey{}).(*Checker)
+	return ck
+}
+
+// Load prepares the rules of the {set}/{collection} route for the request's principal and
+// leaves them in the context for the handler (see FromContext).
+func Load(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			set, collection := chi.URLParam(r, "set"), chi.URLParam(r, "collection")
+			if err := middleware.ValidateNames(set, collection); err != nil {
+				// let the handler report the bad name
+				next.ServeHTTP(w, r)
+				return
+			}
+			ck, err := NewChecker(db, set, collection, IdentityOf(auth.FromContext(r.Context()), set, collection))
+			if err != nil {
+				slog.Error("rules_error", slog.String("error", err.Error()))
+				middleware.WriteJSON(w, http.StatusInternalServerError, false, nil, models.Ptr("internal error"))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, ck)))
+		})
+	}
+}
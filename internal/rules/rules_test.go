@@ -0,0 +1,90 @@
+package rules
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"microapi/internal/middleware"
+)
+
+func TestParseFor(t *testing.T) {
+	tests := []struct {
+		op, src string
+		err     string // substring of the error, "" for none
+	}{
+		{Read, "", ""},
+		{Read, "resource.public || auth.admin", ""},
+		{Read, "incoming.x == 1", "incoming is not available in read rules"},
+		{Delete, "resource.owner == auth.claims.sub", ""},
+		{Delete, "size(incoming.tags) > 0", "incoming is not available in delete rules"},
+		{Create, "incoming.owner == auth.claims.sub", ""},
+		{Create, "resource.owner == null", "resource is not available in create rules"},
+		{Create, "'a' in resource.tags", "resource is not available in create rules"},
+		{Update, "resource.owner == auth.claims.sub && incoming.owner == resource.owner", ""},
+		{Update, "now > 0", ""},
+		{Read, "[1, 2]", "lists are only allowed on the right of in"},
+		{Read, "size('x') > 0", "size expects"},
+		{Read, "resource.a ==", "unexpected"},
+	}
+	for _, tt := range tests {
+		n, err := parseFor(tt.op, tt.src)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("parseFor(%s, %q): %v", tt.op, tt.src, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("parseFor(%s, %q) = %v, want an error containing %q", tt.op, tt.src, err, tt.err)
+		case tt.err == "" && (n == nil) != (tt.src == ""):
+			t.Errorf("parseFor(%s, %q) = %#v", tt.op, tt.src, n)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	r := Rules{Read: "resource.public", Create: "resource.x == 1"}
+	err := r.Validate()
+	if err == nil || !strings.HasPrefix(err.Error(), "create: ") {
+		t.Errorf("Validate() = %v, want a create error", err)
+	}
+	if err := (&Rules{Read: "resource.public", Update: "incoming.a == resource.a"}).Validate(); err != nil {
+		t.Errorf("Validate(): %v", err)
+	}
+}
+
+func TestChecker(t *testing.T) {
+	db := openDB(t)
+	doc := map[string]any{"owner": "u1"}
+
+	// without rules everything is allowed and nothing filtered
+	var none *Checker
+	if ok, err := none.Allow(db, Delete, doc, nil); !ok || err != nil {
+		t.Errorf("nil checker: Allow = %v, %v", ok, err)
+	}
+	if pw, err := (&Checker{}).Filter(Read); pw != nil || err != nil {
+		t.Errorf("checker without rules: Filter = %v, %v", pw, err)
+	}
+
+	// with rules, an operation without an expression is denied
+	ck := &Checker{rules: &Rules{Read: "resource.owner == auth.claims.sub"}, c: &compiler{auth: Identity{Claims: map[string]any{"sub": "u1"}}.value()}}
+	if ok, err := ck.Allow(db, Read, doc, nil); !ok || err != nil {
+		t.Errorf("read: Allow = %v, %v", ok, err)
+	}
+	if ok, err := ck.Allow(db, Delete, doc, nil); ok || err != nil {
+		t.Errorf("delete without a rule: Allow = %v, %v", ok, err)
+	}
+	var he *middleware.HTTPError
+	if err := ck.Check(db, Delete, doc, nil); !errors.As(err, &he) || he.Code != http.StatusForbidden {
+		t.Errorf("delete without a rule: Check = %v", err)
+	}
+	pw, err := ck.Filter(Delete)
+	if err != nil || len(pw.Conds) != 1 || pw.Conds[0].SQL != "0" {
+		t.Errorf("delete without a rule: Filter = %+v, %v", pw, err)
+	}
+	if _, err := ck.Filter(Update); err == nil {
+		t.Errorf("Filter(update) succeeded")
+	}
+	if _, err := Test(db, "list", "true", Identity{}, nil, nil); err == nil {
+		t.Errorf("Test with an unknown op succeeded")
+	}
+}
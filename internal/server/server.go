@@ -2,7 +2,9 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
+	"errors"
 	"log/slog"
 	"net/http"
 	"regexp"
@@ -11,16 +13,52 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"microapi/internal/auth"
 	"microapi/internal/config"
+	"microapi/internal/database"
 	"microapi/internal/handlers"
 	mw "microapi/internal/middleware"
+	"microapi/internal/rules"
+	"microapi/internal/webhooks"
 )
 
 type Server struct {
 	*chi.Mux
+	stopWorkers context.CancelFunc
+	tls         *tlsFiles
+	certs       *auth.CertIdentities
 }
 
-func New(cfg *config.Config, db *sql.DB, version string) *Server {
+func New(cfg *config.Config, db *sql.DB, version string) (*Server, error) {
+	jwtv, err := auth.NewJWTVerifier(auth.JWTConfig{Secret: cfg.JWTSecret, JWKSFile: cfg.JWTJWKSFile, Issuer: cfg.JWTIssuer, Audience: cfg.JWTAudience})
+	if err != nil {
+		return nil, err
+	}
+	tlsf, err := newTLSFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := auth.LoadCertIdentities(cfg.TLSClientIdentitiesFile)
+	if err != nil {
+		return nil, err
+	}
+	// one limiter per route class, each with its own budget per client
+	var limits [3]func(http.Handler) http.Handler
+	for i, spec := range []string{cfg.RateLimitRead, cfg.RateLimitWrite, cfg.RateLimitAdmin} {
+		rate, err := mw.ParseRate(spec)
+		if err != nil {
+			return nil, err
+		}
+		limits[i] = mw.NewRateLimiter(rate).Limit(auth.ClientKey)
+	}
+	limitRead, limitWrite, limitAdmin := limits[0], limits[1], limits[2]
+	authFailures, err := mw.ParseRate(cfg.RateLimitAuthFailures)
+	if err != nil {
+		return nil, err
+	}
+	// runs before Authenticate, where ClientKey is the caller's IP address
+	limitAuth := mw.NewRateLimiter(authFailures).LimitFailures(auth.ClientKey, http.StatusUnauthorized)
+
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
@@ -38,45 +76,127 @@ func New(cfg *config.Config, db *sql.DB, version string) *Server {
 	// Register API routes
 	h := handlers.New(db, cfg)
 
+	// Background webhook delivery and changelog pruning
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	go webhooks.NewWorker(db).Run(workerCtx)
+	go database.RunChangelogPruner(workerCtx, db, cfg.ChangelogRetention, cfg.ChangelogMaxRows)
+
 	// Dashboard fallback at root
 	r.Get("/", h.Dashboard)
 	r.Get("/style.css", h.DashboardCSS)
 	r.Get("/favicon.ico", h.DashboardFavicon)
 	r.Get("/logo.svg", h.DashboardLogo)
 
-	// MCP routes: define before dynamic param routes to avoid capture
-	r.Get("/mcp", h.MCPDiscovery)
-	r.Post("/mcp", h.MCPCall)
+	r.Group(func(r chi.Router) {
+		// Everything but health and the dashboard requires an API key (or a JWT or client
+		// certificate, if configured) when ADMIN_KEY, JWT verification or client identities
+		// are set up.
+		// Routes below check access on their {set}/{collection}; the rest check it per set
+		// inside the handler.
+		// Addresses that keep failing authentication are refused before their next
+		// credential is looked up.
+		r.Use(limitAuth)
+		r.Use(auth.Authenticate(db, cfg.AdminKey, jwtv, certs))
+		read := chi.Chain(limitRead, auth.Require(auth.Read)).Handler
+		admin := chi.Chain(limitAdmin, auth.Require(auth.Admin)).Handler
+		// document routes also admit JWT callers, within the collection's claim rules, and
+		// apply the collection's document rules to everyone
+		docRules := rules.Load(db)
+		readDocs := chi.Chain(limitRead, auth.Guard(db, auth.Read), docRules).Handler
+		writeDocs := chi.Chain(limitWrite, auth.Guard(db, auth.Write), docRules).Handler
+
+		// MCP routes: define before dynamic param routes to avoid capture
+		r.With(limitRead).Get("/mcp", h.MCPDiscovery)
+		r.With(limitWrite).Post("/mcp", h.MCPCall)
 
-	r.Route("/", func(r chi.Router) {
 		// Index management routes (placed before {id} to avoid capture)
-		r.Post("/{set}/{collection}/_index", h.CreateIndex)
-		r.Get("/{set}/{collection}/_indexes", h.ListIndexes)
-		r.Get("/{set}/{collection}/_index/{path}", h.GetIndexStatus)
-		r.Delete("/{set}/{collection}/_index/{path}", h.DeleteIndex)
+		r.With(admin).Post("/{set}/{collection}/_index", h.CreateIndex)
+		r.With(read).Get("/{set}/{collection}/_indexes", h.ListIndexes)
+		r.With(read).Get("/{set}/{collection}/_index/{path}", h.GetIndexStatus)
+		r.With(admin).Delete("/{set}/{collection}/_index/{path}", h.DeleteIndex)
+		// Full-text search index
+		r.With(admin).Put("/{set}/{collection}/_fulltext", h.PutFullText)
+		r.With(read).Get("/{set}/{collection}/_fulltext", h.GetFullText)
+		r.With(admin).Delete("/{set}/{collection}/_fulltext", h.DeleteFullText)
 		// Schema management
-		r.Put("/{set}/{collection}/_schema", h.PutSchema)
-		r.Get("/{set}/{collection}/_info", h.GetCollectionInfo)
+		r.With(admin).Put("/{set}/{collection}/_schema", h.PutSchema)
+		r.With(read).Get("/{set}/{collection}/_info", h.GetCollectionInfo)
+		// Change feed (SSE)
+		r.With(readDocs).Get("/{set}/{collection}/_changes", h.Changes)
+		// Webhooks
+		r.With(admin).Post("/{set}/{collection}/_hooks", h.CreateHook)
+		r.With(admin).Get("/{set}/{collection}/_hooks", h.ListHooks)
+		r.With(admin).Get("/{set}/{collection}/_hooks/{hook}", h.GetHook)
+		r.With(admin).Delete("/{set}/{collection}/_hooks/{hook}", h.DeleteHook)
+		r.With(admin).Get("/{set}/{collection}/_hooks/{hook}/deliveries", h.ListHookDeliveries)
+		r.With(admin).Post("/{set}/{collection}/_hooks/{hook}/deliveries/{delivery}/retry", h.RetryHookDelivery)
+		// Aggregation
+		r.With(readDocs).Get("/{set}/{collection}/_aggregate", h.Aggregate)
+		// Nearest-neighbour search over a vector index
+		r.With(readDocs).Post("/{set}/{collection}/_knn", h.KNN)
+		// Claim rules for JWT callers
+		r.With(admin).Put("/{set}/{collection}/_claims", h.PutClaimRules)
+		r.With(admin).Get("/{set}/{collection}/_claims", h.GetClaimRules)
+		r.With(admin).Delete("/{set}/{collection}/_claims", h.DeleteClaimRules)
+		// Document rules
+		r.With(admin).Put("/{set}/{collection}/_rules", h.PutRules)
+		r.With(admin).Get("/{set}/{collection}/_rules", h.GetRules)
+		r.With(admin).Delete("/{set}/{collection}/_rules", h.DeleteRules)
+		r.With(admin).Post("/{set}/{collection}/_rules/test", h.TestRules)
 		// Document routes
-		r.Post("/{set}/{collection}", h.CreateDocument)
-		r.Get("/{set}/{collection}", h.QueryCollection)
-		r.Get("/{set}/{collection}/{id}", h.GetDocument)
-		r.Put("/{set}/{collection}/{id}", h.ReplaceDocument)
-		r.Patch("/{set}/{collection}/{id}", h.UpdateDocument)
-		r.Delete("/{set}/{collection}/{id}", h.DeleteDocument)
-		r.Delete("/{set}/{collection}", h.DeleteCollection)
+		r.With(writeDocs).Post("/{set}/{collection}/_bulk", h.BulkCreateDocuments)
+		r.With(writeDocs).Post("/{set}/{collection}", h.CreateDocument)
+		r.With(readDocs).Get("/{set}/{collection}", h.QueryCollection)
+		r.With(readDocs).Get("/{set}/{collection}/{id}", h.GetDocument)
+		r.With(writeDocs).Put("/{set}/{collection}/{id}", h.ReplaceDocument)
+		r.With(writeDocs).Patch("/{set}/{collection}/{id}", h.UpdateDocument)
+		r.With(writeDocs).Delete("/{set}/{collection}/{id}", h.DeleteDocument)
+		r.With(writeDocs).Patch("/{set}/{collection}", h.UpdateCollection)
+		r.With(admin, docRules).Delete("/{set}/{collection}", h.DeleteCollection)
 		// Set routes
-		r.Get("/{set}", h.GetSetStats)
-		r.Delete("/{set}", h.DeleteSet)
+		r.With(read).Get("/{set}", h.GetSetStats)
+		r.With(admin).Delete("/{set}", h.DeleteSet)
+		// Storage quotas
+		r.With(admin).Put("/{set}/_quota", h.PutQuota)
+		r.With(read).Get("/{set}/_quota", h.GetQuota)
+		r.With(admin).Delete("/{set}/_quota", h.DeleteQuota)
+		// API keys; without a {set} parameter admin means admin on every set
+		r.With(admin).Post("/_keys", h.CreateKey)
+		r.With(admin).Get("/_keys", h.ListKeys)
+		r.With(admin).Get("/_keys/{key}", h.GetKey)
+		r.With(admin).Delete("/_keys/{key}", h.RevokeKey)
 		// Utility
-		r.Get("/_sets", h.ListSets)
+		r.With(limitRead).Get("/_sets", h.ListSets)
+		r.With(limitWrite).Post("/_tx", h.Transaction)
+		r.With(limitRead).Get("/_ws", h.Live)
 	})
 
-	return &Server{Mux: r}
+	return &Server{Mux: r, stopWorkers: stopWorkers, tls: tlsf, certs: certs}, nil
+}
+
+// TLSConfig is the config to serve HTTPS with, or nil to serve plain HTTP.
+func (s *Server) TLSConfig() *tls.Config {
+	if s.tls == nil {
+		return nil
+	}
+	return s.tls.config()
+}
+
+// Reload rereads the TLS certificate, client CA bundle and client identities. Whatever
+// fails to load keeps its previous version.
+func (s *Server) Reload() error {
+	var errs []error
+	if s.tls != nil {
+		errs = append(errs, s.tls.reload())
+	}
+	if s.certs != nil {
+		errs = append(errs, s.certs.Reload())
+	}
+	return errors.Join(errs...)
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
-	// nothing significant to close here, but allow future hooks
+	s.stopWorkers()
 	slog.Info("shutdown server", slog.String("at", time.Now().Format(time.RFC3339)))
 	return nil
 }
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"microapi/internal/config"
+)
+
+// tlsFiles serves the certificate and client CA bundle of the config. Each handshake gets
+// the tls.Config built by the last successful load, so reload swaps certificates without
+// dropping connections.
+type tlsFiles struct {
+	cfg     *config.Config
+	current atomic.Pointer[tls.Config]
+}
+
+// newTLSFiles returns nil when the config has no certificate.
+func newTLSFiles(cfg *config.Config) (*tlsFiles, error) {
+	if cfg.TLSCertFile == "" {
+		return nil, nil
+	}
+	t := &tlsFiles{cfg: cfg}
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// reload reads the certificate, key and client CA bundle again. On error the ones loaded
+// before stay in use.
+func (t *tlsFiles) reload() error {
+	cert, err := tls.LoadX509KeyPair(t.cfg.TLSCertFile, t.cfg.TLSKeyFile)
+	if err != nil {
+		return fmt.Errorf("tls certificate: %w", err)
+	}
+	c := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if t.cfg.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(t.cfg.TLSClientCAFile)
+		if err != nil {
+			return fmt.Errorf("tls client CA: %w", err)
+		}
+		c.ClientCAs = x509.NewCertPool()
+		if !c.ClientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls client CA: no certificates found in %s", t.cfg.TLSClientCAFile)
+		}
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+		if t.cfg.TLSClientCertRequired {
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	t.current.Store(c)
+	return nil
+}
+
+// config is the listener's tls.Config; it hands every handshake the current one.
+func (t *tlsFiles) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return t.current.Load(), nil
+		},
+	}
+}
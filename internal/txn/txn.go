@@ -0,0 +1,408 @@
+// Package txn executes an ordered batch of document writes across sets and
+// collections inside a single SQLite transaction.
+package txn
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+
+	"microapi/internal/auth"
+	"microapi/internal/database"
+	"microapi/internal/middleware"
+	"microapi/internal/query"
+	"microapi/internal/rules"
+	"microapi/internal/validation"
+)
+
+// refPrefix marks a string that stands for the id of a document created earlier in the
+// batch, e.g. "$ref:order" after a create with "ref": "order".
+const refPrefix = "$ref:"
+
+// idRe restricts client-chosen document ids.
+var idRe = regexp.MustCompile(`^[a-zA-Z0-9_\-]{1,128}$`)
+
+// Operation is one write of a transaction.
+//
+//	create:  data, optional id and ref
+//	replace: id, data
+//	patch:   id, data as a shallow merge or update operators ($inc, $push, ...)
+//	delete:  id
+//
+// IfMatch, when set, requires the stored document to be at that version.
+type Operation struct {
+	Op         string         `json:"op"`
+	Set        string         `json:"set"`
+	Collection string         `json:"collection"`
+	ID         string         `json:"id,omitempty"`
+	Ref        string         `json:"ref,omitempty"`
+	Data       map[string]any `json:"data,omitempty"`
+	IfMatch    *int64         `json:"if_match,omitempty"`
+}
+
+// Result reports the document written by one operation.
+type Result struct {
+	Index      int    `json:"index"`
+	Op         string `json:"op"`
+	Set        string `json:"set"`
+	Collection string `json:"collection"`
+	ID         string `json:"id"`
+	Version    int64  `json:"version,omitempty"`
+}
+
+// Error is the failure of the operation at Index; Code is the HTTP status to report.
+type Error struct {
+	Index   int
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("operation %d: %s", e.Index, e.Message) }
+
+// Execute runs ops in order inside one transaction and rolls everything back on the
+// first failure, which is returned as an *Error. Each operation must pass the document
+// rules of its collection for p.
+func Execute(db *sql.DB, ops []Operation, p *auth.Principal) ([]Result, error) {
+	if len(ops) == 0 {
+		return nil, &Error{Index: -1, Code: http.StatusBadRequest, Message: "no operations provided"}
+	}
+	validators := map[string]*validation.Validator{}
+	checkers := map[string]*rules.Checker{}
+	refs := map[string]bool{}
+	// checks and DDL happen up front: set tables cannot be created inside the transaction
+	for i, op := range ops {
+		if err := checkOperation(op, refs); err != nil {
+			return nil, &Error{Index: i, Code: http.StatusBadRequest, Message: err.Error()}
+		}
+		if err := database.EnsureSetTable(db, op.Set); err != nil {
+			return nil, &Error{Index: i, Code: http.StatusInternalServerError, Message: err.Error()}
+		}
+		if op.Op == "create" {
+			if err := database.EnsureCollectionMetadata(db, op.Set, op.Collection); err != nil {
+				return nil, &Error{Index: i, Code: http.StatusInternalServerError, Message: err.Error()}
+			}
+		}
+		key := op.Set + "/" + op.Collection
+		if _, ok := validators[key]; !ok {
+			v, err := validation.NewValidator(db, op.Set, op.Collection)
+			if err != nil {
+				return nil, &Error{Index: i, Code: http.StatusInternalServerError, Message: err.Error()}
+			}
+			validators[key] = v
+			ck, err := rules.NewChecker(db, op.Set, op.Collection, rules.IdentityOf(p, op.Set, op.Collection))
+			if err != nil {
+				return nil, &Error{Index: i, Code: http.StatusInternalServerError, Message: err.Error()}
+			}
+			checkers[key] = ck
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, &Error{Index: -1, Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+	defer tx.Rollback()
+	e := &executor{tx: tx, ids: map[string]string{}, now: time.Now().Unix()}
+	results := make([]Result, 0, len(ops))
+	for i, op := range ops {
+		key := op.Set + "/" + op.Collection
+		res, herr := e.run(op, validators[key], checkers[key])
+		if herr != nil {
+			return nil, &Error{Index: i, Code: herr.Code, Message: herr.Message}
+		}
+		res.Index = i
+		results = append(results, res)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, &Error{Index: -1, Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+	return results, nil
+}
+
+// checkOperation validates an operation's shape before anything is written. refs collects
+// the names declared so far so that references to later or unknown creates are rejected.
+func checkOperation(op Operation, refs map[string]bool) error {
+	if op.Set == "" || op.Collection == "" {
+		return fmt.Errorf("set and collection are required")
+	}
+	if err := middleware.ValidateNames(op.Set, op.Collection); err != nil {
+		return err
+	}
+	switch op.Op {
+	case "create":
+		if op.IfMatch != nil {
+			return fmt.Errorf("if_match is not allowed on create")
+		}
+	case "replace", "patch", "delete":
+		if op.ID == "" {
+			return fmt.Errorf("id is required for %s", op.Op)
+		}
+		if op.Ref != "" {
+			return fmt.Errorf("ref is only allowed on create")
+		}
+	default:
+		return fmt.Errorf("op must be one of create, replace, patch, delete")
+	}
+	if op.Op != "delete" && op.Data == nil {
+		return fmt.Errorf("data is required for %s", op.Op)
+	}
+	if err := checkRefs(op.ID, refs); err != nil {
+		return err
+	}
+	if err := checkRefs(op.Data, refs); err != nil {
+		return err
+	}
+	if op.Op == "create" && op.ID != "" && !strings.HasPrefix(op.ID, refPrefix) && !idRe.MatchString(op.ID) {
+		return fmt.Errorf("invalid document id")
+	}
+	if op.Ref != "" {
+		if refs[op.Ref] {
+			return fmt.Errorf("duplicate ref %q", op.Ref)
+		}
+		refs[op.Ref] = true
+	}
+	return nil
+}
+
+// checkRefs walks v and fails on any reference to a ref not declared by an earlier create.
+func checkRefs(v any, refs map[string]bool) error {
+	switch t := v.(type) {
+	case string:
+		if name, ok := strings.CutPrefix(t, refPrefix); ok && !refs[name] {
+			return fmt.Errorf("unknown ref %q", name)
+		}
+	case map[string]any:
+		for _, c := range t {
+			if err := checkRefs(c, refs); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, c := range t {
+			if err := checkRefs(c, refs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// executor applies operations to an open transaction, remembering the ids of named creates.
+type executor struct {
+	tx  *sql.Tx
+	ids map[string]string
+	now int64
+}
+
+func (e *executor) run(op Operation, validator *validation.Validator, ck *rules.Checker) (Result, *middleware.HTTPError) {
+	id, _ := e.resolve(op.ID).(string)
+	data, _ := e.resolve(op.Data).(map[string]any)
+	if op.Op != "delete" {
+		if err := checkReserved(data); err != nil {
+			return Result{}, err
+		}
+	}
+	res := Result{Op: op.Op, Set: op.Set, Collection: op.Collection, ID: id}
+	table := "data_" + op.Set
+	switch op.Op {
+	case "create":
+		if err := validate(validator, data); err != nil {
+			return res, err
+		}
+		if err := e.allow(ck, rules.Create, nil, data); err != nil {
+			return res, err
+		}
+		if id == "" {
+			id = xid.New().String()
+		}
+		r, err := e.tx.Exec("INSERT INTO "+table+" (id, collection, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING", id, op.Collection, mustJSON(data), e.now, e.now)
+		if err != nil {
+			return res, internalErr(err)
+		}
+		if n, _ := r.RowsAffected(); n == 0 {
+			return res, &middleware.HTTPError{Code: http.StatusConflict, Message: "document id already in use"}
+		}
+		if op.Ref != "" {
+			e.ids[op.Ref] = id
+		}
+		res.ID, res.Version = id, 1
+	case "replace":
+		if err := validate(validator, data); err != nil {
+			return res, err
+		}
+		old, err := e.precondition(table, op, id)
+		if err != nil {
+			return res, err
+		}
+		if err := e.allow(ck, rules.Update, old, data); err != nil {
+			return res, err
+		}
+		if err := e.tx.QueryRow("UPDATE "+table+" SET data = ?, updated_at = ?, version = version + 1 WHERE id = ? AND collection = ? RETURNING version", mustJSON(data), e.now, id, op.Collection).Scan(&res.Version); err != nil {
+			return res, internalErr(err)
+		}
+	case "patch":
+		old, err := e.precondition(table, op, id)
+		if err != nil {
+			return res, err
+		}
+		updated, version, err := e.patch(table, op.Collection, id, data, validator)
+		if err != nil {
+			return res, err
+		}
+		if err := e.allow(ck, rules.Update, old, updated); err != nil {
+			return res, err
+		}
+		res.Version = version
+	case "delete":
+		old, err := e.precondition(table, op, id)
+		if err != nil {
+			return res, err
+		}
+		if err := e.allow(ck, rules.Delete, old, nil); err != nil {
+			return res, err
+		}
+		if _, err := e.tx.Exec("DELETE FROM "+table+" WHERE id = ? AND collection = ?", id, op.Collection); err != nil {
+			return res, internalErr(err)
+		}
+	}
+	return res, nil
+}
+
+// precondition fails with 404 when the target document is missing and with 412 when it
+// is not at the version required by if_match. It returns the stored document.
+func (e *executor) precondition(table string, op Operation, id string) (map[string]any, *middleware.HTTPError) {
+	var dataStr string
+	var version int64
+	err := e.tx.QueryRow("SELECT data, version FROM "+table+" WHERE id = ? AND collection = ?", id, op.Collection).Scan(&dataStr, &version)
+	if err == sql.ErrNoRows {
+		return nil, &middleware.HTTPError{Code: http.StatusNotFound, Message: "not found: " + id}
+	}
+	if err != nil {
+		return nil, internalErr(err)
+	}
+	if op.IfMatch != nil && *op.IfMatch != version {
+		return nil, &middleware.HTTPError{Code: http.StatusPreconditionFailed, Message: fmt.Sprintf("precondition failed: %s is at version %d", id, version)}
+	}
+	var m map[string]any
+	_ = json.Unmarshal([]byte(dataStr), &m)
+	return m, nil
+}
+
+// allow evaluates the collection's rule for op inside the transaction.
+func (e *executor) allow(ck *rules.Checker, op string, resource, incoming map[string]any) *middleware.HTTPError {
+	ok, err := ck.Allow(e.tx, op, resource, incoming)
+	if err != nil {
+		return internalErr(err)
+	}
+	if !ok {
+		return rules.Denied(op)
+	}
+	return nil
+}
+
+// patch applies update operators in SQL, or shallow-merges data into the stored document,
+// and returns the new document and version.
+func (e *executor) patch(table, collection, id string, data map[string]any, validator *validation.Validator) (map[string]any, int64, *middleware.HTTPError) {
+	var dataStr string
+	var version int64
+	if query.IsUpdate(data) {
+		upd, err := query.ParseUpdate(data)
+		if err != nil {
+			return nil, 0, &middleware.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+		for _, g := range upd.Guards {
+			var ok bool
+			if err := e.tx.QueryRow("SELECT "+g.SQL+" FROM "+table+" WHERE id = ? AND collection = ?", id, collection).Scan(&ok); err != nil {
+				return nil, 0, internalErr(err)
+			}
+			if !ok {
+				return nil, 0, &middleware.HTTPError{Code: http.StatusUnprocessableEntity, Message: g.Message}
+			}
+		}
+		args := append(append([]any{}, upd.Args...), e.now, id, collection)
+		if err := e.tx.QueryRow("UPDATE "+table+" SET data = "+upd.Expr+", updated_at = ?, version = version + 1 WHERE id = ? AND collection = ? RETURNING data, version", args...).Scan(&dataStr, &version); err != nil {
+			return nil, 0, internalErr(err)
+		}
+		var m map[string]any
+		_ = json.Unmarshal([]byte(dataStr), &m)
+		if err := validate(validator, m); err != nil {
+			return nil, 0, err
+		}
+		return m, version, nil
+	}
+	if err := e.tx.QueryRow("SELECT data FROM "+table+" WHERE id = ? AND collection = ?", id, collection).Scan(&dataStr); err != nil {
+		return nil, 0, internalErr(err)
+	}
+	var m map[string]any
+	_ = json.Unmarshal([]byte(dataStr), &m)
+	if m == nil {
+		m = map[string]any{}
+	}
+	for k, v := range data {
+		m[k] = v
+	}
+	if err := validate(validator, m); err != nil {
+		return nil, 0, err
+	}
+	if err := e.tx.QueryRow("UPDATE "+table+" SET data = ?, updated_at = ?, version = version + 1 WHERE id = ? AND collection = ? RETURNING version", mustJSON(m), e.now, id, collection).Scan(&version); err != nil {
+		return nil, 0, internalErr(err)
+	}
+	return m, version, nil
+}
+
+// resolve returns v with every "$ref:<name>" string replaced by the id created under that name.
+func (e *executor) resolve(v any) any {
+	switch t := v.(type) {
+	case string:
+		if name, ok := strings.CutPrefix(t, refPrefix); ok {
+			return e.ids[name]
+		}
+		return t
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, c := range t {
+			out[k] = e.resolve(c)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i, c := range t {
+			out[i] = e.resolve(c)
+		}
+		return out
+	}
+	return v
+}
+
+// checkReserved drops _meta and rejects any other top-level key starting with "_".
+func checkReserved(data map[string]any) *middleware.HTTPError {
+	delete(data, "_meta")
+	for k := range data {
+		if strings.HasPrefix(k, "_") {
+			return &middleware.HTTPError{Code: http.StatusBadRequest, Message: "fields starting with '_' are reserved"}
+		}
+	}
+	return nil
+}
+
+func validate(v *validation.Validator, doc map[string]any) *middleware.HTTPError {
+	if err := v.Validate(doc); err != nil {
+		return &middleware.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return nil
+}
+
+func internalErr(err error) *middleware.HTTPError {
+	if database.IsQuotaExceeded(err) {
+		return &middleware.HTTPError{Code: http.StatusInsufficientStorage, Message: "set quota exceeded"}
+	}
+	return &middleware.HTTPError{Code: http.StatusInternalServerError, Message: err.Error()}
+}
+
+func mustJSON(v any) string { b, _ := json.Marshal(v); return string(b) }
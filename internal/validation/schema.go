@@ -47,23 +47,51 @@ func DeleteSchema(db *sql.DB, set, collection string) error {
 
 // ValidateDocument validates the document against the stored JSON schema, if any.
 func ValidateDocument(db *sql.DB, set, collection string, doc map[string]any) error {
-	schemaBytes, err := GetSchemaJSON(db, set, collection)
+	v, err := NewValidator(db, set, collection)
 	if err != nil {
 		return err
 	}
+	return v.Validate(doc)
+}
+
+// Validator validates documents against a collection schema compiled once,
+// for callers checking many documents in a row.
+type Validator struct {
+	schema *jsonschema.Schema
+}
+
+// NewValidator compiles the stored JSON schema of a collection. Without a schema
+// the returned validator accepts every document.
+func NewValidator(db *sql.DB, set, collection string) (*Validator, error) {
+	schemaBytes, err := GetSchemaJSON(db, set, collection)
+	if err != nil {
+		return nil, err
+	}
 	if schemaBytes == nil {
-		return nil // no schema defined
+		return &Validator{}, nil // no schema defined
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schemaBytes))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
 	}
 	c := jsonschema.NewCompiler()
 	// add schema as an in-memory resource
-	if err := c.AddResource("mem://schema.json", bytes.NewReader(schemaBytes)); err != nil {
-		return fmt.Errorf("invalid JSON schema: %w", err)
+	if err := c.AddResource("mem://schema.json", doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
 	}
 	s, err := c.Compile("mem://schema.json")
 	if err != nil {
-		return fmt.Errorf("invalid JSON schema: %w", err)
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	return &Validator{schema: s}, nil
+}
+
+// Validate checks a document against the compiled schema.
+func (v *Validator) Validate(doc map[string]any) error {
+	if v.schema == nil {
+		return nil
 	}
-	if err := s.Validate(doc); err != nil {
+	if err := v.schema.Validate(doc); err != nil {
 		return fmt.Errorf("schema validation failed: %v", err)
 	}
 	return nil
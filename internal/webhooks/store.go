@@ -0,0 +1,177 @@
+// Package webhooks stores per-collection webhook registrations and delivers document
+// changes from the changelog to them.
+package webhooks
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// Events a webhook can subscribe to; they match the changelog ops.
+var Events = []string{"create", "update", "delete"}
+
+// ErrNotFound is returned for unknown hooks or deliveries.
+var ErrNotFound = errors.New("not found")
+
+// Hook is a webhook registration. Secret is only filled when a hook is created.
+type Hook struct {
+	ID         string   `json:"id"`
+	Set        string   `json:"set"`
+	Collection string   `json:"collection"`
+	URL        string   `json:"url"`
+	Events     []string `json:"events"`
+	Secret     string   `json:"secret,omitempty"`
+	CreatedAt  int64    `json:"created_at"`
+}
+
+// Delivery is one entry of a hook's delivery log.
+type Delivery struct {
+	ID            int64           `json:"id"`
+	HookID        string          `json:"hook_id"`
+	Seq           int64           `json:"seq"`
+	Event         string          `json:"event"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt int64           `json:"next_attempt_at,omitempty"`
+	ResponseCode  *int            `json:"response_code"`
+	LastError     *string         `json:"last_error"`
+	CreatedAt     int64           `json:"created_at"`
+	UpdatedAt     int64           `json:"updated_at"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// Create registers a hook for a collection. Only changes made after registration are
+// delivered. An empty secret is replaced by a random one; no events means all of them.
+func Create(db *sql.DB, set, collection, rawURL, secret string, events []string) (*Hook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url must be an absolute http(s) URL")
+	}
+	if len(events) == 0 {
+		events = Events
+	}
+	for _, e := range events {
+		if !slices.Contains(Events, e) {
+			return nil, fmt.Errorf("unsupported event %q: expected create, update or delete", e)
+		}
+	}
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(b)
+	}
+	h := &Hook{ID: xid.New().String(), Set: set, Collection: collection, URL: rawURL, Events: events, Secret: secret, CreatedAt: time.Now().Unix()}
+	_, err = db.Exec(`INSERT INTO webhooks (id, set_name, collection_name, url, secret, events, last_seq, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(seq), 0) FROM changelog), ?)`,
+		h.ID, set, collection, rawURL, secret, strings.Join(events, ","), h.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// List returns the hooks of a collection, oldest first.
+func List(db *sql.DB, set, collection string) ([]Hook, error) {
+	rows, err := db.Query(`SELECT id, url, events, created_at FROM webhooks WHERE set_name = ? AND collection_name = ? ORDER BY created_at, id`, set, collection)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := []Hook{}
+	for rows.Next() {
+		h := Hook{Set: set, Collection: collection}
+		var events string
+		if err := rows.Scan(&h.ID, &h.URL, &events, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		h.Events = strings.Split(events, ",")
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+// Get returns one hook of a collection.
+func Get(db *sql.DB, set, collection, id string) (*Hook, error) {
+	h := &Hook{ID: id, Set: set, Collection: collection}
+	var events string
+	err := db.QueryRow(`SELECT url, events, created_at FROM webhooks WHERE id = ? AND set_name = ? AND collection_name = ?`, id, set, collection).Scan(&h.URL, &events, &h.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	h.Events = strings.Split(events, ",")
+	return h, nil
+}
+
+// Delete removes a hook and its delivery log.
+func Delete(db *sql.DB, set, collection, id string) error {
+	res, err := db.Exec(`DELETE FROM webhooks WHERE id = ? AND set_name = ? AND collection_name = ?`, id, set, collection)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	_, err = db.Exec(`DELETE FROM webhook_deliveries WHERE hook_id = ?`, id)
+	return err
+}
+
+// Deliveries returns a hook's delivery log, newest first, optionally filtered by status.
+func Deliveries(db *sql.DB, hookID, status string, limit int) ([]Delivery, error) {
+	q := `SELECT id, hook_id, seq, event, status, attempts, next_attempt_at, response_code, last_error, created_at, updated_at, payload
+		FROM webhook_deliveries WHERE hook_id = ?`
+	args := []any{hookID}
+	if status != "" {
+		q += " AND status = ?"
+		args = append(args, status)
+	}
+	q += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		var payload string
+		if err := rows.Scan(&d.ID, &d.HookID, &d.Seq, &d.Event, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.ResponseCode, &d.LastError, &d.CreatedAt, &d.UpdatedAt, &payload); err != nil {
+			return nil, err
+		}
+		if d.Status != "pending" {
+			d.NextAttemptAt = 0
+		}
+		d.Payload = json.RawMessage(payload)
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// Retry puts a dead delivery back in the queue with a fresh attempt budget.
+func Retry(db *sql.DB, hookID string, deliveryID int64) error {
+	now := time.Now().Unix()
+	res, err := db.Exec(`UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = ?, updated_at = ? WHERE id = ? AND hook_id = ? AND status = 'dead'`,
+		now, now, deliveryID, hookID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pollInterval is how often the worker looks for new changes and due deliveries.
+	pollInterval = time.Second
+	// batchSize bounds the changes queued and the deliveries attempted per poll.
+	batchSize = 100
+	// MaxAttempts is how many times a delivery is tried before it is dead-lettered.
+	MaxAttempts = 8
+	// retryBase and retryMax shape the exponential backoff: 10s, 20s, 40s, ... up to 1h.
+	retryBase = 10 * time.Second
+	retryMax  = time.Hour
+)
+
+// Worker queues changelog entries for matching hooks and delivers them.
+type Worker struct {
+	db     *sql.DB
+	client *http.Client
+
+	mu sync.Mutex
+	// busy holds the hooks whose deliveries are being posted
+	busy map[string]bool
+	wg   sync.WaitGroup
+}
+
+// NewWorker returns a worker delivering with a 10 second timeout per request.
+func NewWorker(db *sql.DB) *Worker {
+	return &Worker{db: db, client: &http.Client{Timeout: 10 * time.Second}, busy: map[string]bool{}}
+}
+
+// Run polls until ctx is done.
+func (w *Worker) Run(ctx context.Context) {
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			w.wg.Wait()
+			return
+		case <-t.C:
+			if err := w.enqueue(); err != nil {
+				slog.Error("webhook_enqueue_error", slog.String("error", err.Error()))
+			}
+			if err := w.deliver(ctx); err != nil {
+				slog.Error("webhook_deliver_error", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// enqueue turns changelog entries past each hook's last_seq into pending deliveries.
+// Queueing and advancing last_seq share a transaction, so each change is queued once.
+func (w *Worker) enqueue() error {
+	rows, err := w.db.Query(`SELECT w.id, w.set_name, w.collection_name, w.events, w.last_seq FROM webhooks w
+		WHERE EXISTS (SELECT 1 FROM changelog c WHERE c.set_name = w.set_name AND c.collection_name = w.collection_name AND c.seq > w.last_seq)`)
+	if err != nil {
+		return err
+	}
+	type hookPos struct {
+		id, set, collection, events string
+		last                        int64
+	}
+	var hooks []hookPos
+	for rows.Next() {
+		var h hookPos
+		if err := rows.Scan(&h.id, &h.set, &h.collection, &h.events, &h.last); err != nil {
+			rows.Close()
+			return err
+		}
+		hooks = append(hooks, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	// the worker is the only writer of last_seq, so reading hooks outside the transaction is safe
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	for _, h := range hooks {
+		changes, err := tx.Query(`SELECT seq, doc_id, op, data, version, at FROM changelog WHERE set_name = ? AND collection_name = ? AND seq > ? ORDER BY seq LIMIT ?`,
+			h.set, h.collection, h.last, batchSize)
+		if err != nil {
+			return err
+		}
+		type change struct {
+			seq, version, at int64
+			id, op, data     string
+		}
+		var batch []change
+		for changes.Next() {
+			var c change
+			if err := changes.Scan(&c.seq, &c.id, &c.op, &c.data, &c.version, &c.at); err != nil {
+				changes.Close()
+				return err
+			}
+			batch = append(batch, c)
+		}
+		changes.Close()
+		last := h.last
+		for _, c := range batch {
+			last = c.seq
+			if !strings.Contains(","+h.events+",", ","+c.op+",") {
+				continue
+			}
+			payload := map[string]any{
+				"hook":       h.id,
+				"event":      c.op,
+				"set":        h.set,
+				"collection": h.collection,
+				"seq":        c.seq,
+				"id":         c.id,
+				"version":    c.version,
+				"at":         c.at,
+				"data":       json.RawMessage(c.data),
+			}
+			b, _ := json.Marshal(payload)
+			if _, err := tx.Exec(`INSERT INTO webhook_deliveries (hook_id, seq, event, payload, next_attempt_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				h.id, c.seq, c.op, string(b), now, now, now); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`UPDATE webhooks SET last_seq = ? WHERE id = ?`, last, h.id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deliver starts posting the due deliveries and records the outcomes. Each hook gets its
+// deliveries one at a time in changelog order, in a goroutine of its own that later polls
+// leave alone until it is done, so a slow endpoint holds up neither the other hooks nor
+// the queueing of new changes. After a failure the hook waits for that delivery's retry
+// before anything else is sent to it.
+func (w *Worker) deliver(ctx context.Context) error {
+	now := time.Now().Unix()
+	q := `SELECT d.id, d.hook_id, d.event, d.payload, d.attempts, h.url, h.secret FROM webhook_deliveries d
+		JOIN webhooks h ON h.id = d.hook_id
+		WHERE d.status = 'pending' AND d.next_attempt_at <= ?
+		AND NOT EXISTS (SELECT 1 FROM webhook_deliveries r WHERE r.hook_id = d.hook_id AND r.status = 'pending' AND r.next_attempt_at > ?)`
+	args := []any{now, now}
+	w.mu.Lock()
+	if len(w.busy) > 0 {
+		q += ` AND d.hook_id NOT IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(w.busy)), ", ") + `)`
+		for id := range w.busy {
+			args = append(args, id)
+		}
+	}
+	w.mu.Unlock()
+	rows, err := w.db.Query(q+` ORDER BY d.seq, d.id LIMIT ?`, append(args, batchSize)...)
+	if err != nil {
+		return err
+	}
+	type due struct {
+		id                          int64
+		event, payload, url, secret string
+		attempts                    int
+	}
+	byHook := map[string][]due{}
+	for rows.Next() {
+		var d due
+		var hookID string
+		if err := rows.Scan(&d.id, &hookID, &d.event, &d.payload, &d.attempts, &d.url, &d.secret); err != nil {
+			rows.Close()
+			return err
+		}
+		byHook[hookID] = append(byHook[hookID], d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for hookID, queue := range byHook {
+		w.mu.Lock()
+		w.busy[hookID] = true
+		w.mu.Unlock()
+		w.wg.Add(1)
+		go func() {
+			defer func() {
+				w.mu.Lock()
+				delete(w.busy, hookID)
+				w.mu.Unlock()
+				w.wg.Done()
+			}()
+			for _, d := range queue {
+				code, err := w.post(ctx, d.url, d.secret, d.id, d.event, []byte(d.payload))
+				w.record(d.id, d.attempts+1, code, err)
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// post sends one signed delivery and returns the response status.
+func (w *Worker) post(ctx context.Context, url, secret string, id int64, event string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "microapi-webhooks")
+	req.Header.Set("X-MicroAPI-Event", event)
+	req.Header.Set("X-MicroAPI-Delivery", strconv.FormatInt(id, 10))
+	req.Header.Set("X-MicroAPI-Signature", "sha256="+Sign(secret, body))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// record stores the outcome of an attempt: delivered, retried later, or dead-lettered.
+func (w *Worker) record(id int64, attempts, code int, deliverErr error) {
+	now := time.Now()
+	var codeArg any
+	if code != 0 {
+		codeArg = code
+	}
+	var err error
+	switch {
+	case deliverErr == nil:
+		_, err = w.db.Exec(`UPDATE webhook_deliveries SET status = 'delivered', attempts = ?, response_code = ?, last_error = NULL, updated_at = ? WHERE id = ?`,
+			attempts, codeArg, now.Unix(), id)
+	case attempts >= MaxAttempts:
+		_, err = w.db.Exec(`UPDATE webhook_deliveries SET status = 'dead', attempts = ?, response_code = ?, last_error = ?, updated_at = ? WHERE id = ?`,
+			attempts, codeArg, deliverErr.Error(), now.Unix(), id)
+	default:
+		_, err = w.db.Exec(`UPDATE webhook_deliveries SET attempts = ?, response_code = ?, last_error = ?, next_attempt_at = ?, updated_at = ? WHERE id = ?`,
+			attempts, codeArg, deliverErr.Error(), now.Add(Backoff(attempts)).Unix(), now.Unix(), id)
+	}
+	if err != nil {
+		slog.Error("webhook_record_error", slog.Int64("delivery", id), slog.String("error", err.Error()))
+	}
+}
+
+// Backoff returns the wait before the attempt following the given number of failures.
+func Backoff(failures int) time.Duration {
+	d := retryBase
+	for i := 1; i < failures && d < retryMax; i++ {
+		d *= 2
+	}
+	return min(d, retryMax)
+}
+
+// Sign returns the hex HMAC-SHA256 of body under secret, sent as X-MicroAPI-Signature.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,158 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"microapi/internal/config"
+	"microapi/internal/database"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.Open(&config.Config{DBPath: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// addHook registers a hook posting to url with n due deliveries.
+func addHook(t *testing.T, db *sql.DB, id, url string, n int) {
+	t.Helper()
+	if _, err := db.Exec(`INSERT INTO webhooks (id, set_name, collection_name, url, secret, events, last_seq, created_at) VALUES (?, 's', ?, ?, 'secret', 'create', 0, 0)`, id, id, url); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= n; i++ {
+		if _, err := db.Exec(`INSERT INTO webhook_deliveries (hook_id, seq, event, payload, next_attempt_at, created_at, updated_at) VALUES (?, ?, 'create', '{}', 0, 0, 0)`, id, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// counts returns the attempts of each delivery of a hook, in order, and how many are delivered.
+func counts(t *testing.T, db *sql.DB, hook string) (attempts []int, delivered int) {
+	t.Helper()
+	rows, err := db.Query(`SELECT attempts, status FROM webhook_deliveries WHERE hook_id = ? ORDER BY seq`, hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a int
+		var status string
+		if err := rows.Scan(&a, &status); err != nil {
+			t.Fatal(err)
+		}
+		attempts = append(attempts, a)
+		if status == "delivered" {
+			delivered++
+		}
+	}
+	return attempts, delivered
+}
+
+func TestDeliverStopsHookAfterFailure(t *testing.T) {
+	db := openDB(t)
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	addHook(t, db, "failing", srv.URL, 3)
+
+	w := NewWorker(db)
+	for range 2 {
+		if err := w.deliver(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		w.wg.Wait()
+	}
+	// the second poll finds the hook waiting for its retry
+	if n := hits.Load(); n != 1 {
+		t.Errorf("endpoint hit %d times, want 1", n)
+	}
+	attempts, _ := counts(t, db, "failing")
+	if want := []int{1, 0, 0}; !reflect.DeepEqual(attempts, want) {
+		t.Errorf("attempts = %v, want %v", attempts, want)
+	}
+}
+
+func TestDeliverDoesNotWaitForSlowHooks(t *testing.T) {
+	db := openDB(t)
+	release := make(chan struct{})
+	var slowHits atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowHits.Add(1)
+		<-release
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fast.Close()
+	addHook(t, db, "slow", slow.URL, 2)
+	addHook(t, db, "fast", fast.URL, 2)
+
+	w := NewWorker(db)
+	ctx := context.Background()
+	start := time.Now()
+	if err := w.deliver(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("deliver took %s", d)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, delivered := counts(t, db, "fast"); delivered == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the fast hook was not served while the slow one was busy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// a later poll leaves the busy hook's deliveries to its goroutine
+	if err := w.deliver(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := slowHits.Load(); n != 1 {
+		t.Errorf("slow endpoint hit %d times while busy, want 1", n)
+	}
+	close(release)
+	w.wg.Wait()
+	if _, delivered := counts(t, db, "slow"); delivered != 2 {
+		t.Errorf("slow hook delivered %d, want 2", delivered)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{4, 80 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{30, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.failures); got != tt.want {
+			t.Errorf("Backoff(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}